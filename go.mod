@@ -4,10 +4,12 @@ go 1.21.4
 
 require (
 	github.com/go-chi/chi/v5 v5.0.10
+	github.com/golang-jwt/jwt/v5 v5.1.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/joho/godotenv v1.5.1
+	github.com/rivo/uniseg v0.4.7
 	golang.org/x/crypto v0.15.0
+	golang.org/x/text v0.14.0
 )
 
-require (
-	github.com/golang-jwt/jwt/v5 v5.1.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-)
+require golang.org/x/sys v0.14.0 // indirect
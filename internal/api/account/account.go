@@ -0,0 +1,292 @@
+package account
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/chirp"
+	"github.com/jbdoumenjou/mygoserver/internal/api/user"
+	"github.com/jbdoumenjou/mygoserver/internal/blob"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/password"
+	"github.com/jbdoumenjou/mygoserver/internal/search"
+)
+
+type AccountStorer interface {
+	user.UserStorer
+	chirp.ChirpStorer
+	ListPersonalAccessTokens(userID int) ([]db.PersonalAccessToken, error)
+	ListSessions(userID int) ([]db.Session, error)
+	DeleteUser(id int, chirpPolicy string) (db.AccountDeletion, []db.Chirp, error)
+	GetAccountDeletion(userID int) (*db.AccountDeletion, error)
+	ListAccountDeletions() ([]db.AccountDeletion, error)
+	ListMedia(ownerID int) ([]db.Media, error)
+	GetMedia(id int) (*db.Media, error)
+}
+
+type Handler struct {
+	db          AccountStorer
+	blobs       blob.BlobStore
+	passwords   *password.Manager
+	chirpPolicy string
+	index       *search.Index
+	listeners   []chirp.Listener
+}
+
+// NewHandler returns a new handler.
+// chirpPolicy is applied to the chirps of deleted accounts, see db.ChirpsDelete and db.ChirpsAnonymize.
+// Chirps are deleted by default.
+func NewHandler(store AccountStorer, blobs blob.BlobStore, passwords *password.Manager, chirpPolicy string) *Handler {
+	if chirpPolicy == "" {
+		chirpPolicy = db.ChirpsDelete
+	}
+
+	return &Handler{db: store, blobs: blobs, passwords: passwords, chirpPolicy: chirpPolicy}
+}
+
+// WithSearchIndex sets the search index the chirps of the deleted accounts are removed from.
+func (h *Handler) WithSearchIndex(index *search.Index) *Handler {
+	h.index = index
+	return h
+}
+
+// WithListener adds a listener told about the chirps deleted with the accounts.
+func (h *Handler) WithListener(listener chirp.Listener) *Handler {
+	h.listeners = append(h.listeners, listener)
+	return h
+}
+
+type Profile struct {
+	ID           int              `json:"id"`
+	Email        string           `json:"email"`
+	IsChirpyRed  bool             `json:"is_chirpy_red"`
+	Subscription *db.Subscription `json:"subscription,omitempty"`
+}
+
+type Sessions struct {
+	Sessions             []db.Session    `json:"sessions"`
+	PersonalAccessTokens []TokenMetadata `json:"personal_access_tokens"`
+}
+
+// TokenMetadata describes a personal access token without its hash.
+type TokenMetadata struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Export returns a zip archive of the data of the authenticated user:
+// profile.json, chirps.json, chirp_revisions.json and sessions.json.
+// It holds personal data and cannot be done with a personal access token.
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if principal.PersonalAccessToken {
+		api.RespondWithError(w, http.StatusForbidden, "personal access tokens cannot export accounts")
+		return
+	}
+
+	u, err := h.db.GetUser(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	chirps, err := h.db.ListChirps(principal.UserID, 0, chirp.SortAsc)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sessions, err := h.db.ListSessions(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	pats, err := h.db.ListPersonalAccessTokens(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tokens := make([]TokenMetadata, 0, len(pats))
+	for _, pat := range pats {
+		tokens = append(tokens, TokenMetadata{
+			ID:         pat.ID,
+			Name:       pat.Name,
+			Scopes:     pat.Scopes,
+			CreatedAt:  pat.CreatedAt,
+			LastUsedAt: pat.LastUsedAt,
+			RevokedAt:  pat.RevokedAt,
+		})
+	}
+
+	if chirps == nil {
+		chirps = []db.Chirp{}
+	}
+
+	revisions := map[int][]db.ChirpRevision{}
+	for _, c := range chirps {
+		chirpRevisions, err := h.db.ListChirpRevisions(c.ID)
+		if err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(chirpRevisions) > 0 {
+			revisions[c.ID] = chirpRevisions
+		}
+	}
+
+	files := []struct {
+		name    string
+		content any
+	}{
+		{
+			name: "profile.json",
+			content: Profile{
+				ID:           u.ID,
+				Email:        u.Email,
+				IsChirpyRed:  u.IsChirpyRed(),
+				Subscription: u.Subscription,
+			},
+		},
+		{name: "chirps.json", content: chirps},
+		{name: "chirp_revisions.json", content: revisions},
+		{name: "sessions.json", content: Sessions{Sessions: sessions, PersonalAccessTokens: tokens}},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%d.zip"`, u.ID))
+	w.WriteHeader(http.StatusOK)
+
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := archive.Create(file.name)
+		if err != nil {
+			log.Printf("export user %d: %v", u.ID, err)
+			return
+		}
+
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.content); err != nil {
+			log.Printf("export user %d: %v", u.ID, err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Printf("export user %d: %v", u.ID, err)
+	}
+}
+
+type DeleteParameters struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+// Delete deletes the account of the authenticated user.
+// It requires the current password and cannot be done with a personal access token.
+// Retrying the deletion with the access token of the deleted account returns its audit record,
+// the route must then allow the deleted accounts, see api.Authenticator.AllowDeleted.
+func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if principal.PersonalAccessToken {
+		api.RespondWithError(w, http.StatusForbidden, "personal access tokens cannot delete accounts")
+		return
+	}
+	if principal.Deleted {
+		deletion, err := h.db.GetAccountDeletion(principal.UserID)
+		if err != nil {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithJSON(w, http.StatusOK, deletion)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := DeleteParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := h.db.GetUser(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if _, err := h.passwords.Verify(u.Password, params.CurrentPassword); err != nil {
+		api.RespondWithError(w, http.StatusForbidden, "invalid current password")
+		return
+	}
+
+	media, err := h.db.ListMedia(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	deletion, deleted, err := h.db.DeleteUser(principal.UserID, h.chirpPolicy)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, c := range deleted {
+		if h.index != nil {
+			h.index.Remove(c.ID)
+		}
+		for _, listener := range h.listeners {
+			listener.ChirpDeleted(c)
+		}
+	}
+
+	for _, m := range media {
+		if _, err := h.db.GetMedia(m.ID); err == nil {
+			// kept as the attachment of an anonymized chirp.
+			continue
+		}
+		for _, key := range []string{m.Key, m.ThumbnailKey} {
+			if err := h.blobs.Delete(key); err != nil {
+				log.Printf("delete blob %s of account %d: %v", key, deletion.UserID, err)
+			}
+		}
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, deletion)
+}
+
+// Deletions returns the audit records of the deleted accounts, the most recent first.
+func (h *Handler) Deletions(w http.ResponseWriter, r *http.Request) {
+	deletions, err := h.db.ListAccountDeletions()
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, deletions)
+}
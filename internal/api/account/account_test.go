@@ -0,0 +1,102 @@
+package account
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/apitest"
+	"github.com/jbdoumenjou/mygoserver/internal/blob"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/password"
+	"github.com/jbdoumenjou/mygoserver/internal/search"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *db.DB, db.User) {
+	t.Helper()
+	store := apitest.NewDB(t)
+	passwords := password.NewManager(password.NewBcrypt(4))
+	hash, err := passwords.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := store.CreateUser("leaving@example.com", hash)
+	if err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+
+	return NewHandler(store, blob.NewFileStore(t.TempDir()), passwords, db.ChirpsDelete), store, u
+}
+
+type deletedChirps []int
+
+func (d *deletedChirps) ChirpCreated(db.Chirp) {}
+
+func (d *deletedChirps) ChirpDeleted(chirp db.Chirp) { *d = append(*d, chirp.ID) }
+
+func TestHandler_Delete(t *testing.T) {
+	h, store, u := newTestHandler(t)
+	principal := api.Principal{UserID: u.ID}
+	if _, err := store.CreateChirp(db.Chirp{Body: "goodbye", AuthorID: u.ID}); err != nil {
+		t.Fatal(err)
+	}
+	index := search.NewIndex()
+	index.Add(1, "goodbye")
+	var deleted deletedChirps
+	h.WithSearchIndex(index).WithListener(&deleted)
+
+	if rw := apitest.ServeAs(h.Delete, http.MethodDelete, "/api/users/me", `{"current_password":"secret"}`, api.Principal{UserID: u.ID, PersonalAccessToken: true}); rw.Code != http.StatusForbidden {
+		t.Errorf("Expected a personal access token to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.ServeAs(h.Delete, http.MethodDelete, "/api/users/me", `{"current_password":"wrong"}`, principal); rw.Code != http.StatusForbidden {
+		t.Errorf("Expected a wrong password to be rejected, got %d", rw.Code)
+	}
+
+	rw := apitest.ServeAs(h.Delete, http.MethodDelete, "/api/users/me", `{"current_password":"secret"}`, principal)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rw.Code, rw.Body.String())
+	}
+	var deletion db.AccountDeletion
+	if err := json.Unmarshal(rw.Body.Bytes(), &deletion); err != nil {
+		t.Fatal(err)
+	}
+
+	// the deleted chirps leave the index and are announced.
+	if hits := index.Search(search.ParseQuery("goodbye"), search.OrderRelevance); len(hits) != 0 {
+		t.Errorf("Expected the deleted chirp to leave the index, got %v", hits)
+	}
+	if len(deleted) != 1 || deleted[0] != 1 {
+		t.Errorf("Expected the deleted chirp to be announced, got %v", deleted)
+	}
+
+	// a retried request returns the audit record of the deletion.
+	rw = apitest.ServeAs(h.Delete, http.MethodDelete, "/api/users/me", "", api.Principal{UserID: u.ID, Deleted: true})
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected the deletion to be idempotent, got %d: %s", rw.Code, rw.Body.String())
+	}
+	var again db.AccountDeletion
+	if err := json.Unmarshal(rw.Body.Bytes(), &again); err != nil {
+		t.Fatal(err)
+	}
+	if !again.DeletedAt.Equal(deletion.DeletedAt) || again.UserID != u.ID {
+		t.Errorf("Expected the original audit record %+v, got %+v", deletion, again)
+	}
+
+	deletions, err := store.ListAccountDeletions()
+	if err != nil || len(deletions) != 1 || deletions[0].UserID != u.ID {
+		t.Errorf("ListAccountDeletions() = %v, %v, want the deletion of %d", deletions, err, u.ID)
+	}
+}
+
+func TestHandler_Export(t *testing.T) {
+	h, _, u := newTestHandler(t)
+
+	if rw := apitest.ServeAs(h.Export, http.MethodGet, "/api/users/me", "", api.Principal{UserID: u.ID, PersonalAccessToken: true}); rw.Code != http.StatusForbidden {
+		t.Errorf("Expected a personal access token to be rejected, got %d", rw.Code)
+	}
+	rw := apitest.ServeAs(h.Export, http.MethodGet, "/api/users/me", "", api.Principal{UserID: u.ID})
+	if rw.Code != http.StatusOK || rw.Header().Get("Content-Type") != "application/zip" {
+		t.Errorf("Expected a zip archive, got %d %q", rw.Code, rw.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,64 @@
+// Package apitest provides utilities for testing the API handlers.
+package apitest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+// NewDB returns a database in a temporary directory, holding a user for each email.
+// The users get their ids in the order of the emails, starting at 1.
+func NewDB(t *testing.T, emails ...string) *db.DB {
+	t.Helper()
+	store, err := db.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	for _, email := range emails {
+		if _, err := store.CreateUser(email, "hash"); err != nil {
+			t.Fatalf("CreateUser should not have an error %v", err)
+		}
+	}
+	return store
+}
+
+// NewRequest returns a request as the router passes it to a handler: on behalf of the principal,
+// unless its user id is 0, and with the URL parameters given as key and value pairs.
+func NewRequest(method, target string, body io.Reader, principal api.Principal, params ...string) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	ctx := req.Context()
+	if principal.UserID != 0 {
+		ctx = api.WithPrincipal(ctx, principal)
+	}
+	routeCtx := chi.NewRouteContext()
+	for i := 0; i+1 < len(params); i += 2 {
+		routeCtx.URLParams.Add(params[i], params[i+1])
+	}
+	return req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, routeCtx))
+}
+
+// Record calls the handler with the request and returns its response.
+func Record(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	handler(rw, req)
+	return rw
+}
+
+// Serve calls the handler on behalf of the user, unless 0, with the URL parameters given as key and value pairs.
+func Serve(handler http.HandlerFunc, method, target, body string, userID int, params ...string) *httptest.ResponseRecorder {
+	return ServeAs(handler, method, target, body, api.Principal{UserID: userID}, params...)
+}
+
+// ServeAs calls the handler on behalf of the principal, with the URL parameters given as key and value pairs.
+func ServeAs(handler http.HandlerFunc, method, target, body string, principal api.Principal, params ...string) *httptest.ResponseRecorder {
+	return Record(handler, NewRequest(method, target, strings.NewReader(body), principal, params...))
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID int
+	Roles  []string
+	// TokenID identifies the token: the jti of an access token, the id of a personal access token.
+	TokenID string
+	Scopes  []string
+	// ExpiresAt is when the token expires, the zero time for a personal access token.
+	ExpiresAt time.Time
+	// PersonalAccessToken is true when the caller authenticated with a personal access token.
+	PersonalAccessToken bool
+	// Deleted is true when the account of the caller has been deleted since the token was issued.
+	// Only the authenticators allowing deleted accounts let such a caller through.
+	Deleted bool
+}
+
+// HasScope reports whether the principal has been granted the scope.
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// HasRole reports whether the principal has the given role.
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored in ctx, if any.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// AuthStorer looks up the users and the personal access tokens.
+type AuthStorer interface {
+	GetPersonalAccessTokenByHash(hash string) (*db.PersonalAccessToken, error)
+	GetUser(id int) (*db.User, error)
+	GetAccountDeletion(userID int) (*db.AccountDeletion, error)
+	ListPersonalAccessTokens(userID int) ([]db.PersonalAccessToken, error)
+}
+
+// ErrSuspended is returned when the owner of a valid token is suspended.
+var ErrSuspended = errors.New("account suspended")
+
+// Authenticator validates bearer tokens and stores the principal in the request context.
+// Both the access tokens issued by the token.Manager and personal access tokens are accepted.
+// The tokens of suspended users are rejected.
+type Authenticator struct {
+	tokenManager   *token.Manager
+	db             AuthStorer
+	allowSuspended bool
+	allowDeleted   bool
+}
+
+// NewAuthenticator returns a new authenticator.
+func NewAuthenticator(tokenManager *token.Manager, db AuthStorer) *Authenticator {
+	return &Authenticator{tokenManager: tokenManager, db: db}
+}
+
+// AllowSuspended returns an authenticator that also accepts the tokens of suspended users,
+// to let them see and appeal their suspension.
+func (a *Authenticator) AllowSuspended() *Authenticator {
+	allowed := *a
+	allowed.allowSuspended = true
+	return &allowed
+}
+
+// AllowDeleted returns an authenticator that also accepts the access tokens of deleted accounts,
+// to let a deletion be retried. Their principal is marked as Deleted.
+// The personal access tokens are revoked with the account, and stay rejected.
+func (a *Authenticator) AllowDeleted() *Authenticator {
+	allowed := *a
+	allowed.allowDeleted = true
+	return &allowed
+}
+
+// Required rejects requests without a valid access token.
+func (a *Authenticator) Required(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if err != nil {
+			respondAuthError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// Optional stores the principal when a valid access token is provided,
+// and lets anonymous requests through.
+// A request with an invalid token is still rejected.
+func (a *Authenticator) Optional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := a.authenticate(r)
+		if err != nil {
+			respondAuthError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// respondAuthError rejects a request whose token is invalid, or whose owner is suspended.
+func respondAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrSuspended) {
+		RespondWithError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	RespondWithError(w, http.StatusUnauthorized, err.Error())
+}
+
+// RequireScope rejects authenticated requests whose principal lacks the scope.
+// Anonymous requests are left to the Required and Optional middlewares.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if ok && !principal.HasScope(scope) {
+				RespondWithError(w, http.StatusForbidden, "missing scope "+scope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireRole rejects the requests whose principal lacks the role.
+// It must be used after the Required middleware.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			if !principal.HasRole(role) {
+				RespondWithError(w, http.StatusForbidden, "missing role "+role)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (Principal, error) {
+	if raw, ok := token.GetBearer(r.Header.Get("Authorization")); ok && token.IsPersonalAccessToken(raw) {
+		return a.authenticatePersonalAccessToken(raw)
+	}
+
+	accessToken, err := a.tokenManager.GetAccessToken(r.Header)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	userID, err := a.tokenManager.GetUserID(accessToken)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	// the tokens of a deleted user must not outlive the account,
+	// except to retry its deletion.
+	user, err := a.db.GetUser(userID)
+	if err != nil {
+		if !a.allowDeleted {
+			return Principal{}, errors.New("invalid token")
+		}
+		if _, err := a.db.GetAccountDeletion(userID); err != nil {
+			return Principal{}, errors.New("invalid token")
+		}
+		return Principal{
+			UserID:    userID,
+			TokenID:   a.tokenManager.GetTokenID(accessToken),
+			Scopes:    token.Scopes,
+			ExpiresAt: a.tokenManager.GetExpiresAt(accessToken),
+			Deleted:   true,
+		}, nil
+	}
+	if user.IsSuspended() && !a.allowSuspended {
+		return Principal{}, ErrSuspended
+	}
+
+	return Principal{
+		UserID:    userID,
+		Roles:     a.tokenManager.GetRoles(accessToken),
+		TokenID:   a.tokenManager.GetTokenID(accessToken),
+		Scopes:    token.Scopes,
+		ExpiresAt: a.tokenManager.GetExpiresAt(accessToken),
+	}, nil
+}
+
+func (a *Authenticator) authenticatePersonalAccessToken(raw string) (Principal, error) {
+	pat, err := a.db.GetPersonalAccessTokenByHash(token.HashPersonalAccessToken(raw))
+	if err != nil {
+		return Principal{}, errors.New("invalid token")
+	}
+	if pat.RevokedAt != nil {
+		return Principal{}, errors.New("token revoked")
+	}
+	if user, err := a.db.GetUser(pat.UserID); err == nil && user.IsSuspended() && !a.allowSuspended {
+		return Principal{}, ErrSuspended
+	}
+
+	return Principal{
+		UserID:              pat.UserID,
+		TokenID:             strconv.Itoa(pat.ID),
+		Scopes:              pat.Scopes,
+		PersonalAccessToken: true,
+	}, nil
+}
+
+// Check reports whether an authenticated principal is still valid:
+// its token has neither expired nor been revoked, and its account is neither deleted nor suspended.
+// The long-lived connections check it periodically.
+func (a *Authenticator) Check(principal Principal) error {
+	if !principal.ExpiresAt.IsZero() && !time.Now().Before(principal.ExpiresAt) {
+		return errors.New("token expired")
+	}
+
+	user, err := a.db.GetUser(principal.UserID)
+	if err != nil {
+		return errors.New("invalid token")
+	}
+	if user.IsSuspended() && !a.allowSuspended {
+		return ErrSuspended
+	}
+
+	if !principal.PersonalAccessToken {
+		return nil
+	}
+	pats, err := a.db.ListPersonalAccessTokens(principal.UserID)
+	if err != nil {
+		return err
+	}
+	for _, pat := range pats {
+		if strconv.Itoa(pat.ID) == principal.TokenID && pat.RevokedAt == nil {
+			return nil
+		}
+	}
+	return errors.New("token revoked")
+}
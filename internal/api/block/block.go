@@ -0,0 +1,274 @@
+package block
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/timeline"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+const (
+	listBlocks = "blocks"
+	listMutes  = "mutes"
+)
+
+// BlockStorer stores the blocks and the mutes between users.
+type BlockStorer interface {
+	// BlockUser is idempotent, created reports whether the block is new.
+	BlockUser(blockerID, blockedID int) (block db.Block, created bool, err error)
+	// UnblockUser is idempotent, deleted reports whether there was a block to remove.
+	UnblockUser(blockerID, blockedID int) (deleted bool, err error)
+	// MuteUser is idempotent, created reports whether the mute is new.
+	MuteUser(muterID, mutedID int) (mute db.Mute, created bool, err error)
+	// UnmuteUser is idempotent, deleted reports whether there was a mute to remove.
+	UnmuteUser(muterID, mutedID int) (deleted bool, err error)
+	// ListBlocks and ListMutes return the blocks and mutes the most recent first, before the id beforeID if not 0.
+	ListBlocks(userID, beforeID, limit int) ([]db.Block, bool, error)
+	ListMutes(userID, beforeID, limit int) ([]db.Mute, bool, error)
+	// IsBlocked reports whether one of the users blocks the other.
+	IsBlocked(userID, otherID int) (bool, error)
+	// ListHiddenAuthorIDs returns the users blocked by, blocking or muted by a viewer.
+	ListHiddenAuthorIDs(viewerID int) ([]int, error)
+}
+
+type Storer interface {
+	BlockStorer
+	GetUser(id int) (*db.User, error)
+}
+
+type Handler struct {
+	db        Storer
+	cursors   *cursor.Signer
+	timelines *timeline.Timeline
+}
+
+// NewHandler returns a new handler.
+// The cached timelines of both users are invalidated when one blocks the other.
+func NewHandler(db Storer, cursors *cursor.Signer, timelines *timeline.Timeline) *Handler {
+	return &Handler{db: db, cursors: cursors, timelines: timelines}
+}
+
+// BlockStateResponse is the block state of a user for the authenticated user.
+type BlockStateResponse struct {
+	UserID      int  `json:"user_id"`
+	BlockedByMe bool `json:"blocked_by_me"`
+}
+
+// MuteStateResponse is the mute state of a user for the authenticated user.
+type MuteStateResponse struct {
+	UserID    int  `json:"user_id"`
+	MutedByMe bool `json:"muted_by_me"`
+}
+
+// UserResponse is a user of a block or mute list.
+type UserResponse struct {
+	db.PublicProfile
+	Since time.Time `json:"since"`
+}
+
+// UsersResponse is a page of blocked or muted users.
+type UsersResponse struct {
+	Users      []UserResponse `json:"users"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// listCursor is the position in a block or mute list.
+type listCursor struct {
+	UserID int    `json:"user_id"`
+	List   string `json:"list"`
+	ID     int    `json:"id"`
+}
+
+// Block makes the authenticated user block a user. Blocking a user twice is a no-op.
+func (h *Handler) Block(w http.ResponseWriter, r *http.Request) {
+	h.setBlock(w, r, true)
+}
+
+// Unblock makes the authenticated user unblock a user. Unblocking a user not blocked is a no-op.
+func (h *Handler) Unblock(w http.ResponseWriter, r *http.Request) {
+	h.setBlock(w, r, false)
+}
+
+func (h *Handler) setBlock(w http.ResponseWriter, r *http.Request, blocked bool) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var changed bool
+	if blocked {
+		_, changed, err = h.db.BlockUser(principal.UserID, userID)
+	} else {
+		changed, err = h.db.UnblockUser(principal.UserID, userID)
+	}
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	// the follows between the users are removed with the block.
+	if changed && h.timelines != nil {
+		h.timelines.Invalidate(principal.UserID)
+		h.timelines.Invalidate(userID)
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, BlockStateResponse{UserID: userID, BlockedByMe: blocked})
+}
+
+// Mute makes the authenticated user mute a user. Muting a user twice is a no-op.
+// The muted user is not told.
+func (h *Handler) Mute(w http.ResponseWriter, r *http.Request) {
+	h.setMute(w, r, true)
+}
+
+// Unmute makes the authenticated user unmute a user. Unmuting a user not muted is a no-op.
+func (h *Handler) Unmute(w http.ResponseWriter, r *http.Request) {
+	h.setMute(w, r, false)
+}
+
+func (h *Handler) setMute(w http.ResponseWriter, r *http.Request, muted bool) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if muted {
+		_, _, err = h.db.MuteUser(principal.UserID, userID)
+	} else {
+		_, err = h.db.UnmuteUser(principal.UserID, userID)
+	}
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, MuteStateResponse{UserID: userID, MutedByMe: muted})
+}
+
+func respondError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, db.ErrSelfBlock), errors.Is(err, db.ErrSelfMute):
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, db.ErrNotFound):
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// Blocks returns a page of the users blocked by the authenticated user, the most recent first.
+func (h *Handler) Blocks(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, listBlocks)
+}
+
+// Mutes returns a page of the users muted by the authenticated user, the most recent first.
+func (h *Handler) Mutes(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, listMutes)
+}
+
+// entry is a user of a block or mute list.
+type entry struct {
+	id     int
+	userID int
+	since  time.Time
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, list string) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Before != "" {
+		api.RespondWithError(w, http.StatusBadRequest, "before is not supported by "+list)
+		return
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+
+	beforeID := 0
+	if params.After != "" {
+		var position listCursor
+		if err := h.cursors.Decode(params.After, &position); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if position.UserID != principal.UserID || position.List != list {
+			api.RespondWithError(w, http.StatusBadRequest, "cursor does not match the list")
+			return
+		}
+		beforeID = position.ID
+	}
+
+	var entries []entry
+	var more bool
+	if list == listBlocks {
+		var blocks []db.Block
+		blocks, more, err = h.db.ListBlocks(principal.UserID, beforeID, limit)
+		for _, block := range blocks {
+			entries = append(entries, entry{id: block.ID, userID: block.BlockedID, since: block.CreatedAt})
+		}
+	} else {
+		var mutes []db.Mute
+		mutes, more, err = h.db.ListMutes(principal.UserID, beforeID, limit)
+		for _, mute := range mutes {
+			entries = append(entries, entry{id: mute.ID, userID: mute.MutedID, since: mute.CreatedAt})
+		}
+	}
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := UsersResponse{Users: make([]UserResponse, 0, len(entries))}
+	for _, e := range entries {
+		// the blocks and mutes of deleted users are deleted with them.
+		user, err := h.db.GetUser(e.userID)
+		if err != nil {
+			continue
+		}
+		resp.Users = append(resp.Users, UserResponse{PublicProfile: user.PublicProfile(), Since: e.since})
+	}
+	if more {
+		position := listCursor{UserID: principal.UserID, List: list, ID: entries[len(entries)-1].id}
+		if resp.NextCursor, err = h.cursors.Encode(position); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, "")
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
@@ -2,14 +2,26 @@ package chirp
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/api/like"
 	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/blob"
 	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/moderation"
+	"github.com/jbdoumenjou/mygoserver/internal/search"
+	"github.com/jbdoumenjou/mygoserver/internal/timeline"
+	"github.com/rivo/uniseg"
 )
 
 const (
@@ -17,39 +29,324 @@ const (
 	SortDesc = "desc"
 )
 
+// maxChirpLength is the maximum length of a chirp body, in grapheme clusters.
+const maxChirpLength = 140
+
+// DefaultEditWindow is how long after their creation the chirps can be edited by default.
+const DefaultEditWindow = 15 * time.Minute
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+const (
+	maxAttachments          = 4
+	maxAttachmentsChirpyRed = 8
+	maxAltTextLength        = 1000
+)
+
 type ChirpStorer interface {
-	CreateChirp(body string, authorID int) (db.Chirp, error)
-	ListChirps(authorId int, sort string) ([]db.Chirp, error)
+	CreateChirp(chirp db.Chirp) (db.Chirp, error)
+	Rechirp(userID, chirpID int) (rechirp db.Chirp, created bool, err error)
+	// ListChirps and ListChirpsPage leave out the chirps of the users the viewer blocked, muted or is blocked by.
+	ListChirps(authorId, viewerID int, sort string) ([]db.Chirp, error)
+	ListChirpsPage(page db.ChirpPage) ([]db.Chirp, bool, error)
 	GetChirp(id int) (*db.Chirp, error)
-	DeleteChirp(id int)
+	DeleteChirp(id int) ([]db.Chirp, []db.Media, error)
+	UpdateChirp(id int, body string) (db.Chirp, error)
+	ListChirpRevisions(id int) ([]db.ChirpRevision, error)
+	GetConversation(id int) (db.Conversation, error)
+	CountReplies(ids []int) (map[int]int, error)
+	TrendingTags(since time.Time, limit int) ([]db.TagCount, error)
+	GetUser(id int) (*db.User, error)
+	IsBlocked(userID, otherID int) (bool, error)
+	ListHiddenAuthorIDs(viewerID int) ([]int, error)
+	like.LikeStorer
+}
+
+// Listener is notified of the chirps created and deleted through the handler.
+type Listener interface {
+	ChirpCreated(chirp db.Chirp)
+	ChirpDeleted(chirp db.Chirp)
 }
 
 type Handler struct {
-	db           ChirpStorer
-	tokenManager *token.Manager
+	db         ChirpStorer
+	blobs      blob.BlobStore
+	cursors    *cursor.Signer
+	index      *search.Index
+	editWindow time.Duration
+	timelines  *timeline.Timeline
+	listeners  []Listener
+	moderation *moderation.Filter
 }
 
 // NewHandler returns a new handler.
-func NewHandler(db ChirpStorer, tokenManager *token.Manager) *Handler {
-	return &Handler{db: db, tokenManager: tokenManager}
+// The search index must already contain the stored chirps, the handler keeps it current.
+func NewHandler(db ChirpStorer, blobs blob.BlobStore, cursors *cursor.Signer, index *search.Index) *Handler {
+	// the default words cannot fail to build a filter.
+	filter, _ := moderation.NewFilter(moderation.DefaultWords, moderation.MaskFixed)
+	return &Handler{db: db, blobs: blobs, cursors: cursors, index: index, editWindow: DefaultEditWindow, moderation: filter}
 }
 
-type ChirpParameters struct {
-	Body string `json:"body"`
+// WithEditWindow sets how long after their creation the chirps can be edited.
+// A zero window disables editing.
+func (h *Handler) WithEditWindow(window time.Duration) *Handler {
+	h.editWindow = window
+	return h
 }
 
-// Create creates a new chirp.
-func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
-	// first check the access token. If it's not valid, we can't create a chirp.
-	token, err := h.tokenManager.GetAccessToken(r.Header)
+// WithModeration sets the filter masking the forbidden words of the chirps.
+func (h *Handler) WithModeration(filter *moderation.Filter) *Handler {
+	h.moderation = filter
+	return h
+}
+
+// WithTimeline sets the reader of the home timelines, kept current with the created chirps.
+func (h *Handler) WithTimeline(timelines *timeline.Timeline) *Handler {
+	h.timelines = timelines
+	return h.WithListener(timelines)
+}
+
+// WithListener adds a listener of the created and deleted chirps.
+func (h *Handler) WithListener(listener Listener) *Handler {
+	h.listeners = append(h.listeners, listener)
+	return h
+}
+
+func (h *Handler) notifyCreated(chirp db.Chirp) {
+	for _, listener := range h.listeners {
+		listener.ChirpCreated(chirp)
+	}
+}
+
+func (h *Handler) notifyDeleted(chirp db.Chirp) {
+	for _, listener := range h.listeners {
+		listener.ChirpDeleted(chirp)
+	}
+}
+
+// ChirpResponse is a chirp as returned by the API.
+type ChirpResponse struct {
+	db.Chirp
+	Attachments []AttachmentResponse `json:"attachments,omitempty"`
+	ReplyCount  int                  `json:"reply_count"`
+	LikeCount   int                  `json:"like_count"`
+	// LikedByMe is only set for authenticated requests.
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	// Original is the chirp shared by a rechirp or a quote.
+	Original *ChirpResponse `json:"original,omitempty"`
+	// Deleted is set on the placeholders of deleted chirps, which only have an id.
+	Deleted bool `json:"deleted,omitempty"`
+	// Hidden is set on the placeholders of the chirps hidden by a moderator.
+	Hidden bool `json:"hidden,omitempty"`
+	// Author is only embedded on demand, with ?embed=author.
+	Author *db.PublicProfile `json:"author,omitempty"`
+}
+
+// ChirpPageResponse is a page of chirps.
+type ChirpPageResponse struct {
+	Chirps     []ChirpResponse `json:"chirps"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+	PrevCursor string          `json:"prev_cursor,omitempty"`
+}
+
+// chirpCursor is the position of a page of chirps.
+// It carries the filters of the listing so that a cursor cannot be reused with other ones.
+type chirpCursor struct {
+	ID       int    `json:"id"`
+	AuthorID int    `json:"author_id"`
+	Sort     string `json:"sort"`
+}
+
+// AttachmentResponse is an attachment with the urls of its media.
+type AttachmentResponse struct {
+	db.Attachment
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+// embedAuthor reports whether the request asks to embed the author profiles.
+func embedAuthor(r *http.Request) bool {
+	for _, embed := range strings.Split(r.URL.Query().Get("embed"), ",") {
+		if embed == "author" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deletedChirpResponse returns the placeholder of a deleted chirp.
+func deletedChirpResponse(id int) ChirpResponse {
+	return ChirpResponse{Chirp: db.Chirp{ID: id}, Deleted: true}
+}
+
+// hiddenChirpResponse returns the placeholder of a hidden chirp, which keeps its place in its conversation.
+func hiddenChirpResponse(chirp db.Chirp) ChirpResponse {
+	return ChirpResponse{
+		Chirp:  db.Chirp{ID: chirp.ID, ReplyToID: chirp.ReplyToID, ConversationID: chirp.ConversationID},
+		Hidden: true,
+	}
+}
+
+// CanSee reports whether the chirp is visible to the caller of the request:
+// the hidden chirps are only visible to their author and the admins,
+// and the chirps of the users blocking or blocked by the caller are not visible.
+func (h *Handler) CanSee(r *http.Request, chirp db.Chirp) bool {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if chirp.IsHidden() && !(ok && (principal.UserID == chirp.AuthorID || principal.HasRole(token.RoleAdmin))) {
+		return false
+	}
+	if !ok || principal.UserID == chirp.AuthorID {
+		return true
+	}
+
+	blocked, err := h.db.IsBlocked(principal.UserID, chirp.AuthorID)
 	if err != nil {
-		api.RespondWithError(w, http.StatusUnauthorized, err.Error())
-		return
+		log.Printf("check block of chirp %d: %v", chirp.ID, err)
+	}
+	return !blocked
+}
+
+// viewerID returns the id of the authenticated caller of the request, 0 for anonymous requests.
+func viewerID(r *http.Request) int {
+	principal, _ := api.PrincipalFromContext(r.Context())
+	return principal.UserID
+}
+
+// newChirpResponses builds the responses of the chirps, embedding the author profiles if asked,
+// and the originals of the rechirps and quotes.
+func (h *Handler) newChirpResponses(r *http.Request, chirps []db.Chirp) []ChirpResponse {
+	resp := h.newChirpResponsesWithoutOriginals(r, chirps)
+
+	var originals []db.Chirp
+	hidden := map[int]db.Chirp{}
+	seen := map[int]bool{}
+	for _, chirp := range chirps {
+		id := originalID(chirp)
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		// quotes keep the reference of their deleted original, which becomes a placeholder.
+		original, err := h.db.GetChirp(id)
+		if err != nil {
+			continue
+		}
+		if !h.CanSee(r, *original) {
+			hidden[id] = *original
+			continue
+		}
+		originals = append(originals, *original)
+	}
+
+	// originals are embedded one level deep, the original of a quote of a quote is not.
+	originalResponses := map[int]ChirpResponse{}
+	for _, original := range h.newChirpResponsesWithoutOriginals(r, originals) {
+		originalResponses[original.ID] = original
+	}
+	for i, chirp := range chirps {
+		id := originalID(chirp)
+		if id == 0 {
+			continue
+		}
+		original, ok := originalResponses[id]
+		if hiddenOriginal, isHidden := hidden[id]; isHidden {
+			original = hiddenChirpResponse(hiddenOriginal)
+		} else if !ok {
+			original = deletedChirpResponse(id)
+		}
+		resp[i].Original = &original
+	}
+
+	return resp
+}
+
+// originalID returns the id of the chirp shared by a rechirp or a quote, 0 if none.
+func originalID(chirp db.Chirp) int {
+	if chirp.RechirpOfID != 0 {
+		return chirp.RechirpOfID
 	}
+	return chirp.QuoteOfID
+}
+
+func (h *Handler) newChirpResponsesWithoutOriginals(r *http.Request, chirps []db.Chirp) []ChirpResponse {
+	withAuthor := embedAuthor(r)
+	profiles := map[int]*db.PublicProfile{}
 
-	userID, err := h.tokenManager.GetUserID(token)
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	replyCounts, err := h.db.CountReplies(ids)
+	if err != nil {
+		log.Printf("count replies: %v", err)
+	}
+	likeCounts, err := h.db.CountLikes(ids)
 	if err != nil {
-		api.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		log.Printf("count likes: %v", err)
+	}
+	var liked map[int]bool
+	if principal, ok := api.PrincipalFromContext(r.Context()); ok {
+		if liked, err = h.db.ListLikedChirps(principal.UserID, ids); err != nil {
+			log.Printf("list liked chirps: %v", err)
+		}
+	}
+
+	resp := make([]ChirpResponse, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirpResp := ChirpResponse{Chirp: chirp, ReplyCount: replyCounts[chirp.ID], LikeCount: likeCounts[chirp.ID]}
+		if liked != nil {
+			likedByMe := liked[chirp.ID]
+			chirpResp.LikedByMe = &likedByMe
+		}
+		for _, attachment := range chirp.Attachments {
+			chirpResp.Attachments = append(chirpResp.Attachments, AttachmentResponse{
+				Attachment:   attachment,
+				URL:          db.MediaURL(attachment.MediaID),
+				ThumbnailURL: db.MediaThumbnailURL(attachment.MediaID),
+			})
+		}
+		if withAuthor {
+			profile, ok := profiles[chirp.AuthorID]
+			if !ok {
+				// the author may have been deleted, the chirp is then anonymous.
+				if author, err := h.db.GetUser(chirp.AuthorID); err == nil {
+					p := author.PublicProfile()
+					profile = &p
+				}
+				profiles[chirp.AuthorID] = profile
+			}
+			chirpResp.Author = profile
+		}
+		resp = append(resp, chirpResp)
+	}
+
+	return resp
+}
+
+type ChirpParameters struct {
+	Body  string                 `json:"body"`
+	Media []AttachmentParameters `json:"media"`
+	// ReplyToID is the chirp the new chirp replies to.
+	ReplyToID int `json:"reply_to_id"`
+	// QuoteOfID is the chirp quoted by the new chirp.
+	QuoteOfID int `json:"quote_of_id"`
+}
+
+// AttachmentParameters reference a media uploaded with POST /api/media.
+type AttachmentParameters struct {
+	ID      int    `json:"id"`
+	AltText string `json:"alt_text"`
+}
+
+// Create creates a new chirp.
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
@@ -61,32 +358,65 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(params.Body) > 140 {
+	// the length is the one of the stored body, once its forbidden words are masked.
+	cleanedChirp := h.moderation.Clean(params.Body)
+	if uniseg.GraphemeClusterCount(cleanedChirp) > maxChirpLength {
 		api.RespondWithError(w, http.StatusBadRequest, "Chirp is too long")
 		return
 	}
 
-	cleanedChirp := cleanChirp(params.Body)
-	chirp, err := h.db.CreateChirp(cleanedChirp, userID)
+	var attachments []db.Attachment
+	if len(params.Media) > 0 {
+		limit := maxAttachments
+		if author, err := h.db.GetUser(principal.UserID); err == nil && author.IsChirpyRed() {
+			limit = maxAttachmentsChirpyRed
+		}
+		if len(params.Media) > limit {
+			api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Chirp can have at most %d attachments", limit))
+			return
+		}
+
+		for _, media := range params.Media {
+			if utf8.RuneCountInString(media.AltText) > maxAltTextLength {
+				api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Alt text must be at most %d characters long", maxAltTextLength))
+				return
+			}
+			attachments = append(attachments, db.Attachment{MediaID: media.ID, AltText: strings.TrimSpace(media.AltText)})
+		}
+	}
+
+	chirp, err := h.db.CreateChirp(db.Chirp{
+		Body:        cleanedChirp,
+		AuthorID:    principal.UserID,
+		Attachments: attachments,
+		ReplyToID:   params.ReplyToID,
+		QuoteOfID:   params.QuoteOfID,
+	})
 	if err != nil {
+		if errors.Is(err, db.ErrInvalidAttachment) || errors.Is(err, db.ErrInvalidReply) || errors.Is(err, db.ErrInvalidQuote) {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, db.ErrSuspended) {
+			api.RespondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	h.index.Add(chirp.ID, chirp.Body)
+	h.notifyCreated(chirp)
 
-	api.RespondWithJSON(w, http.StatusCreated, chirp)
+	api.RespondWithJSON(w, http.StatusCreated, h.newChirpResponses(r, []db.Chirp{chirp})[0])
 }
 
 // List returns all chirps in the database
 func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
-	author_id := r.URL.Query().Get("author_id")
-	authorID := -1
-	if author_id != "" {
-		var err error
-		authorID, err = strconv.Atoi(author_id)
-		if err != nil {
-			api.RespondWithError(w, http.StatusBadRequest, err.Error())
-			return
-		}
+	authorID, err := parseAuthorID(r)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	sort := r.URL.Query().Get("sort")
@@ -98,14 +428,91 @@ func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chirps, err := h.db.ListChirps(authorID, sort)
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// without pagination parameters, all the chirps are returned as before.
+	if !params.Paginated() {
+		chirps, err := h.db.ListChirps(authorID, viewerID(r), sort)
+		if err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		api.RespondWithJSON(w, http.StatusOK, h.newChirpResponses(r, chirps))
+		return
+	}
+
+	page := db.ChirpPage{AuthorID: authorID, ViewerID: viewerID(r), Sort: sort, Limit: params.Limit}
+	if page.Limit == 0 {
+		page.Limit = defaultPageSize
+	}
+	if params.After != "" {
+		if page.AfterID, err = h.decodeCursor(params.After, authorID, sort); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if params.Before != "" {
+		if page.BeforeID, err = h.decodeCursor(params.Before, authorID, sort); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	chirps, more, err := h.db.ListChirpsPage(page)
 	if err != nil {
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	api.RespondWithJSON(w, http.StatusOK, chirps)
-	return
+	resp := ChirpPageResponse{Chirps: h.newChirpResponses(r, chirps)}
+	if len(chirps) > 0 {
+		// a page before a cursor always has a next page, the one of the cursor.
+		hasNext, hasPrev := more, page.AfterID != 0
+		if page.BeforeID != 0 {
+			hasNext, hasPrev = true, more
+		}
+		if hasNext {
+			resp.NextCursor, err = h.cursors.Encode(chirpCursor{ID: chirps[len(chirps)-1].ID, AuthorID: authorID, Sort: sort})
+		}
+		if hasPrev && err == nil {
+			resp.PrevCursor, err = h.cursors.Encode(chirpCursor{ID: chirps[0].ID, AuthorID: authorID, Sort: sort})
+		}
+		if err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, resp.PrevCursor)
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// parseAuthorID returns the author_id query parameter, -1 if not set.
+func parseAuthorID(r *http.Request) (int, error) {
+	authorID := r.URL.Query().Get("author_id")
+	if authorID == "" {
+		return -1, nil
+	}
+
+	return strconv.Atoi(authorID)
+}
+
+// decodeCursor returns the chirp id of a cursor issued for the same filters.
+func (h *Handler) decodeCursor(raw string, authorID int, sort string) (int, error) {
+	var position chirpCursor
+	if err := h.cursors.Decode(raw, &position); err != nil {
+		return 0, err
+	}
+	if position.AuthorID != authorID || position.Sort != sort {
+		return 0, errors.New("cursor does not match the author_id and sort parameters")
+	}
+
+	return position.ID, nil
 }
 
 // Get returns a single chirp.
@@ -123,15 +530,19 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 		api.RespondWithError(w, http.StatusNotFound, err.Error())
 		return
 	}
+	if !h.CanSee(r, *chirp) {
+		api.RespondWithError(w, http.StatusNotFound, db.ErrNotFound.Error())
+		return
+	}
 
-	api.RespondWithJSON(w, http.StatusOK, chirp)
+	api.RespondWithJSON(w, http.StatusOK, h.newChirpResponses(r, []db.Chirp{*chirp})[0])
 }
 
 // Delete deletes a owned chirp.
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
-	accessToken, err := h.tokenManager.GetAccessToken(r.Header)
-	if err != nil {
-		api.RespondWithError(w, http.StatusUnauthorized, err.Error())
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
 		return
 	}
 
@@ -149,29 +560,29 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := h.tokenManager.GetUserID(accessToken)
-	if err != nil {
-		api.RespondWithError(w, http.StatusUnauthorized, err.Error())
+	if chirp.AuthorID != principal.UserID {
+		api.RespondWithError(w, http.StatusForbidden, "You can only delete your own chirps")
 		return
 	}
-
-	if chirp.AuthorID != userID {
-		api.RespondWithError(w, http.StatusForbidden, "You can only delete your own chirps")
+	deleted, released, err := h.db.DeleteChirp(id)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	h.db.DeleteChirp(id)
-
-	api.RespondWithJSON(w, http.StatusOK, chirp)
-}
+	// the plain rechirps of the chirp are deleted with it.
+	for _, chirp := range deleted {
+		h.index.Remove(chirp.ID)
+		h.notifyDeleted(chirp)
+	}
 
-func cleanChirp(body string) string {
-	splitBody := strings.Split(body, " ")
-	for i, word := range splitBody {
-		switch strings.ToLower(word) {
-		case "kerfuffle", "sharbert", "fornax":
-			splitBody[i] = "****"
+	// the attached media are orphans now.
+	for _, media := range released {
+		for _, key := range []string{media.Key, media.ThumbnailKey} {
+			if err := h.blobs.Delete(key); err != nil {
+				log.Printf("delete blob %s of chirp %d: %v", key, id, err)
+			}
 		}
 	}
 
-	return strings.Join(splitBody, " ")
+	api.RespondWithJSON(w, http.StatusOK, h.newChirpResponses(r, []db.Chirp{*chirp})[0])
 }
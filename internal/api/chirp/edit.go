@@ -0,0 +1,122 @@
+package chirp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/rivo/uniseg"
+)
+
+// UpdateParameters are the editable fields of a chirp.
+type UpdateParameters struct {
+	Body string `json:"body"`
+}
+
+// Update edits the body of an owned chirp during the edit window.
+// The previous body is kept in the history of the chirp.
+func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chirp, err := h.db.GetChirp(id)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	if chirp.AuthorID != principal.UserID {
+		api.RespondWithError(w, http.StatusForbidden, "You can only edit your own chirps")
+		return
+	}
+
+	if chirp.RechirpOfID != 0 {
+		api.RespondWithError(w, http.StatusBadRequest, "Rechirps cannot be edited")
+		return
+	}
+
+	// chirps created before their creation time was recorded cannot be edited.
+	if chirp.CreatedAt == nil || time.Since(*chirp.CreatedAt) > h.editWindow {
+		api.RespondWithError(w, http.StatusForbidden, "The chirp can no longer be edited")
+		return
+	}
+
+	params := UpdateParameters{}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cleanedChirp := h.moderation.Clean(params.Body)
+	if uniseg.GraphemeClusterCount(cleanedChirp) > maxChirpLength {
+		api.RespondWithError(w, http.StatusBadRequest, "Chirp is too long")
+		return
+	}
+
+	// an edit that changes nothing does not add a revision.
+	if cleanedChirp == chirp.Body {
+		api.RespondWithJSON(w, http.StatusOK, h.newChirpResponses(r, []db.Chirp{*chirp})[0])
+		return
+	}
+
+	updated, err := h.db.UpdateChirp(id, cleanedChirp)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.index.Add(updated.ID, updated.Body)
+
+	api.RespondWithJSON(w, http.StatusOK, h.newChirpResponses(r, []db.Chirp{updated})[0])
+}
+
+// History returns the previous versions of a chirp, the oldest first.
+// The history of a chirp the caller cannot see is not found, as the chirp itself.
+func (h *Handler) History(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chirp, err := h.db.GetChirp(id)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !h.CanSee(r, *chirp) {
+		api.RespondWithError(w, http.StatusNotFound, db.ErrNotFound.Error())
+		return
+	}
+
+	revisions, err := h.db.ListChirpRevisions(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, revisions)
+}
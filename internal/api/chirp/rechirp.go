@@ -0,0 +1,49 @@
+package chirp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+// Rechirp shares a chirp as a plain rechirp of the authenticated user.
+// Rechirping a chirp twice returns the existing rechirp, which is deleted like any chirp.
+func (h *Handler) Rechirp(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rechirp, created, err := h.db.Rechirp(principal.UserID, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, db.ErrSuspended) {
+			api.RespondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		h.notifyCreated(rechirp)
+	}
+	api.RespondWithJSON(w, status, h.newChirpResponses(r, []db.Chirp{rechirp})[0])
+}
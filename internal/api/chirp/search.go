@@ -0,0 +1,117 @@
+package chirp
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/search"
+)
+
+// searchCursor is the position in the results of a search.
+// Results are ranked, so the position is an offset in the ranking.
+type searchCursor struct {
+	Query    string `json:"q"`
+	Sort     string `json:"sort"`
+	AuthorID int    `json:"author_id"`
+	Offset   int    `json:"offset"`
+}
+
+// Search returns a page of the chirps matching the q parameter,
+// by relevance (default) or recency with sort=recent.
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	query := search.ParseQuery(q)
+	if query.Empty() {
+		api.RespondWithError(w, http.StatusBadRequest, "Missing search query")
+		return
+	}
+
+	authorID, err := parseAuthorID(r)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = search.OrderRelevance
+	}
+	if sort != search.OrderRelevance && sort != search.OrderRecency {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid sort parameter")
+		return
+	}
+
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Before != "" {
+		api.RespondWithError(w, http.StatusBadRequest, "before is not supported by search")
+		return
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+
+	position := searchCursor{Query: q, Sort: sort, AuthorID: authorID}
+	if params.After != "" {
+		var after searchCursor
+		if err := h.cursors.Decode(params.After, &after); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if after.Query != q || after.Sort != sort || after.AuthorID != authorID {
+			api.RespondWithError(w, http.StatusBadRequest, "cursor does not match the q, author_id and sort parameters")
+			return
+		}
+		position.Offset = after.Offset
+	}
+
+	hiddenAuthors, err := h.db.ListHiddenAuthorIDs(viewerID(r))
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// the chirps are read from the store, which applies its visibility rules
+	// and skips the chirps the index still knows but that are gone.
+	// As in the listings, the hidden chirps are left out, even for their author and the admins.
+	var chirps []db.Chirp
+	more, skipped := false, 0
+	for _, hit := range h.index.Search(query, sort) {
+		chirp, err := h.db.GetChirp(hit.ID)
+		if err != nil || chirp.IsHidden() || !h.CanSee(r, *chirp) || slices.Contains(hiddenAuthors, chirp.AuthorID) {
+			continue
+		}
+		if authorID != -1 && chirp.AuthorID != authorID {
+			continue
+		}
+		if skipped < position.Offset {
+			skipped++
+			continue
+		}
+		if len(chirps) == limit {
+			more = true
+			break
+		}
+		chirps = append(chirps, *chirp)
+	}
+
+	resp := ChirpPageResponse{Chirps: h.newChirpResponses(r, chirps)}
+	if more {
+		position.Offset += limit
+		if resp.NextCursor, err = h.cursors.Encode(position); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, "")
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
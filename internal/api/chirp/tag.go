@@ -0,0 +1,91 @@
+package chirp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/entity"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+	maxTrendingLimit      = 50
+)
+
+// TrendingResponse lists the most used hashtags of a window.
+type TrendingResponse struct {
+	Window string        `json:"window"`
+	Tags   []db.TagCount `json:"tags"`
+}
+
+// Tag returns a page of the chirps using a hashtag, the most recent first.
+func (h *Handler) Tag(w http.ResponseWriter, r *http.Request) {
+	tag := entity.NormalizeTag(chi.URLParam(r, "tag"))
+	if tag == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "tag is required")
+		return
+	}
+
+	h.respondFeed(w, r, "tag:"+tag, func(beforeID, limit int) ([]db.Chirp, bool, error) {
+		return h.db.ListChirpsPage(db.ChirpPage{AuthorID: -1, Tag: tag, ViewerID: viewerID(r), Sort: SortDesc, AfterID: beforeID, Limit: limit})
+	})
+}
+
+// Mentions returns a page of the chirps mentioning a user, the most recent first.
+func (h *Handler) Mentions(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, err := h.db.GetUser(userID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.respondFeed(w, r, "mentions:"+strconv.Itoa(userID), func(beforeID, limit int) ([]db.Chirp, bool, error) {
+		return h.db.ListChirpsPage(db.ChirpPage{AuthorID: -1, MentionedID: userID, ViewerID: viewerID(r), Sort: SortDesc, AfterID: beforeID, Limit: limit})
+	})
+}
+
+// Trending returns the hashtags used by the most chirps during the last window,
+// a Go duration of 24h by default and 7 days at most.
+func (h *Handler) Trending(w http.ResponseWriter, r *http.Request) {
+	window := defaultTrendingWindow
+	if value := r.URL.Query().Get("window"); value != "" {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil || window <= 0 || window > maxTrendingWindow {
+			api.RespondWithError(w, http.StatusBadRequest, "window must be a positive duration of 168h at most")
+			return
+		}
+	}
+
+	limit := defaultTrendingLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxTrendingLimit {
+			api.RespondWithError(w, http.StatusBadRequest, "limit must be between 1 and 50")
+			return
+		}
+	}
+
+	tags, err := h.db.TrendingTags(time.Now().Add(-window), limit)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, TrendingResponse{Window: window.String(), Tags: tags})
+}
@@ -0,0 +1,225 @@
+package chirp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+const (
+	defaultThreadDepth = 5
+	maxThreadDepth     = 10
+)
+
+// ThreadResponse is a chirp with its ancestors and its replies.
+type ThreadResponse struct {
+	// Ancestors go from the first chirp of the conversation to the parent of the chirp.
+	Ancestors []ThreadNode `json:"ancestors"`
+	Chirp     ThreadNode   `json:"chirp"`
+	// NextCursor is the cursor of the next page of the direct replies to the chirp.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ThreadNode is a chirp of a thread with its replies.
+// A deleted chirp is a placeholder with only its id, reply_to_id and reply_count.
+type ThreadNode struct {
+	ChirpResponse
+	Replies []ThreadNode `json:"replies,omitempty"`
+	// HasMoreReplies is set when some replies are beyond the depth or the page size.
+	HasMoreReplies bool `json:"has_more_replies,omitempty"`
+}
+
+// threadCursor is the position in the replies to a chirp.
+type threadCursor struct {
+	ChirpID int `json:"chirp_id"`
+	ReplyID int `json:"reply_id"`
+}
+
+// thread indexes the chirps of a conversation.
+type thread struct {
+	nodes   map[int]ThreadNode
+	replies map[int][]int
+	limit   int
+}
+
+// Thread returns a chirp with its ancestors and its replies, the oldest first,
+// up to the depth parameter. The direct replies to the chirp are paginated
+// with the limit and after parameters, limit also caps the replies of the other chirps.
+func (h *Handler) Thread(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	depth := defaultThreadDepth
+	if value := r.URL.Query().Get("depth"); value != "" {
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth < 0 || depth > maxThreadDepth {
+			api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("depth must be between 0 and %d", maxThreadDepth))
+			return
+		}
+	}
+
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Before != "" {
+		api.RespondWithError(w, http.StatusBadRequest, "before is not supported by threads")
+		return
+	}
+	afterID := 0
+	if params.After != "" {
+		var position threadCursor
+		if err := h.cursors.Decode(params.After, &position); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if position.ChirpID != id {
+			api.RespondWithError(w, http.StatusBadRequest, "cursor does not match the chirp")
+			return
+		}
+		afterID = position.ReplyID
+	}
+
+	conversation, err := h.db.GetConversation(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	t := h.newThread(r, conversation, params.Limit)
+	if t.nodes[id].Hidden {
+		api.RespondWithError(w, http.StatusNotFound, db.ErrNotFound.Error())
+		return
+	}
+
+	var ancestors []ThreadNode
+	for parentID := t.nodes[id].ReplyToID; parentID != 0; parentID = t.node(parentID).ReplyToID {
+		ancestors = append([]ThreadNode{t.node(parentID)}, ancestors...)
+	}
+
+	// the direct replies to the chirp are paginated.
+	node := t.nodes[id]
+	replies := t.replies[id]
+	for len(replies) > 0 && replies[0] <= afterID {
+		replies = replies[1:]
+	}
+	more := len(replies) > t.limit
+	if more {
+		replies = replies[:t.limit]
+	}
+	node.HasMoreReplies = more || depth == 0 && len(replies) > 0
+	if depth > 0 {
+		for _, reply := range replies {
+			node.Replies = append(node.Replies, t.tree(reply, depth-1))
+		}
+	}
+
+	resp := ThreadResponse{Ancestors: ancestors, Chirp: node}
+	if ancestors == nil {
+		resp.Ancestors = []ThreadNode{}
+	}
+	if more && depth > 0 {
+		if resp.NextCursor, err = h.cursors.Encode(threadCursor{ChirpID: id, ReplyID: replies[len(replies)-1]}); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, "")
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// newThread indexes the chirps of the conversation by id and by parent.
+func (h *Handler) newThread(r *http.Request, conversation db.Conversation, limit int) *thread {
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	t := &thread{nodes: map[int]ThreadNode{}, replies: map[int][]int{}, limit: limit}
+
+	for i, chirp := range h.newChirpResponses(r, conversation.Chirps) {
+		if !h.CanSee(r, conversation.Chirps[i]) {
+			chirp = hiddenChirpResponse(conversation.Chirps[i])
+		}
+		t.nodes[chirp.ID] = ThreadNode{ChirpResponse: chirp}
+	}
+	for _, tombstone := range conversation.Deleted {
+		t.nodes[tombstone.ID] = ThreadNode{ChirpResponse: ChirpResponse{
+			Chirp: db.Chirp{
+				ID:             tombstone.ID,
+				ReplyToID:      tombstone.ReplyToID,
+				ConversationID: tombstone.ConversationID,
+			},
+			Deleted: true,
+		}}
+	}
+
+	// the conversation is sorted by id, so are the replies.
+	for _, chirp := range conversation.Chirps {
+		if chirp.ReplyToID != 0 {
+			t.replies[chirp.ReplyToID] = append(t.replies[chirp.ReplyToID], chirp.ID)
+		}
+	}
+	for _, tombstone := range conversation.Deleted {
+		if tombstone.ReplyToID != 0 {
+			t.replies[tombstone.ReplyToID] = append(t.replies[tombstone.ReplyToID], tombstone.ID)
+		}
+	}
+	for parentID, replies := range t.replies {
+		slices.Sort(replies)
+		// the reply count of the placeholders is not known by the store.
+		if parent, ok := t.nodes[parentID]; ok && parent.Deleted {
+			for _, reply := range replies {
+				if !t.nodes[reply].Deleted {
+					parent.ReplyCount++
+				}
+			}
+			t.nodes[parentID] = parent
+		}
+	}
+
+	return t
+}
+
+// node returns a chirp of the thread, or a placeholder if the chirp is unknown.
+func (t *thread) node(id int) ThreadNode {
+	if node, ok := t.nodes[id]; ok {
+		return node
+	}
+	return ThreadNode{ChirpResponse: deletedChirpResponse(id)}
+}
+
+// tree returns a chirp with its replies up to the given depth.
+func (t *thread) tree(id, depth int) ThreadNode {
+	node := t.node(id)
+	replies := t.replies[id]
+	if depth == 0 {
+		node.HasMoreReplies = len(replies) > 0
+		return node
+	}
+
+	if len(replies) > t.limit {
+		replies = replies[:t.limit]
+		node.HasMoreReplies = true
+	}
+	for _, reply := range replies {
+		node.Replies = append(node.Replies, t.tree(reply, depth-1))
+	}
+
+	return node
+}
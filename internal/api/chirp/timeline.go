@@ -0,0 +1,87 @@
+package chirp
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+// feedCursor is the position in a feed of chirps listed the most recent first.
+// Feed identifies the feed, so that a cursor cannot be reused with another one.
+type feedCursor struct {
+	Feed    string `json:"feed"`
+	ChirpID int    `json:"chirp_id"`
+}
+
+// loadFeed returns the chirps of a feed older than the chirp beforeID, 0 for the first page,
+// and whether more chirps follow.
+type loadFeed func(beforeID, limit int) ([]db.Chirp, bool, error)
+
+// Timeline returns a page of the home timeline of the authenticated user:
+// their chirps and the chirps of the users they follow, the most recent first.
+func (h *Handler) Timeline(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if h.timelines == nil {
+		api.RespondWithError(w, http.StatusNotFound, "timelines are not enabled")
+		return
+	}
+
+	h.respondFeed(w, r, fmt.Sprintf("timeline:%d", principal.UserID), func(beforeID, limit int) ([]db.Chirp, bool, error) {
+		return h.timelines.Page(principal.UserID, beforeID, limit)
+	})
+}
+
+// respondFeed responds with a page of a feed, paginated with limit and after.
+func (h *Handler) respondFeed(w http.ResponseWriter, r *http.Request, feed string, load loadFeed) {
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Before != "" {
+		api.RespondWithError(w, http.StatusBadRequest, "before is not supported by feeds")
+		return
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+
+	beforeID := 0
+	if params.After != "" {
+		var position feedCursor
+		if err := h.cursors.Decode(params.After, &position); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if position.Feed != feed {
+			api.RespondWithError(w, http.StatusBadRequest, "cursor does not match the feed")
+			return
+		}
+		beforeID = position.ChirpID
+	}
+
+	chirps, more, err := load(beforeID, limit)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := ChirpPageResponse{Chirps: h.newChirpResponses(r, chirps)}
+	if more && len(chirps) > 0 {
+		if resp.NextCursor, err = h.cursors.Encode(feedCursor{Feed: feed, ChirpID: chirps[len(chirps)-1].ID}); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, "")
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
@@ -1,11 +1,45 @@
 package cors
 
-import "net/http"
+import (
+	"net/http"
+	"slices"
+)
 
-func Middleware(next http.Handler) http.Handler {
+// AnyOrigin allows the requests of every origin.
+const AnyOrigin = "*"
+
+// Policy lists the origins allowed to call the API from a browser.
+type Policy struct {
+	origins []string
+}
+
+// NewPolicy returns the policy of the allowed origins, any origin when none is given.
+func NewPolicy(origins ...string) *Policy {
+	if len(origins) == 0 {
+		origins = []string{AnyOrigin}
+	}
+	return &Policy{origins: origins}
+}
+
+// Allows reports whether the origin is listed by the policy.
+// The wildcard is not an origin: it only allows the cross-origin requests
+// that do not carry credentials, and the websockets ignore it.
+func (p *Policy) Allows(origin string) bool {
+	return origin != AnyOrigin && slices.Contains(p.origins, origin)
+}
+
+// Middleware adds the CORS headers of the allowed origins to the responses.
+func (p *Policy) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
+		if slices.Contains(p.origins, AnyOrigin) {
+			w.Header().Set("Access-Control-Allow-Origin", AnyOrigin)
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if origin := r.Header.Get("Origin"); p.Allows(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, PATCH, DELETE")
 		w.Header().Set("Access-Control-Allow-Headers", "*")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
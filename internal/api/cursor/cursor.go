@@ -0,0 +1,145 @@
+// Package cursor implements opaque, signed pagination cursors.
+//
+// A cursor is the base64 encoded JSON of a position followed by its HMAC-SHA256,
+// so that clients cannot forge positions nor reuse a cursor with other filters.
+package cursor
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidCursor is returned for a malformed or tampered cursor.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Signer encodes and decodes signed cursors.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner returns a signer using the given secret.
+// An empty secret is replaced by a random one, the cursors are then only valid
+// until the server restarts.
+func NewSigner(secret string) *Signer {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(fmt.Sprintf("generate cursor key: %v", err))
+		}
+	}
+
+	return &Signer{key: key}
+}
+
+// Encode returns the signed cursor of a position.
+func (s *Signer) Encode(position any) (string, error) {
+	payload, err := json.Marshal(position)
+	if err != nil {
+		return "", fmt.Errorf("marshal cursor: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sign(payload)), nil
+}
+
+// Decode verifies a cursor and stores its position in the value pointed to by position.
+func (s *Signer) Decode(cursor string, position any) error {
+	encodedPayload, encodedMAC, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, s.sign(payload)) {
+		return ErrInvalidCursor
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(position); err != nil {
+		return ErrInvalidCursor
+	}
+
+	return nil
+}
+
+func (s *Signer) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Params are the pagination parameters of a request.
+type Params struct {
+	Limit int
+	// After and Before are the raw cursors, at most one is set.
+	After  string
+	Before string
+}
+
+// Paginated reports whether the request asked for a page.
+func (p Params) Paginated() bool {
+	return p.Limit > 0 || p.After != "" || p.Before != ""
+}
+
+// ParseParams reads the limit, after and before query parameters.
+// Limit is 0 when the parameter is missing, and must be between 1 and maxLimit otherwise.
+func ParseParams(r *http.Request, maxLimit int) (Params, error) {
+	query := r.URL.Query()
+	params := Params{After: query.Get("after"), Before: query.Get("before")}
+
+	if params.After != "" && params.Before != "" {
+		return Params{}, errors.New("after and before cannot be used together")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		params.Limit, err = strconv.Atoi(limit)
+		if err != nil || params.Limit < 1 || params.Limit > maxLimit {
+			return Params{}, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+	}
+
+	return params, nil
+}
+
+// SetLinks sets the Link header of a page with its next and previous pages.
+// Empty cursors are omitted.
+func SetLinks(w http.ResponseWriter, r *http.Request, next, prev string) {
+	var links []string
+	if next != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, pageURL(r, "after", next)))
+	}
+	if prev != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, pageURL(r, "before", prev)))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// pageURL returns the url of the request with the given cursor.
+func pageURL(r *http.Request, param, cursor string) string {
+	query := r.URL.Query()
+	query.Del("after")
+	query.Del("before")
+	query.Set(param, cursor)
+
+	u := *r.URL
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
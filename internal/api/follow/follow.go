@@ -0,0 +1,213 @@
+package follow
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/timeline"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+const (
+	listFollowers = "followers"
+	listFollowing = "following"
+)
+
+// FollowStorer stores the follow graph.
+type FollowStorer interface {
+	// FollowUser is idempotent, created reports whether the follow is new.
+	FollowUser(followerID, followeeID int) (follow db.Follow, created bool, err error)
+	// UnfollowUser is idempotent, deleted reports whether there was a follow to remove.
+	UnfollowUser(followerID, followeeID int) (deleted bool, err error)
+	ListFollowedIDs(userID int) ([]int, error)
+	ListFollowerIDs(userID int) ([]int, error)
+	// ListFollowers and ListFollowing return the follows the most recent first, before the follow beforeID if not 0.
+	ListFollowers(userID, beforeID, limit int) ([]db.Follow, bool, error)
+	ListFollowing(userID, beforeID, limit int) ([]db.Follow, bool, error)
+}
+
+type Storer interface {
+	FollowStorer
+	GetUser(id int) (*db.User, error)
+}
+
+type Handler struct {
+	db        Storer
+	cursors   *cursor.Signer
+	timelines *timeline.Timeline
+}
+
+// NewHandler returns a new handler.
+// The cached timelines of the users are invalidated when they follow or unfollow someone.
+func NewHandler(db Storer, cursors *cursor.Signer, timelines *timeline.Timeline) *Handler {
+	return &Handler{db: db, cursors: cursors, timelines: timelines}
+}
+
+// StateResponse is the follow state of a user for the authenticated user.
+type StateResponse struct {
+	UserID       int  `json:"user_id"`
+	FollowedByMe bool `json:"followed_by_me"`
+}
+
+// UserResponse is a user of a follow list.
+type UserResponse struct {
+	db.PublicProfile
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+// UsersResponse is a page of followers or followed users.
+type UsersResponse struct {
+	Users      []UserResponse `json:"users"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// followCursor is the position in a follow list.
+type followCursor struct {
+	UserID   int    `json:"user_id"`
+	List     string `json:"list"`
+	FollowID int    `json:"follow_id"`
+}
+
+// Follow makes the authenticated user follow a user. Following a user twice is a no-op.
+func (h *Handler) Follow(w http.ResponseWriter, r *http.Request) {
+	h.setFollow(w, r, true)
+}
+
+// Unfollow makes the authenticated user unfollow a user. Unfollowing a user not followed is a no-op.
+func (h *Handler) Unfollow(w http.ResponseWriter, r *http.Request) {
+	h.setFollow(w, r, false)
+}
+
+func (h *Handler) setFollow(w http.ResponseWriter, r *http.Request, followed bool) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var changed bool
+	if followed {
+		_, changed, err = h.db.FollowUser(principal.UserID, userID)
+	} else {
+		changed, err = h.db.UnfollowUser(principal.UserID, userID)
+	}
+	if err != nil {
+		if errors.Is(err, db.ErrSelfFollow) {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if errors.Is(err, db.ErrBlocked) {
+			api.RespondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if changed && h.timelines != nil {
+		h.timelines.Invalidate(principal.UserID)
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, StateResponse{UserID: userID, FollowedByMe: followed})
+}
+
+// Followers returns a page of the followers of a user, the most recent first.
+func (h *Handler) Followers(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, listFollowers)
+}
+
+// Following returns a page of the users followed by a user, the most recent first.
+func (h *Handler) Following(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r, listFollowing)
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, list string) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Before != "" {
+		api.RespondWithError(w, http.StatusBadRequest, "before is not supported by follow lists")
+		return
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+
+	beforeID := 0
+	if params.After != "" {
+		var position followCursor
+		if err := h.cursors.Decode(params.After, &position); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if position.UserID != userID || position.List != list {
+			api.RespondWithError(w, http.StatusBadRequest, "cursor does not match the list")
+			return
+		}
+		beforeID = position.FollowID
+	}
+
+	listFollows, otherID := h.db.ListFollowers, func(follow db.Follow) int { return follow.FollowerID }
+	if list == listFollowing {
+		listFollows, otherID = h.db.ListFollowing, func(follow db.Follow) int { return follow.FolloweeID }
+	}
+	follows, more, err := listFollows(userID, beforeID, limit)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := UsersResponse{Users: make([]UserResponse, 0, len(follows))}
+	for _, follow := range follows {
+		// the follows of deleted users are deleted with them.
+		user, err := h.db.GetUser(otherID(follow))
+		if err != nil {
+			continue
+		}
+		resp.Users = append(resp.Users, UserResponse{PublicProfile: user.PublicProfile(), FollowedAt: follow.CreatedAt})
+	}
+	if more {
+		position := followCursor{UserID: userID, List: list, FollowID: follows[len(follows)-1].ID}
+		if resp.NextCursor, err = h.cursors.Encode(position); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, "")
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,100 @@
+package follow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api/apitest"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *db.DB) {
+	t.Helper()
+	store := apitest.NewDB(t, "star@example.com", "fan@example.com", "other@example.com")
+
+	return NewHandler(store, cursor.NewSigner("secret"), nil), store
+}
+
+func TestHandler_Follow(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	// following and unfollowing twice are no-ops.
+	for i := 0; i < 2; i++ {
+		if rw := apitest.Serve(h.Follow, http.MethodPost, "/", "", 2, "id", "1"); rw.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d: %s", rw.Code, rw.Body.String())
+		}
+	}
+	if followers, err := store.ListFollowerIDs(1); err != nil || len(followers) != 1 {
+		t.Errorf("ListFollowerIDs() = %v, %v, want a single follower", followers, err)
+	}
+	for i := 0; i < 2; i++ {
+		rw := apitest.Serve(h.Unfollow, http.MethodDelete, "/", "", 2, "id", "1")
+		var state StateResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &state); err != nil || rw.Code != http.StatusOK || state.FollowedByMe {
+			t.Errorf("Unfollow() = %d %+v, want not followed", rw.Code, state)
+		}
+	}
+
+	if rw := apitest.Serve(h.Follow, http.MethodPost, "/", "", 0, "id", "1"); rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an anonymous follow to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Follow, http.MethodPost, "/", "", 2, "id", "2"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a self follow to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Follow, http.MethodPost, "/", "", 2, "id", "42"); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected the follow of a missing user to be rejected, got %d", rw.Code)
+	}
+	if _, _, err := store.BlockUser(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if rw := apitest.Serve(h.Follow, http.MethodPost, "/", "", 2, "id", "1"); rw.Code != http.StatusForbidden {
+		t.Errorf("Expected the follow of a blocking user to be rejected, got %d", rw.Code)
+	}
+}
+
+func TestHandler_Followers(t *testing.T) {
+	h, store := newTestHandler(t)
+	for _, followerID := range []int{2, 3} {
+		if _, _, err := store.FollowUser(followerID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	users := func(rw *httptest.ResponseRecorder) UsersResponse {
+		t.Helper()
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rw.Code, rw.Body.String())
+		}
+		var resp UsersResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	first := users(apitest.Serve(h.Followers, http.MethodGet, "/?limit=1", "", 0, "id", "1"))
+	if len(first.Users) != 1 || first.Users[0].ID != 3 || first.NextCursor == "" {
+		t.Fatalf("Followers() = %+v, want the last follower and a cursor", first)
+	}
+	second := users(apitest.Serve(h.Followers, http.MethodGet, "/?limit=1&after="+first.NextCursor, "", 0, "id", "1"))
+	if len(second.Users) != 1 || second.Users[0].ID != 2 || second.NextCursor != "" {
+		t.Errorf("Followers() = %+v, want the first follower and no cursor", second)
+	}
+
+	// a cursor only pages through the list it comes from.
+	if rw := apitest.Serve(h.Following, http.MethodGet, "/?after="+first.NextCursor, "", 0, "id", "1"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected the cursor of another list to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Followers, http.MethodGet, "/?after="+first.NextCursor, "", 0, "id", "2"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected the cursor of another user to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Followers, http.MethodGet, "/?limit=0", "", 0, "id", "1"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid limit to be rejected, got %d", rw.Code)
+	}
+
+	if got := users(apitest.Serve(h.Following, http.MethodGet, "/", "", 0, "id", "2")); len(got.Users) != 1 || got.Users[0].ID != 1 {
+		t.Errorf("Following() = %+v, want the followed user", got)
+	}
+}
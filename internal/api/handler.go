@@ -20,3 +20,21 @@ func RespondWithJSON(w http.ResponseWriter, code int, payload any) {
 	w.WriteHeader(code)
 	w.Write(content)
 }
+
+// FieldError describes why a request field is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// RespondWithFieldErrors responds with the list of invalid fields.
+func RespondWithFieldErrors(w http.ResponseWriter, code int, errs []FieldError) {
+	RespondWithJSON(w, code, struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}{
+		Error:  "invalid parameters",
+		Fields: errs,
+	})
+}
@@ -0,0 +1,221 @@
+package like
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// LikeStorer stores the likes of the chirps.
+type LikeStorer interface {
+	// LikeChirp is idempotent, created reports whether the like is new.
+	LikeChirp(userID, chirpID int) (like db.Like, created bool, err error)
+	// UnlikeChirp is idempotent, deleted reports whether there was a like to remove.
+	UnlikeChirp(userID, chirpID int) (deleted bool, err error)
+	CountLikes(chirpIDs []int) (map[int]int, error)
+	ListLikedChirps(userID int, chirpIDs []int) (map[int]bool, error)
+	// ListLikes returns the likes of a chirp the most recent first, before the like beforeID if not 0.
+	ListLikes(chirpID, beforeID, limit int) ([]db.Like, bool, error)
+}
+
+type Storer interface {
+	LikeStorer
+	GetUser(id int) (*db.User, error)
+	GetChirp(id int) (*db.Chirp, error)
+	ListHiddenAuthorIDs(viewerID int) ([]int, error)
+}
+
+// Visibility reports whether a chirp is visible to the caller of a request.
+type Visibility func(r *http.Request, chirp db.Chirp) bool
+
+type Handler struct {
+	db      Storer
+	cursors *cursor.Signer
+	canSee  Visibility
+}
+
+// NewHandler returns a new handler. Every chirp is visible by default.
+func NewHandler(db Storer, cursors *cursor.Signer) *Handler {
+	return &Handler{db: db, cursors: cursors, canSee: everyChirp}
+}
+
+func everyChirp(*http.Request, db.Chirp) bool { return true }
+
+// WithVisibility sets which chirps the likers can be listed of.
+func (h *Handler) WithVisibility(canSee Visibility) *Handler {
+	h.canSee = canSee
+	return h
+}
+
+// StateResponse is the like state of a chirp for the authenticated user.
+type StateResponse struct {
+	ChirpID   int  `json:"chirp_id"`
+	LikeCount int  `json:"like_count"`
+	LikedByMe bool `json:"liked_by_me"`
+}
+
+// LikerResponse is a user who liked a chirp.
+type LikerResponse struct {
+	db.PublicProfile
+	LikedAt time.Time `json:"liked_at"`
+}
+
+// LikersResponse is a page of the users who liked a chirp.
+type LikersResponse struct {
+	Users      []LikerResponse `json:"users"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// likeCursor is the position in the likes of a chirp.
+type likeCursor struct {
+	ChirpID int `json:"chirp_id"`
+	LikeID  int `json:"like_id"`
+}
+
+// Like likes a chirp for the authenticated user. Liking a chirp twice is a no-op.
+func (h *Handler) Like(w http.ResponseWriter, r *http.Request) {
+	h.setLike(w, r, true)
+}
+
+// Unlike removes the like of the authenticated user. Unliking a chirp not liked is a no-op.
+func (h *Handler) Unlike(w http.ResponseWriter, r *http.Request) {
+	h.setLike(w, r, false)
+}
+
+func (h *Handler) setLike(w http.ResponseWriter, r *http.Request, liked bool) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if liked {
+		_, _, err = h.db.LikeChirp(principal.UserID, chirpID)
+	} else {
+		_, err = h.db.UnlikeChirp(principal.UserID, chirpID)
+	}
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	counts, err := h.db.CountLikes([]int{chirpID})
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, StateResponse{ChirpID: chirpID, LikeCount: counts[chirpID], LikedByMe: liked})
+}
+
+// Likers returns a page of the users who liked a chirp, the most recent first.
+// The likers of a chirp the caller cannot see are not found.
+func (h *Handler) Likers(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Before != "" {
+		api.RespondWithError(w, http.StatusBadRequest, "before is not supported by likes")
+		return
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+
+	beforeID := 0
+	if params.After != "" {
+		var position likeCursor
+		if err := h.cursors.Decode(params.After, &position); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if position.ChirpID != chirpID {
+			api.RespondWithError(w, http.StatusBadRequest, "cursor does not match the chirp")
+			return
+		}
+		beforeID = position.LikeID
+	}
+
+	chirp, err := h.db.GetChirp(chirpID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if !h.canSee(r, *chirp) {
+		api.RespondWithError(w, http.StatusNotFound, db.ErrNotFound.Error())
+		return
+	}
+
+	principal, _ := api.PrincipalFromContext(r.Context())
+	hidden, err := h.db.ListHiddenAuthorIDs(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	likes, more, err := h.db.ListLikes(chirpID, beforeID, limit)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := LikersResponse{Users: make([]LikerResponse, 0, len(likes))}
+	for _, like := range likes {
+		// the users blocked by, blocking or muted by the caller are left out.
+		if slices.Contains(hidden, like.UserID) {
+			continue
+		}
+		// the likes of deleted users are deleted with them.
+		user, err := h.db.GetUser(like.UserID)
+		if err != nil {
+			continue
+		}
+		resp.Users = append(resp.Users, LikerResponse{PublicProfile: user.PublicProfile(), LikedAt: like.CreatedAt})
+	}
+	if more {
+		if resp.NextCursor, err = h.cursors.Encode(likeCursor{ChirpID: chirpID, LikeID: likes[len(likes)-1].ID}); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, "")
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,117 @@
+package like
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api/apitest"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *db.DB) {
+	t.Helper()
+	store := apitest.NewDB(t, "author@example.com", "fan@example.com", "other@example.com")
+	if _, err := store.CreateChirp(db.Chirp{Body: "hello", AuthorID: 1}); err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+
+	return NewHandler(store, cursor.NewSigner("secret")), store
+}
+
+func TestHandler_Like(t *testing.T) {
+	h, _ := newTestHandler(t)
+	state := func(rw *httptest.ResponseRecorder) StateResponse {
+		t.Helper()
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rw.Code, rw.Body.String())
+		}
+		var resp StateResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// liking and unliking twice are no-ops.
+	for i := 0; i < 2; i++ {
+		if got := state(apitest.Serve(h.Like, http.MethodPost, "/", "", 2, "id", "1")); got.LikeCount != 1 || !got.LikedByMe {
+			t.Errorf("Like() = %+v, want one like by me", got)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if got := state(apitest.Serve(h.Unlike, http.MethodDelete, "/", "", 2, "id", "1")); got.LikeCount != 0 || got.LikedByMe {
+			t.Errorf("Unlike() = %+v, want no like", got)
+		}
+	}
+
+	if rw := apitest.Serve(h.Like, http.MethodPost, "/", "", 0, "id", "1"); rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an anonymous like to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Like, http.MethodPost, "/", "", 2, "id", "one"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid id to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Like, http.MethodPost, "/", "", 2, "id", "42"); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected the like of a missing chirp to be rejected, got %d", rw.Code)
+	}
+}
+
+func TestHandler_Likers(t *testing.T) {
+	h, store := newTestHandler(t)
+	if _, err := store.CreateChirp(db.Chirp{Body: "other", AuthorID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []int{2, 3} {
+		if _, _, err := store.LikeChirp(userID, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	likers := func(rw *httptest.ResponseRecorder) LikersResponse {
+		t.Helper()
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rw.Code, rw.Body.String())
+		}
+		var resp LikersResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	first := likers(apitest.Serve(h.Likers, http.MethodGet, "/?limit=1", "", 0, "id", "1"))
+	if len(first.Users) != 1 || first.Users[0].ID != 3 || first.NextCursor == "" {
+		t.Fatalf("Likers() = %+v, want the last liker and a cursor", first)
+	}
+	second := likers(apitest.Serve(h.Likers, http.MethodGet, "/?limit=1&after="+first.NextCursor, "", 0, "id", "1"))
+	if len(second.Users) != 1 || second.Users[0].ID != 2 || second.NextCursor != "" {
+		t.Errorf("Likers() = %+v, want the first liker and no cursor", second)
+	}
+
+	if rw := apitest.Serve(h.Likers, http.MethodGet, "/?after="+first.NextCursor, "", 0, "id", "2"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected the cursor of another chirp to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Likers, http.MethodGet, "/?after=garbage", "", 0, "id", "1"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid cursor to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Likers, http.MethodGet, "/?before="+first.NextCursor, "", 0, "id", "1"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected before to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Likers, http.MethodGet, "/", "", 0, "id", "42"); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected the likers of a missing chirp not to be found, got %d", rw.Code)
+	}
+
+	// the muted likers are left out.
+	if _, _, err := store.MuteUser(2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := likers(apitest.Serve(h.Likers, http.MethodGet, "/", "", 2, "id", "1")); len(got.Users) != 1 || got.Users[0].ID != 2 {
+		t.Errorf("Likers() = %+v, want the likers not muted", got)
+	}
+
+	h.WithVisibility(func(*http.Request, db.Chirp) bool { return false })
+	if rw := apitest.Serve(h.Likers, http.MethodGet, "/", "", 0, "id", "1"); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected the likers of a hidden chirp not to be found, got %d", rw.Code)
+	}
+}
@@ -0,0 +1,277 @@
+package notification
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// NotificationStorer stores the notifications of the users.
+type NotificationStorer interface {
+	// ListNotifications returns the notifications the most recent first, before the notification beforeID if not 0.
+	ListNotifications(userID, beforeID, limit int, unreadOnly bool) ([]db.Notification, bool, error)
+	CountUnreadNotifications(userID int) (int, error)
+	// MarkNotificationsRead marks all the notifications of the user when ids is nil.
+	MarkNotificationsRead(userID int, ids []int) (int, error)
+	SetMutedNotifications(userID int, types []string) (db.User, error)
+}
+
+type Storer interface {
+	NotificationStorer
+	GetUser(id int) (*db.User, error)
+}
+
+type Handler struct {
+	db      Storer
+	cursors *cursor.Signer
+}
+
+// NewHandler returns a new handler.
+func NewHandler(db Storer, cursors *cursor.Signer) *Handler {
+	return &Handler{db: db, cursors: cursors}
+}
+
+// NotificationResponse is a notification with the profile of its actor.
+type NotificationResponse struct {
+	db.Notification
+	// Actor is nil for the notifications without actor.
+	Actor *db.PublicProfile `json:"actor,omitempty"`
+}
+
+// NotificationsResponse is a page of notifications.
+type NotificationsResponse struct {
+	Notifications []NotificationResponse `json:"notifications"`
+	NextCursor    string                 `json:"next_cursor,omitempty"`
+}
+
+// UnreadResponse is the number of unread notifications.
+type UnreadResponse struct {
+	UnreadCount int `json:"unread_count"`
+}
+
+// ReadParameters select the notifications to mark as read.
+type ReadParameters struct {
+	IDs []int `json:"ids"`
+	All bool  `json:"all"`
+}
+
+// ReadResponse is the result of marking notifications as read.
+type ReadResponse struct {
+	Marked      int `json:"marked"`
+	UnreadCount int `json:"unread_count"`
+}
+
+// PreferencesResponse holds the notification types muted by a user.
+type PreferencesResponse struct {
+	Muted []string `json:"muted"`
+}
+
+// notificationCursor is the position in the notifications of a user.
+type notificationCursor struct {
+	UserID         int  `json:"user_id"`
+	UnreadOnly     bool `json:"unread_only"`
+	NotificationID int  `json:"notification_id"`
+}
+
+// List returns a page of the notifications of the authenticated user, the most recent first.
+// Only the unread notifications are returned with ?unread=true.
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	unreadOnly := false
+	if value := r.URL.Query().Get("unread"); value != "" {
+		var err error
+		if unreadOnly, err = strconv.ParseBool(value); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, "Invalid unread parameter")
+			return
+		}
+	}
+
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Before != "" {
+		api.RespondWithError(w, http.StatusBadRequest, "before is not supported by notifications")
+		return
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+
+	beforeID := 0
+	if params.After != "" {
+		var position notificationCursor
+		if err := h.cursors.Decode(params.After, &position); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if position.UserID != principal.UserID || position.UnreadOnly != unreadOnly {
+			api.RespondWithError(w, http.StatusBadRequest, "cursor does not match the user and unread parameter")
+			return
+		}
+		beforeID = position.NotificationID
+	}
+
+	notifications, more, err := h.db.ListNotifications(principal.UserID, beforeID, limit, unreadOnly)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	profiles := map[int]*db.PublicProfile{}
+	resp := NotificationsResponse{Notifications: make([]NotificationResponse, 0, len(notifications))}
+	for _, notification := range notifications {
+		notificationResp := NotificationResponse{Notification: notification}
+		if notification.ActorID != 0 {
+			profile, ok := profiles[notification.ActorID]
+			if !ok {
+				if actor, err := h.db.GetUser(notification.ActorID); err == nil {
+					p := actor.PublicProfile()
+					profile = &p
+				}
+				profiles[notification.ActorID] = profile
+			}
+			notificationResp.Actor = profile
+		}
+		resp.Notifications = append(resp.Notifications, notificationResp)
+	}
+	if more {
+		position := notificationCursor{UserID: principal.UserID, UnreadOnly: unreadOnly, NotificationID: notifications[len(notifications)-1].ID}
+		if resp.NextCursor, err = h.cursors.Encode(position); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, "")
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// Unread returns the number of unread notifications of the authenticated user.
+func (h *Handler) Unread(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	count, err := h.db.CountUnreadNotifications(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, UnreadResponse{UnreadCount: count})
+}
+
+// Read marks notifications of the authenticated user as read, the listed ones or all of them.
+func (h *Handler) Read(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := ReadParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.All == (len(params.IDs) > 0) {
+		api.RespondWithError(w, http.StatusBadRequest, "either ids or all must be set")
+		return
+	}
+
+	ids := params.IDs
+	if params.All {
+		ids = nil
+	}
+	marked, err := h.db.MarkNotificationsRead(principal.UserID, ids)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	count, err := h.db.CountUnreadNotifications(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, ReadResponse{Marked: marked, UnreadCount: count})
+}
+
+// GetPreferences returns the notification types muted by the authenticated user.
+func (h *Handler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	u, err := h.db.GetUser(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, newPreferencesResponse(*u))
+}
+
+// UpdatePreferences replaces the notification types muted by the authenticated user.
+func (h *Handler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := PreferencesResponse{}
+	if err := decoder.Decode(&params); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	u, err := h.db.SetMutedNotifications(principal.UserID, params.Muted)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidNotificationType) {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, newPreferencesResponse(u))
+}
+
+func newPreferencesResponse(u db.User) PreferencesResponse {
+	muted := u.MutedNotifications
+	if muted == nil {
+		muted = []string{}
+	}
+	return PreferencesResponse{Muted: muted}
+}
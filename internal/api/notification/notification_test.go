@@ -0,0 +1,109 @@
+package notification
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api/apitest"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+// newTestHandler returns a handler whose first user is followed by the two other ones.
+func newTestHandler(t *testing.T) (*Handler, *db.DB) {
+	t.Helper()
+	store := apitest.NewDB(t, "star@example.com", "fan@example.com", "other@example.com")
+	for _, followerID := range []int{2, 3} {
+		if _, _, err := store.FollowUser(followerID, 1); err != nil {
+			t.Fatalf("FollowUser should not have an error %v", err)
+		}
+	}
+
+	return NewHandler(store, cursor.NewSigner("secret")), store
+}
+
+func decode[T any](t *testing.T, rw *httptest.ResponseRecorder) T {
+	t.Helper()
+	var resp T
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rw.Code, rw.Body.String())
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHandler_List(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	first := decode[NotificationsResponse](t, apitest.Serve(h.List, http.MethodGet, "/?limit=1", "", 1))
+	if len(first.Notifications) != 1 || first.Notifications[0].Actor == nil || first.Notifications[0].Actor.ID != 3 || first.NextCursor == "" {
+		t.Fatalf("List() = %+v, want the last follow and a cursor", first)
+	}
+	second := decode[NotificationsResponse](t, apitest.Serve(h.List, http.MethodGet, "/?limit=1&after="+first.NextCursor, "", 1))
+	if len(second.Notifications) != 1 || second.Notifications[0].ActorID != 2 || second.NextCursor != "" {
+		t.Errorf("List() = %+v, want the first follow and no cursor", second)
+	}
+
+	// a cursor only pages through the notifications of its user, with the same filter.
+	if rw := apitest.Serve(h.List, http.MethodGet, "/?after="+first.NextCursor, "", 2); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected the cursor of another user to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.List, http.MethodGet, "/?unread=true&after="+first.NextCursor, "", 1); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected the cursor of another filter to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.List, http.MethodGet, "/?unread=maybe", "", 1); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid unread parameter to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.List, http.MethodGet, "/", "", 0); rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an anonymous request to be rejected, got %d", rw.Code)
+	}
+}
+
+func TestHandler_Read(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	if got := decode[UnreadResponse](t, apitest.Serve(h.Unread, http.MethodGet, "/", "", 1)); got.UnreadCount != 2 {
+		t.Errorf("Unread() = %+v, want 2 unread notifications", got)
+	}
+	if got := decode[ReadResponse](t, apitest.Serve(h.Read, http.MethodPost, "/", `{"ids":[1]}`, 1)); got.Marked != 1 || got.UnreadCount != 1 {
+		t.Errorf("Read() = %+v, want one marked and one unread", got)
+	}
+	// marking again is a no-op.
+	if got := decode[ReadResponse](t, apitest.Serve(h.Read, http.MethodPost, "/", `{"ids":[1]}`, 1)); got.Marked != 0 || got.UnreadCount != 1 {
+		t.Errorf("Read() = %+v, want none marked and one unread", got)
+	}
+	// the notifications of another user are left untouched.
+	if got := decode[ReadResponse](t, apitest.Serve(h.Read, http.MethodPost, "/", `{"ids":[2]}`, 2)); got.Marked != 0 {
+		t.Errorf("Read() = %+v, want none marked", got)
+	}
+	if got := decode[ReadResponse](t, apitest.Serve(h.Read, http.MethodPost, "/", `{"all":true}`, 1)); got.Marked != 1 || got.UnreadCount != 0 {
+		t.Errorf("Read() = %+v, want one marked and none unread", got)
+	}
+
+	for _, body := range []string{`{}`, `{"ids":[1],"all":true}`, `not json`} {
+		if rw := apitest.Serve(h.Read, http.MethodPost, "/", body, 1); rw.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", body, rw.Code)
+		}
+	}
+}
+
+func TestHandler_UpdatePreferences(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	if got := decode[PreferencesResponse](t, apitest.Serve(h.GetPreferences, http.MethodGet, "/", "", 1)); got.Muted == nil || len(got.Muted) != 0 {
+		t.Errorf("GetPreferences() = %+v, want an empty list", got)
+	}
+	if got := decode[PreferencesResponse](t, apitest.Serve(h.UpdatePreferences, http.MethodPut, "/", `{"muted":["like"]}`, 1)); len(got.Muted) != 1 || got.Muted[0] != db.NotificationLike {
+		t.Errorf("UpdatePreferences() = %+v, want the likes muted", got)
+	}
+	if rw := apitest.Serve(h.UpdatePreferences, http.MethodPut, "/", `{"muted":["gossip"]}`, 1); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown type to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.UpdatePreferences, http.MethodPut, "/", `{"muted":[]}`, 42); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing user to be rejected, got %d", rw.Code)
+	}
+}
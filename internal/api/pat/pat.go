@@ -0,0 +1,171 @@
+package pat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+type PersonalAccessTokenStorer interface {
+	CreatePersonalAccessToken(userID int, name, hash string, scopes []string) (db.PersonalAccessToken, error)
+	ListPersonalAccessTokens(userID int) ([]db.PersonalAccessToken, error)
+	GetPersonalAccessTokenByHash(hash string) (*db.PersonalAccessToken, error)
+	RevokePersonalAccessToken(userID, id int) error
+}
+
+type Handler struct {
+	db PersonalAccessTokenStorer
+}
+
+// NewHandler returns a new handler.
+func NewHandler(db PersonalAccessTokenStorer) *Handler {
+	return &Handler{db: db}
+}
+
+type Parameters struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type Response struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// Token is only returned once, at creation.
+	Token string `json:"token,omitempty"`
+}
+
+func newResponse(pat db.PersonalAccessToken) Response {
+	return Response{
+		ID:         pat.ID,
+		Name:       pat.Name,
+		Scopes:     pat.Scopes,
+		CreatedAt:  pat.CreatedAt,
+		LastUsedAt: pat.LastUsedAt,
+		RevokedAt:  pat.RevokedAt,
+	}
+}
+
+// Create creates a new personal access token for the authenticated user.
+// A personal access token cannot be used to create another one.
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if principal.PersonalAccessToken {
+		api.RespondWithError(w, http.StatusForbidden, "personal access tokens cannot create tokens")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := Parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params.Name = strings.TrimSpace(params.Name)
+	if params.Name == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(params.Scopes) == 0 {
+		api.RespondWithError(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !token.IsValidScope(scope) {
+			api.RespondWithError(w, http.StatusBadRequest, "invalid scope "+scope)
+			return
+		}
+	}
+
+	raw, err := token.NewPersonalAccessToken()
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	pat, err := h.db.CreatePersonalAccessToken(principal.UserID, params.Name, token.HashPersonalAccessToken(raw), params.Scopes)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := newResponse(pat)
+	resp.Token = raw
+
+	api.RespondWithJSON(w, http.StatusCreated, resp)
+}
+
+// List returns the personal access tokens of the authenticated user.
+// A personal access token cannot be used to list the tokens.
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if principal.PersonalAccessToken {
+		api.RespondWithError(w, http.StatusForbidden, "personal access tokens cannot list tokens")
+		return
+	}
+
+	pats, err := h.db.ListPersonalAccessTokens(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := make([]Response, 0, len(pats))
+	for _, pat := range pats {
+		resp = append(resp, newResponse(pat))
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// Revoke revokes a personal access token of the authenticated user.
+// A personal access token cannot be used to revoke a token.
+func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if principal.PersonalAccessToken {
+		api.RespondWithError(w, http.StatusForbidden, "personal access tokens cannot revoke tokens")
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := h.db.RevokePersonalAccessToken(principal.UserID, id); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
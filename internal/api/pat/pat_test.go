@@ -0,0 +1,96 @@
+package pat
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/apitest"
+	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *db.DB) {
+	t.Helper()
+	store := apitest.NewDB(t)
+
+	return NewHandler(store), store
+}
+
+func TestHandler_Create(t *testing.T) {
+	h, store := newTestHandler(t)
+	owner := api.Principal{UserID: 1}
+
+	rw := apitest.ServeAs(h.Create, http.MethodPost, "/api/tokens", `{"name":" cli ","scopes":["chirps:read"]}`, owner)
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+	var created Response
+	if err := json.Unmarshal(rw.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != "cli" || !token.IsPersonalAccessToken(created.Token) {
+		t.Errorf("Create() = %+v, want a trimmed name and a token", created)
+	}
+	// only the hash of the token is stored.
+	if pat, err := store.GetPersonalAccessTokenByHash(token.HashPersonalAccessToken(created.Token)); err != nil || pat.ID != created.ID {
+		t.Errorf("GetPersonalAccessTokenByHash() = %v, %v, want the created token", pat, err)
+	}
+
+	tests := []struct {
+		name      string
+		body      string
+		principal api.Principal
+		want      int
+	}{
+		{name: "personal access token", body: `{"name":"cli","scopes":["chirps:read"]}`, principal: api.Principal{UserID: 1, PersonalAccessToken: true}, want: http.StatusForbidden},
+		{name: "invalid body", body: `{`, principal: owner, want: http.StatusBadRequest},
+		{name: "blank name", body: `{"name":" ","scopes":["chirps:read"]}`, principal: owner, want: http.StatusBadRequest},
+		{name: "no scope", body: `{"name":"cli","scopes":[]}`, principal: owner, want: http.StatusBadRequest},
+		{name: "unknown scope", body: `{"name":"cli","scopes":["admin"]}`, principal: owner, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rw := apitest.ServeAs(h.Create, http.MethodPost, "/api/tokens", tt.body, tt.principal); rw.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rw.Code, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_Revoke(t *testing.T) {
+	h, store := newTestHandler(t)
+	if _, err := store.CreatePersonalAccessToken(1, "cli", "hash", []string{token.ScopeChirpsRead}); err != nil {
+		t.Fatal(err)
+	}
+	owner := api.Principal{UserID: 1}
+
+	if rw := apitest.ServeAs(h.Revoke, http.MethodDelete, "/api/tokens", "", api.Principal{UserID: 1, PersonalAccessToken: true}, "id", "1"); rw.Code != http.StatusForbidden {
+		t.Errorf("Expected a personal access token to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.ServeAs(h.Revoke, http.MethodDelete, "/api/tokens", "", api.Principal{UserID: 2}, "id", "1"); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected the token of another user not to be found, got %d", rw.Code)
+	}
+	if rw := apitest.ServeAs(h.Revoke, http.MethodDelete, "/api/tokens", "", owner, "id", "one"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid id to be rejected, got %d", rw.Code)
+	}
+	// revoking twice is a no-op.
+	for i := 0; i < 2; i++ {
+		if rw := apitest.ServeAs(h.Revoke, http.MethodDelete, "/api/tokens", "", owner, "id", "1"); rw.Code != http.StatusNoContent {
+			t.Errorf("Expected status 204, got %d: %s", rw.Code, rw.Body.String())
+		}
+	}
+
+	if rw := apitest.ServeAs(h.List, http.MethodGet, "/api/tokens", "", api.Principal{UserID: 1, PersonalAccessToken: true}); rw.Code != http.StatusForbidden {
+		t.Errorf("Expected a personal access token to be rejected, got %d", rw.Code)
+	}
+	rw := apitest.ServeAs(h.List, http.MethodGet, "/api/tokens", "", owner)
+	var pats []Response
+	if err := json.Unmarshal(rw.Body.Bytes(), &pats); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("List() = %d %v", rw.Code, err)
+	}
+	if len(pats) != 1 || pats[0].RevokedAt == nil || pats[0].Token != "" {
+		t.Errorf("List() = %+v, want the revoked token without its value", pats)
+	}
+}
@@ -0,0 +1,275 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+// ReportResponse is a report with the reported chirp and user, as seen by the admins.
+type ReportResponse struct {
+	db.Report
+	// Chirp is nil when the chirp has been deleted, it is returned even if hidden.
+	Chirp *db.Chirp `json:"chirp,omitempty"`
+	// User is nil when the account has been deleted.
+	User *db.PublicProfile `json:"user,omitempty"`
+	// Suspension is the current suspension of the reported user.
+	Suspension *db.Suspension `json:"suspension,omitempty"`
+}
+
+// ReportsResponse is a page of the moderation queue.
+type ReportsResponse struct {
+	Reports    []ReportResponse `json:"reports"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// DecisionParameters are the fields of a moderation decision.
+// The chirp and the user default to the ones of the first report.
+type DecisionParameters struct {
+	Action    string `json:"action"`
+	ReportIDs []int  `json:"report_ids"`
+	ChirpID   int    `json:"chirp_id"`
+	UserID    int    `json:"user_id"`
+	Note      string `json:"note"`
+	// Duration is how long a suspension lasts, a Go duration. A suspension without duration is permanent.
+	Duration string `json:"duration"`
+}
+
+// DecisionsResponse is a page of the moderation decisions.
+type DecisionsResponse struct {
+	Decisions  []db.Decision `json:"decisions"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// reportCursor is the position in the moderation queue.
+type reportCursor struct {
+	Status   string `json:"status"`
+	ReportID int    `json:"report_id"`
+}
+
+// decisionCursor is the position in the moderation decisions.
+type decisionCursor struct {
+	DecisionID int `json:"decision_id"`
+}
+
+// Queue returns a page of the reports to triage, the oldest first.
+// The status parameter selects the open (default) or resolved reports, or all of them with "all".
+func (h *Handler) Queue(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = db.ReportOpen
+	case "all":
+		status = ""
+	case db.ReportOpen, db.ReportResolved:
+	default:
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid status parameter")
+		return
+	}
+
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Before != "" {
+		api.RespondWithError(w, http.StatusBadRequest, "before is not supported by the moderation queue")
+		return
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+
+	afterID := 0
+	if params.After != "" {
+		var position reportCursor
+		if err := h.cursors.Decode(params.After, &position); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if position.Status != status {
+			api.RespondWithError(w, http.StatusBadRequest, "cursor does not match the status parameter")
+			return
+		}
+		afterID = position.ReportID
+	}
+
+	reports, more, err := h.db.ListReports(status, afterID, limit)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := ReportsResponse{Reports: make([]ReportResponse, 0, len(reports))}
+	for _, report := range reports {
+		resp.Reports = append(resp.Reports, h.newReportResponse(report))
+	}
+	if more {
+		position := reportCursor{Status: status, ReportID: reports[len(reports)-1].ID}
+		if resp.NextCursor, err = h.cursors.Encode(position); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, "")
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
+
+// Get returns a single report.
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := h.db.GetReport(id)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, h.newReportResponse(*report))
+}
+
+func (h *Handler) newReportResponse(report db.Report) ReportResponse {
+	resp := ReportResponse{Report: report}
+	if report.ChirpID != 0 {
+		if c, err := h.db.GetChirp(report.ChirpID); err == nil {
+			resp.Chirp = c
+		}
+	}
+	if u, err := h.db.GetUser(report.UserID); err == nil {
+		profile := u.PublicProfile()
+		resp.User = &profile
+		if u.IsSuspended() {
+			resp.Suspension = u.Suspension
+		}
+	}
+
+	return resp
+}
+
+// Decide records a moderation decision of the authenticated admin and applies it.
+func (h *Handler) Decide(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := DecisionParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var expiresAt *time.Time
+	if params.Duration != "" {
+		if params.Action != db.ActionSuspend {
+			api.RespondWithError(w, http.StatusBadRequest, "duration is only supported by suspensions")
+			return
+		}
+		duration, err := time.ParseDuration(params.Duration)
+		if err != nil || duration <= 0 {
+			api.RespondWithError(w, http.StatusBadRequest, "Invalid duration")
+			return
+		}
+		expires := time.Now().UTC().Add(duration)
+		expiresAt = &expires
+	}
+
+	decision, err := h.db.Decide(db.Decision{
+		AdminID:   principal.UserID,
+		Action:    params.Action,
+		ReportIDs: params.ReportIDs,
+		ChirpID:   params.ChirpID,
+		UserID:    params.UserID,
+		Note:      params.Note,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidDecision):
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, db.ErrNotFound):
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+		default:
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	// the streams drop the hidden chirps. Unhidden chirps are not streamed again,
+	// the timelines filter the hidden chirps when they are read.
+	if decision.Action == db.ActionHideChirp {
+		if hidden, err := h.db.GetChirp(decision.ChirpID); err == nil {
+			for _, listener := range h.listeners {
+				listener.ChirpDeleted(*hidden)
+			}
+		} else {
+			log.Printf("notify hidden chirp %d: %v", decision.ChirpID, err)
+		}
+	}
+
+	api.RespondWithJSON(w, http.StatusCreated, decision)
+}
+
+// Decisions returns a page of the moderation decisions, the most recent first.
+func (h *Handler) Decisions(w http.ResponseWriter, r *http.Request) {
+	params, err := cursor.ParseParams(r, maxPageSize)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Before != "" {
+		api.RespondWithError(w, http.StatusBadRequest, "before is not supported by the decisions")
+		return
+	}
+	limit := params.Limit
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+
+	beforeID := 0
+	if params.After != "" {
+		var position decisionCursor
+		if err := h.cursors.Decode(params.After, &position); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		beforeID = position.DecisionID
+	}
+
+	decisions, more, err := h.db.ListDecisions(beforeID, limit)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := DecisionsResponse{Decisions: decisions}
+	if resp.Decisions == nil {
+		resp.Decisions = []db.Decision{}
+	}
+	if more {
+		position := decisionCursor{DecisionID: decisions[len(decisions)-1].ID}
+		if resp.NextCursor, err = h.cursors.Encode(position); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	cursor.SetLinks(w, r, resp.NextCursor, "")
+	api.RespondWithJSON(w, http.StatusOK, resp)
+}
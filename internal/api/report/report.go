@@ -0,0 +1,183 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/chirp"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	maxCommentLength = 500
+	maxAppealLength  = 1000
+)
+
+// ReportStorer stores the reports, the moderation decisions and the suspensions.
+type ReportStorer interface {
+	CreateReport(report db.Report) (db.Report, error)
+	GetReport(id int) (*db.Report, error)
+	// ListReports returns the reports the oldest first, after the report afterID.
+	ListReports(status string, afterID, limit int) ([]db.Report, bool, error)
+	// ListDecisions returns the decisions the most recent first, before the decision beforeID if not 0.
+	ListDecisions(beforeID, limit int) ([]db.Decision, bool, error)
+	Decide(decision db.Decision) (db.Decision, error)
+	AppealSuspension(userID int, message string) (db.Suspension, error)
+}
+
+type Storer interface {
+	ReportStorer
+	GetUser(id int) (*db.User, error)
+	GetChirp(id int) (*db.Chirp, error)
+}
+
+type Handler struct {
+	db        Storer
+	cursors   *cursor.Signer
+	listeners []chirp.Listener
+}
+
+// NewHandler returns a new handler.
+func NewHandler(db Storer, cursors *cursor.Signer) *Handler {
+	return &Handler{db: db, cursors: cursors}
+}
+
+// WithListener adds a listener told about the chirps hidden by the moderators, as if they were deleted.
+func (h *Handler) WithListener(listener chirp.Listener) *Handler {
+	h.listeners = append(h.listeners, listener)
+	return h
+}
+
+// ReportParameters are the fields of a new report, which targets either a chirp or a user.
+type ReportParameters struct {
+	ChirpID int    `json:"chirp_id"`
+	UserID  int    `json:"user_id"`
+	Reason  string `json:"reason"`
+	Comment string `json:"comment"`
+}
+
+// SuspensionResponse is the suspension of a user, which may have expired.
+type SuspensionResponse struct {
+	db.Suspension
+	Active bool `json:"active"`
+}
+
+// AppealParameters are the fields of an appeal.
+type AppealParameters struct {
+	Message string `json:"message"`
+}
+
+// Create reports a chirp or a user to the moderators.
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := ReportParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if (params.ChirpID == 0) == (params.UserID == 0) {
+		api.RespondWithError(w, http.StatusBadRequest, "either chirp_id or user_id must be set")
+		return
+	}
+	if utf8.RuneCountInString(params.Comment) > maxCommentLength {
+		api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Comment must be at most %d characters long", maxCommentLength))
+		return
+	}
+
+	report, err := h.db.CreateReport(db.Report{
+		ReporterID: principal.UserID,
+		ChirpID:    params.ChirpID,
+		UserID:     params.UserID,
+		Reason:     params.Reason,
+		Comment:    params.Comment,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrInvalidReport):
+			api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, db.ErrNotFound):
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, db.ErrAlreadyExists):
+			api.RespondWithError(w, http.StatusConflict, err.Error())
+		default:
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusCreated, report)
+}
+
+// Suspension returns the last suspension of the authenticated user, with the status of its appeal.
+func (h *Handler) Suspension(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	u, err := h.db.GetUser(principal.UserID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if u.Suspension == nil {
+		api.RespondWithError(w, http.StatusNotFound, "suspension not found")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, SuspensionResponse{Suspension: *u.Suspension, Active: u.IsSuspended()})
+}
+
+// Appeal asks the moderators to lift the suspension of the authenticated user.
+func (h *Handler) Appeal(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := AppealParameters{}
+	if err := decoder.Decode(&params); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if params.Message == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "Missing message")
+		return
+	}
+	if utf8.RuneCountInString(params.Message) > maxAppealLength {
+		api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Message must be at most %d characters long", maxAppealLength))
+		return
+	}
+
+	suspension, err := h.db.AppealSuspension(principal.UserID, params.Message)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotFound):
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, db.ErrAlreadyExists):
+			api.RespondWithError(w, http.StatusConflict, err.Error())
+		default:
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusCreated, SuspensionResponse{Suspension: suspension, Active: true})
+}
@@ -0,0 +1,168 @@
+package report
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api/apitest"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+// newTestHandler returns a handler whose first user is an admin and second one wrote the first chirp.
+func newTestHandler(t *testing.T) (*Handler, *db.DB) {
+	t.Helper()
+	store := apitest.NewDB(t, "admin@example.com", "author@example.com", "reader@example.com", "other@example.com")
+	if _, err := store.CreateChirp(db.Chirp{Body: "hello", AuthorID: 2}); err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+
+	return NewHandler(store, cursor.NewSigner("secret")), store
+}
+
+type deletedChirps []int
+
+func (d *deletedChirps) ChirpCreated(db.Chirp) {}
+
+func (d *deletedChirps) ChirpDeleted(chirp db.Chirp) { *d = append(*d, chirp.ID) }
+
+func TestHandler_Create(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	if rw := apitest.Serve(h.Create, http.MethodPost, "/", `{"chirp_id":1,"reason":"spam"}`, 3); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		userID int
+		want   int
+	}{
+		{name: "anonymous", body: `{"chirp_id":1,"reason":"spam"}`, want: http.StatusUnauthorized},
+		{name: "duplicate", body: `{"chirp_id":1,"reason":"hate"}`, userID: 3, want: http.StatusConflict},
+		{name: "chirp and user", body: `{"chirp_id":1,"user_id":2,"reason":"spam"}`, userID: 3, want: http.StatusBadRequest},
+		{name: "no target", body: `{"reason":"spam"}`, userID: 3, want: http.StatusBadRequest},
+		{name: "unknown reason", body: `{"user_id":2,"reason":"boring"}`, userID: 3, want: http.StatusBadRequest},
+		{name: "long comment", body: `{"user_id":2,"reason":"other","comment":"` + strings.Repeat("é", maxCommentLength+1) + `"}`, userID: 3, want: http.StatusBadRequest},
+		{name: "yourself", body: `{"user_id":3,"reason":"spam"}`, userID: 3, want: http.StatusBadRequest},
+		{name: "missing chirp", body: `{"chirp_id":42,"reason":"spam"}`, userID: 3, want: http.StatusNotFound},
+		{name: "missing user", body: `{"user_id":42,"reason":"spam"}`, userID: 3, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rw := apitest.Serve(h.Create, http.MethodPost, "/", tt.body, tt.userID); rw.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rw.Code, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_Queue(t *testing.T) {
+	h, _ := newTestHandler(t)
+	for _, reporterID := range []int{3, 4} {
+		if rw := apitest.Serve(h.Create, http.MethodPost, "/", `{"chirp_id":1,"reason":"spam"}`, reporterID); rw.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+		}
+	}
+	queue := func(rw *httptest.ResponseRecorder) ReportsResponse {
+		t.Helper()
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rw.Code, rw.Body.String())
+		}
+		var resp ReportsResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	first := queue(apitest.Serve(h.Queue, http.MethodGet, "/?limit=1", "", 1))
+	if len(first.Reports) != 1 || first.Reports[0].ID != 1 || first.Reports[0].Chirp == nil || first.NextCursor == "" {
+		t.Fatalf("Queue() = %+v, want the oldest report with its chirp and a cursor", first)
+	}
+	second := queue(apitest.Serve(h.Queue, http.MethodGet, "/?limit=1&after="+first.NextCursor, "", 1))
+	if len(second.Reports) != 1 || second.Reports[0].ID != 2 || second.NextCursor != "" {
+		t.Errorf("Queue() = %+v, want the newest report and no cursor", second)
+	}
+
+	if rw := apitest.Serve(h.Queue, http.MethodGet, "/?status=all&after="+first.NextCursor, "", 1); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected the cursor of another status to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Queue, http.MethodGet, "/?status=closed", "", 1); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown status to be rejected, got %d", rw.Code)
+	}
+}
+
+func TestHandler_Decide(t *testing.T) {
+	h, store := newTestHandler(t)
+	var deleted deletedChirps
+	h.WithListener(&deleted)
+	if rw := apitest.Serve(h.Create, http.MethodPost, "/", `{"chirp_id":1,"reason":"spam"}`, 3); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "duration of a hidden chirp", body: `{"action":"hide_chirp","report_ids":[1],"duration":"1h"}`, want: http.StatusBadRequest},
+		{name: "invalid duration", body: `{"action":"suspend","report_ids":[1],"duration":"-1h"}`, want: http.StatusBadRequest},
+		{name: "unknown action", body: `{"action":"ban","report_ids":[1]}`, want: http.StatusBadRequest},
+		{name: "missing report", body: `{"action":"dismiss","report_ids":[42]}`, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rw := apitest.Serve(h.Decide, http.MethodPost, "/", tt.body, 1); rw.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rw.Code, rw.Body.String())
+			}
+		})
+	}
+
+	// the listeners drop the hidden chirps.
+	if rw := apitest.Serve(h.Decide, http.MethodPost, "/", `{"action":"hide_chirp","report_ids":[1]}`, 1); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+	if len(deleted) != 1 || deleted[0] != 1 {
+		t.Errorf("Expected the hidden chirp to be announced as deleted, got %v", deleted)
+	}
+	if report, err := store.GetReport(1); err != nil || report.Status != db.ReportResolved {
+		t.Errorf("GetReport() = %v, %v, want a resolved report", report, err)
+	}
+}
+
+func TestHandler_Appeal(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	if rw := apitest.Serve(h.Appeal, http.MethodPost, "/", `{"message":"sorry"}`, 2); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected the appeal without suspension to be rejected, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Suspension, http.MethodGet, "/", "", 2); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected no suspension, got %d", rw.Code)
+	}
+	if rw := apitest.Serve(h.Decide, http.MethodPost, "/", `{"action":"suspend","user_id":2,"duration":"1h"}`, 1); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	for _, body := range []string{`{}`, `{"message":"` + strings.Repeat("a", maxAppealLength+1) + `"}`} {
+		if rw := apitest.Serve(h.Appeal, http.MethodPost, "/", body, 2); rw.Code != http.StatusBadRequest {
+			t.Errorf("Expected an invalid appeal to be rejected, got %d", rw.Code)
+		}
+	}
+	if rw := apitest.Serve(h.Appeal, http.MethodPost, "/", `{"message":"sorry"}`, 2); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+	if rw := apitest.Serve(h.Appeal, http.MethodPost, "/", `{"message":"really sorry"}`, 2); rw.Code != http.StatusConflict {
+		t.Errorf("Expected a second appeal to be rejected, got %d", rw.Code)
+	}
+
+	rw := apitest.Serve(h.Suspension, http.MethodGet, "/", "", 2)
+	var suspension SuspensionResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &suspension); err != nil || !suspension.Active || suspension.Appeal == nil {
+		t.Errorf("Suspension() = %d %+v, want an active suspension with its appeal", rw.Code, suspension)
+	}
+}
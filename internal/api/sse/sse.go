@@ -0,0 +1,214 @@
+package sse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/stream"
+)
+
+// DefaultHeartbeat is how often a comment is sent to keep the idle streams open.
+const DefaultHeartbeat = 15 * time.Second
+
+// retryDelay is how long the clients wait before reconnecting, in milliseconds.
+const retryDelay = 3000
+
+type Storer interface {
+	ListFollowedIDs(userID int) ([]int, error)
+	ListHiddenAuthorIDs(viewerID int) ([]int, error)
+}
+
+type Handler struct {
+	db        Storer
+	broker    *stream.Broker
+	heartbeat time.Duration
+}
+
+// NewHandler returns a new handler.
+func NewHandler(db Storer, broker *stream.Broker) *Handler {
+	return &Handler{db: db, broker: broker, heartbeat: DefaultHeartbeat}
+}
+
+// WithHeartbeat sets how often a comment is sent on the idle streams.
+func (h *Handler) WithHeartbeat(heartbeat time.Duration) *Handler {
+	h.heartbeat = heartbeat
+	return h
+}
+
+// DeletedChirp is the data of a chirp.deleted event.
+type DeletedChirp struct {
+	ID int `json:"id"`
+}
+
+// filter selects the events sent to a client.
+type filter func(event stream.Event) bool
+
+// Stream pushes the created and deleted chirps as Server-Sent Events.
+// The events are filtered by author with ?author_id=, or by the timeline of the authenticated user with ?timeline=true.
+// The chirps of the users the authenticated user blocked, muted or is blocked by are left out.
+// A client reconnecting with the Last-Event-ID header first receives the kept events it missed.
+func (h *Handler) Stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	accept, err := h.parseFilter(r)
+	if err != nil {
+		if errors.Is(err, errUnauthorized) {
+			api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if principal, ok := api.PrincipalFromContext(r.Context()); ok {
+		accept = h.hiddenAuthorsFilter(principal.UserID, accept)
+	}
+
+	var lastEventID int64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		if lastEventID, err = strconv.ParseInt(value, 10, 64); err != nil || lastEventID < 0 {
+			api.RespondWithError(w, http.StatusBadRequest, "Invalid Last-Event-ID header")
+			return
+		}
+	}
+
+	sub, missed := h.broker.Subscribe(lastEventID)
+	defer sub.Cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// proxies must not buffer the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", retryDelay)
+
+	for _, event := range missed {
+		if err := h.send(w, event, accept); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// the server shuts down, or the client lagged too far behind and resumes with Last-Event-ID.
+				return
+			}
+			if err := h.send(w, event, accept); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// send writes an event if the filter accepts it.
+func (h *Handler) send(w http.ResponseWriter, event stream.Event, accept filter) error {
+	if !accept(event) {
+		return nil
+	}
+
+	var data any = event.Chirp
+	if event.Type == stream.EventChirpDeleted {
+		data = DeletedChirp{ID: event.Chirp.ID}
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("stream event %d: %v", event.ID, err)
+		return nil
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, payload)
+	return err
+}
+
+// errUnauthorized is returned when an anonymous request asks for a timeline.
+var errUnauthorized = errors.New("unauthorized")
+
+// parseFilter returns the filter of the author_id and timeline parameters.
+func (h *Handler) parseFilter(r *http.Request) (filter, error) {
+	query := r.URL.Query()
+	if query.Get("author_id") != "" && query.Get("timeline") != "" {
+		return nil, errors.New("author_id and timeline cannot be used together")
+	}
+
+	if value := query.Get("author_id"); value != "" {
+		authorID, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		return func(event stream.Event) bool { return event.Chirp.AuthorID == authorID }, nil
+	}
+
+	if value := query.Get("timeline"); value != "" {
+		timeline, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("invalid timeline parameter")
+		}
+		if timeline {
+			principal, ok := api.PrincipalFromContext(r.Context())
+			if !ok {
+				return nil, errUnauthorized
+			}
+			return h.timelineFilter(principal.UserID), nil
+		}
+	}
+
+	return func(stream.Event) bool { return true }, nil
+}
+
+// timelineFilter accepts the chirps of a user and of the users they follow.
+// The followed users are read again every stream.DefaultAuthorsTTL.
+func (h *Handler) timelineFilter(userID int) filter {
+	followed := stream.NewAuthors(func() ([]int, error) { return h.db.ListFollowedIDs(userID) }, 0)
+	return func(event stream.Event) bool {
+		if event.Chirp.AuthorID == userID {
+			return true
+		}
+		ok, err := followed.Contains(event.Chirp.AuthorID)
+		if err != nil {
+			log.Printf("stream timeline of %d: %v", userID, err)
+			return false
+		}
+		return ok
+	}
+}
+
+// hiddenAuthorsFilter wraps a filter to reject the chirps of the users hidden from a viewer.
+// The hidden users are read again every stream.DefaultAuthorsTTL.
+func (h *Handler) hiddenAuthorsFilter(viewerID int, accept filter) filter {
+	hidden := stream.NewAuthors(func() ([]int, error) { return h.db.ListHiddenAuthorIDs(viewerID) }, 0)
+	return func(event stream.Event) bool {
+		if !accept(event) {
+			return false
+		}
+		ok, err := hidden.Contains(event.Chirp.AuthorID)
+		if err != nil {
+			log.Printf("stream hidden authors of %d: %v", viewerID, err)
+			return false
+		}
+		return !ok
+	}
+}
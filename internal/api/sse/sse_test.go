@@ -0,0 +1,119 @@
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/stream"
+)
+
+// store is the follow graph of the user 1, who follows the user 2 and hides the user 3.
+type store struct{}
+
+func (store) ListFollowedIDs(int) ([]int, error) { return []int{2}, nil }
+
+func (store) ListHiddenAuthorIDs(int) ([]int, error) { return []int{3}, nil }
+
+// authenticated serves the requests on behalf of the user 1 when they carry an Authorization header.
+func authenticated(h *Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			r = r.WithContext(api.WithPrincipal(r.Context(), api.Principal{UserID: 1}))
+		}
+		h.Stream(w, r)
+	})
+}
+
+// open starts a stream and skips its retry field.
+func open(t *testing.T, url string, header http.Header) (*bufio.Scanner, func()) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() && lines.Text() != "" {
+	}
+	return lines, func() { resp.Body.Close() }
+}
+
+// next returns the next event of a stream.
+func next(t *testing.T, lines *bufio.Scanner) string {
+	t.Helper()
+	var event []string
+	for lines.Scan() && lines.Text() != "" {
+		event = append(event, lines.Text())
+	}
+	return strings.Join(event, "\n")
+}
+
+func TestHandler_Stream_Parameters(t *testing.T) {
+	h := NewHandler(store{}, stream.NewBroker(0))
+
+	tests := []struct {
+		name   string
+		target string
+		header http.Header
+		want   int
+	}{
+		{name: "author and timeline", target: "/?author_id=2&timeline=true", want: http.StatusBadRequest},
+		{name: "invalid author", target: "/?author_id=two", want: http.StatusBadRequest},
+		{name: "invalid timeline", target: "/?timeline=maybe", want: http.StatusBadRequest},
+		{name: "anonymous timeline", target: "/?timeline=true", want: http.StatusUnauthorized},
+		{name: "invalid last event id", target: "/", header: http.Header{"Last-Event-Id": {"-1"}}, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rw := httptest.NewRecorder()
+			authenticated(h).ServeHTTP(rw, req)
+			if rw.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rw.Code, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_Stream(t *testing.T) {
+	broker := stream.NewBroker(10)
+	server := httptest.NewServer(authenticated(NewHandler(store{}, broker)))
+	defer server.Close()
+
+	// the timeline leaves out the users not followed.
+	lines, closeStream := open(t, server.URL+"/?timeline=true", http.Header{"Authorization": {"Bearer token"}})
+	broker.ChirpCreated(db.Chirp{ID: 1, AuthorID: 4, Body: "not followed"})
+	broker.ChirpCreated(db.Chirp{ID: 2, AuthorID: 2, Body: "followed"})
+	if got, want := next(t, lines), "id: 2\nevent: chirp.created\ndata: {\"id\":2,\"author_id\":2,\"body\":\"followed\"}"; got != want {
+		t.Errorf("Expected event %q, got %q", want, got)
+	}
+	closeStream()
+
+	// the hidden users are left out.
+	lines, closeStream = open(t, server.URL+"/", http.Header{"Authorization": {"Bearer token"}})
+	defer closeStream()
+	broker.ChirpDeleted(db.Chirp{ID: 3, AuthorID: 3})
+	broker.ChirpDeleted(db.Chirp{ID: 2, AuthorID: 2})
+	if got, want := next(t, lines), "id: 4\nevent: chirp.deleted\ndata: {\"id\":2}"; got != want {
+		t.Errorf("Expected event %q, got %q", want, got)
+	}
+
+	// a client reconnecting receives the events it missed.
+	anonymous, closeAnonymous := open(t, server.URL+"/?author_id=3", http.Header{"Last-Event-Id": {"2"}})
+	defer closeAnonymous()
+	if got, want := next(t, anonymous), "id: 3\nevent: chirp.deleted\ndata: {\"id\":3}"; got != want {
+		t.Errorf("Expected the missed event %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,71 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// personalAccessTokenPrefix identifies personal access tokens in the Authorization header.
+const personalAccessTokenPrefix = "chirpy_pat_"
+
+// Scopes granted to personal access tokens.
+const (
+	ScopeChirpsRead   = "chirps:read"
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+)
+
+// Scopes lists every known scope.
+// Access tokens issued at login implicitly carry all of them.
+var Scopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeProfileRead, ScopeProfileWrite}
+
+// IsValidScope reports whether scope is a known scope.
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewPersonalAccessToken generates a new random personal access token.
+func NewPersonalAccessToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return personalAccessTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// IsPersonalAccessToken reports whether the raw token looks like a personal access token.
+func IsPersonalAccessToken(raw string) bool {
+	return strings.HasPrefix(raw, personalAccessTokenPrefix)
+}
+
+// HashPersonalAccessToken returns the hash stored for a personal access token.
+func HashPersonalAccessToken(raw string) string {
+	return HashToken(raw)
+}
+
+// HashToken returns the hash under which a token is stored.
+// Tokens have at least 128 bits of entropy, so a fast hash is enough.
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetBearer returns the raw bearer token from the Authorization header.
+func GetBearer(header string) (string, bool) {
+	split := strings.Split(header, " ")
+	if len(split) != 2 || split[0] != "Bearer" {
+		return "", false
+	}
+
+	return split[1], true
+}
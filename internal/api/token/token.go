@@ -1,6 +1,9 @@
 package token
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strconv"
@@ -13,15 +16,29 @@ import (
 const (
 	issuerRefresh = "chirpy-refresh"
 	issuerAccess  = "chirpy-access"
+
+	// RefreshTokenTTL is the lifetime of a refresh token.
+	RefreshTokenTTL = 60 * 24 * time.Hour
 )
 
+// RoleAdmin is the role of the users allowed to moderate, through the /admin routes.
+const RoleAdmin = "admin"
+
+// Claims are the claims carried by the tokens issued by the Manager.
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
 type Manager struct {
-	jwtSecret string
-	apiKey    string
+	jwtSecret     string
+	apiKey        string
+	webhookSecret string
+	now           func() time.Time
 }
 
 func NewManager(jwtSecret string, apiKey string) *Manager {
-	return &Manager{jwtSecret: jwtSecret, apiKey: apiKey}
+	return &Manager{jwtSecret: jwtSecret, apiKey: apiKey, now: time.Now}
 }
 
 func (t *Manager) CheckAPIKey(header http.Header) error {
@@ -35,7 +52,7 @@ func (t *Manager) CheckAPIKey(header http.Header) error {
 		return errors.New("invalid Authorization header")
 	}
 
-	if split[1] != t.apiKey {
+	if t.apiKey == "" || subtle.ConstantTimeCompare([]byte(split[1]), []byte(t.apiKey)) != 1 {
 		return errors.New("invalid api key")
 	}
 
@@ -64,6 +81,36 @@ func (t *Manager) GetUserID(token *jwt.Token) (int, error) {
 	return userID, nil
 }
 
+// GetRoles returns the roles carried by the token.
+func (t *Manager) GetRoles(token *jwt.Token) []string {
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return nil
+	}
+
+	return claims.Roles
+}
+
+// GetTokenID returns the unique identifier (jti) of the token.
+func (t *Manager) GetTokenID(token *jwt.Token) string {
+	claims, ok := token.Claims.(*Claims)
+	if !ok {
+		return ""
+	}
+
+	return claims.ID
+}
+
+// GetExpiresAt returns when the token expires, the zero time if it does not.
+func (t *Manager) GetExpiresAt(token *jwt.Token) time.Time {
+	expiresAt, err := token.Claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return time.Time{}
+	}
+
+	return expiresAt.Time
+}
+
 func (t *Manager) getToken(header http.Header, expectedIssuer string) (*jwt.Token, error) {
 	authHeader := header.Get("Authorization")
 	if authHeader == "" {
@@ -76,7 +123,7 @@ func (t *Manager) getToken(header http.Header, expectedIssuer string) (*jwt.Toke
 	}
 
 	tokenString := split[1]
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(t.jwtSecret), nil
 	})
 	if err != nil {
@@ -95,22 +142,31 @@ func (t *Manager) getToken(header http.Header, expectedIssuer string) (*jwt.Toke
 	return token, nil
 }
 
-func (t *Manager) CreateAccessToken(userID int) (string, error) {
-	return t.createToken(userID, issuerAccess, time.Hour)
+func (t *Manager) CreateAccessToken(userID int, roles ...string) (string, error) {
+	return t.createToken(userID, issuerAccess, time.Hour, roles)
 }
 
 func (t *Manager) CreateRefreshToken(userID int) (string, error) {
-	return t.createToken(userID, issuerRefresh, 60*24*time.Hour)
+	return t.createToken(userID, issuerRefresh, RefreshTokenTTL, nil)
 }
 
-func (t *Manager) createToken(userID int, issuer string, expiresAt time.Duration) (string, error) {
-	now := time.Now().UTC()
+func (t *Manager) createToken(userID int, issuer string, expiresAt time.Duration, roles []string) (string, error) {
+	now := t.now().UTC()
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
 
-	claims := jwt.RegisteredClaims{
-		Issuer:    issuer,
-		Subject:   strconv.Itoa(userID),
-		ExpiresAt: jwt.NewNumericDate(now.Add(expiresAt)),
-		IssuedAt:  jwt.NewNumericDate(now),
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			Issuer:    issuer,
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresAt)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Roles: roles,
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
@@ -0,0 +1,165 @@
+package token
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+func TestManager_AccessToken(t *testing.T) {
+	m := NewManager("mysecret", "")
+	accessToken, err := m.CreateAccessToken(42, RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := m.GetAccessToken(bearer(accessToken))
+	if err != nil {
+		t.Fatalf("GetAccessToken() error = %v", err)
+	}
+	if userID, err := m.GetUserID(token); err != nil || userID != 42 {
+		t.Errorf("GetUserID() = %d, %v, want 42", userID, err)
+	}
+	if roles := m.GetRoles(token); len(roles) != 1 || roles[0] != RoleAdmin {
+		t.Errorf("GetRoles() = %v, want the admin role", roles)
+	}
+	if m.GetTokenID(token) == "" {
+		t.Error("GetTokenID() is empty")
+	}
+
+	// an access token does not refresh, and a refresh token does not authenticate.
+	if _, err := m.GetRefreshToken(bearer(accessToken)); err == nil {
+		t.Error("Expected an access token to be rejected as a refresh token")
+	}
+	refreshToken, err := m.CreateRefreshToken(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetAccessToken(bearer(refreshToken)); err == nil {
+		t.Error("Expected a refresh token to be rejected as an access token")
+	}
+
+	for _, header := range []http.Header{{}, {"Authorization": {accessToken}}, bearer("garbage")} {
+		if _, err := m.GetAccessToken(header); err == nil {
+			t.Errorf("Expected %v to be rejected", header)
+		}
+	}
+	if _, err := NewManager("othersecret", "").GetAccessToken(bearer(accessToken)); err == nil {
+		t.Error("Expected a token signed with another secret to be rejected")
+	}
+}
+
+func TestManager_AccessToken_Expired(t *testing.T) {
+	m := NewManager("mysecret", "")
+	m.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	accessToken, err := m.CreateAccessToken(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.GetAccessToken(bearer(accessToken)); err == nil {
+		t.Error("Expected an expired token to be rejected")
+	}
+}
+
+func TestManager_CheckAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		apiKey  string
+		header  string
+		wantErr bool
+	}{
+		{name: "valid", apiKey: "polkakey", header: "ApiKey polkakey"},
+		{name: "missing", apiKey: "polkakey", wantErr: true},
+		{name: "bearer", apiKey: "polkakey", header: "Bearer polkakey", wantErr: true},
+		{name: "wrong key", apiKey: "polkakey", header: "ApiKey other", wantErr: true},
+		{name: "no key configured", header: "ApiKey ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.header != "" {
+				header.Set("Authorization", tt.header)
+			}
+			if err := NewManager("mysecret", tt.apiKey).CheckAPIKey(header); (err != nil) != tt.wantErr {
+				t.Errorf("CheckAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManager_CheckWebhookSignature(t *testing.T) {
+	m := NewManager("mysecret", "").WithWebhookSecret("webhooksecret")
+	body := []byte(`{"event":"user.upgraded"}`)
+	signed := func(at time.Time, body []byte) http.Header {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		return http.Header{
+			WebhookTimestampHeader: {timestamp},
+			WebhookSignatureHeader: {"sha256=" + hex.EncodeToString(m.SignWebhook(timestamp, body))},
+		}
+	}
+
+	if err := m.CheckWebhookSignature(signed(time.Now(), body), body); err != nil {
+		t.Errorf("CheckWebhookSignature() error = %v", err)
+	}
+	if !HasWebhookSignature(signed(time.Now(), body)) || HasWebhookSignature(http.Header{}) {
+		t.Error("HasWebhookSignature() does not detect the signature")
+	}
+
+	tampered := signed(time.Now(), body)
+	tests := map[string]http.Header{
+		"stale":           signed(time.Now().Add(-10*time.Minute), body),
+		"future":          signed(time.Now().Add(10*time.Minute), body),
+		"other body":      signed(time.Now(), []byte(`{}`)),
+		"no prefix":       {WebhookTimestampHeader: tampered[WebhookTimestampHeader], WebhookSignatureHeader: {"abcd"}},
+		"not hex":         {WebhookTimestampHeader: tampered[WebhookTimestampHeader], WebhookSignatureHeader: {"sha256=xyz"}},
+		"no timestamp":    {WebhookSignatureHeader: tampered[WebhookSignatureHeader]},
+		"other timestamp": {WebhookTimestampHeader: {"1"}, WebhookSignatureHeader: tampered[WebhookSignatureHeader]},
+	}
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := m.CheckWebhookSignature(header, body); err == nil {
+				t.Error("Expected the webhook to be rejected")
+			}
+		})
+	}
+
+	if err := NewManager("mysecret", "").CheckWebhookSignature(signed(time.Now(), body), body); err == nil {
+		t.Error("Expected the signature to be rejected without webhook secret")
+	}
+}
+
+func TestPersonalAccessToken(t *testing.T) {
+	raw, err := NewPersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewPersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsPersonalAccessToken(raw) || raw == other {
+		t.Errorf("NewPersonalAccessToken() = %q and %q, want distinct personal access tokens", raw, other)
+	}
+	if HashPersonalAccessToken(raw) == HashPersonalAccessToken(other) || HashPersonalAccessToken(raw) != HashToken(raw) {
+		t.Error("HashPersonalAccessToken() does not identify the tokens")
+	}
+	if got, ok := GetBearer("Bearer " + raw); !ok || got != raw {
+		t.Errorf("GetBearer() = %q, %v, want the token", got, ok)
+	}
+	for _, scope := range Scopes {
+		if !IsValidScope(scope) {
+			t.Errorf("IsValidScope(%q) = false", scope)
+		}
+	}
+	if IsValidScope("admin") {
+		t.Error("IsValidScope(admin) = true")
+	}
+}
@@ -0,0 +1,78 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader carries the hex encoded HMAC-SHA256 signature, prefixed with "sha256=".
+	WebhookSignatureHeader = "X-Polka-Signature"
+	// WebhookTimestampHeader carries the unix time at which the webhook was signed.
+	WebhookTimestampHeader = "X-Polka-Timestamp"
+
+	// webhookTolerance is the maximum age of a signed webhook.
+	webhookTolerance = 5 * time.Minute
+)
+
+// WithWebhookSecret sets the secret used to verify signed webhooks.
+func (t *Manager) WithWebhookSecret(secret string) *Manager {
+	t.webhookSecret = secret
+	return t
+}
+
+// HasWebhookSignature reports whether the request carries a webhook signature.
+func HasWebhookSignature(header http.Header) bool {
+	return header.Get(WebhookSignatureHeader) != ""
+}
+
+// CheckWebhookSignature verifies the HMAC-SHA256 signature of a webhook.
+// The signed payload is the timestamp header, a dot, and the raw body.
+func (t *Manager) CheckWebhookSignature(header http.Header, body []byte) error {
+	if t.webhookSecret == "" {
+		return errors.New("webhook signature not supported")
+	}
+
+	signature, ok := strings.CutPrefix(header.Get(WebhookSignatureHeader), "sha256=")
+	if !ok {
+		return errors.New("invalid webhook signature")
+	}
+
+	timestamp := header.Get(WebhookTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid webhook timestamp")
+	}
+
+	age := t.now().Sub(time.Unix(unix, 0))
+	if age > webhookTolerance || age < -webhookTolerance {
+		return errors.New("stale webhook timestamp")
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("invalid webhook signature")
+	}
+
+	if !hmac.Equal(got, t.SignWebhook(timestamp, body)) {
+		return errors.New("invalid webhook signature")
+	}
+
+	return nil
+}
+
+// SignWebhook returns the HMAC-SHA256 signature of a webhook payload.
+func (t *Manager) SignWebhook(timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(t.webhookSecret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,277 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/blob"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/media"
+)
+
+const (
+	maxAvatarSize = 2 << 20
+	maxMediaSize  = 5 << 20
+	// multipartOverhead is the room left for the multipart boundaries and headers.
+	multipartOverhead = 64 << 10
+
+	avatarSide          = 512
+	avatarThumbnailSide = 128
+	mediaSide           = 2048
+	mediaThumbnailSide  = 320
+
+	// media never change once uploaded, they can be cached forever.
+	cacheControl = "public, max-age=31536000, immutable"
+	// the chirp media not attached yet are only served to their owner, who must not get them from a shared cache.
+	privateCacheControl = "private, no-cache"
+)
+
+type MediaStorer interface {
+	CreateMedia(media db.Media) (db.Media, error)
+	GetMedia(id int) (*db.Media, error)
+	DeleteMedia(id int) error
+	SetUserAvatar(userID, mediaID int) (int, error)
+}
+
+type Handler struct {
+	db    MediaStorer
+	blobs blob.BlobStore
+}
+
+// NewHandler returns a new handler.
+func NewHandler(db MediaStorer, blobs blob.BlobStore) *Handler {
+	return &Handler{db: db, blobs: blobs}
+}
+
+type MediaResponse struct {
+	ID           int    `json:"id"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	ContentType  string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Size         int    `json:"size"`
+}
+
+func newMediaResponse(m db.Media) MediaResponse {
+	return MediaResponse{
+		ID:           m.ID,
+		URL:          db.MediaURL(m.ID),
+		ThumbnailURL: db.MediaThumbnailURL(m.ID),
+		ContentType:  m.ContentType,
+		Width:        m.Width,
+		Height:       m.Height,
+		Size:         m.Size,
+	}
+}
+
+// Avatar replaces the avatar of the authenticated user.
+func (h *Handler) Avatar(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	m, ok := h.upload(w, r, principal.UserID, db.MediaAvatar, maxAvatarSize, avatarSide, avatarThumbnailSide)
+	if !ok {
+		return
+	}
+
+	previous, err := h.db.SetUserAvatar(principal.UserID, m.ID)
+	if err != nil {
+		h.remove(m)
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if previous != 0 {
+		if old, err := h.db.GetMedia(previous); err == nil {
+			h.remove(*old)
+		}
+	}
+
+	api.RespondWithJSON(w, http.StatusCreated, newMediaResponse(m))
+}
+
+// Create uploads a media to attach to a chirp.
+func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	m, ok := h.upload(w, r, principal.UserID, db.MediaChirp, maxMediaSize, mediaSide, mediaThumbnailSide)
+	if !ok {
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusCreated, newMediaResponse(m))
+}
+
+// Get serves a media. The chirp media not attached yet are only served to their owner.
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, false)
+}
+
+// GetThumbnail serves the thumbnail of a media.
+func (h *Handler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	h.serve(w, r, true)
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m, err := h.db.GetMedia(id)
+	if err != nil {
+		// the only error we can get is not found
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	cache := cacheControl
+	if m.Kind == db.MediaChirp && m.ChirpID == 0 {
+		// media ids are sequential, the unattached media would be enumerated before their chirp is posted.
+		principal, ok := api.PrincipalFromContext(r.Context())
+		if !ok || principal.UserID != m.OwnerID {
+			api.RespondWithError(w, http.StatusNotFound, db.ErrNotFound.Error())
+			return
+		}
+		cache = privateCacheControl
+	}
+
+	key, etag := m.Key, fmt.Sprintf(`"%d"`, m.ID)
+	if thumbnail {
+		key, etag = m.ThumbnailKey, fmt.Sprintf(`"%d-thumbnail"`, m.ID)
+	}
+
+	content, modTime, err := h.blobs.Open(key)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", m.ContentType)
+	w.Header().Set("Cache-Control", cache)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", modTime, content)
+}
+
+// upload reads the "file" field of a multipart request, re-encodes the image and stores it.
+// It responds with an error and returns false on failure.
+func (h *Handler) upload(w http.ResponseWriter, r *http.Request, ownerID int, kind string, maxSize int64, side, thumbnailSide int) (db.Media, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+multipartOverhead)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			api.RespondWithError(w, http.StatusRequestEntityTooLarge, "file too large")
+			return db.Media{}, false
+		}
+
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return db.Media{}, false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return db.Media{}, false
+	}
+	if int64(len(data)) > maxSize {
+		api.RespondWithError(w, http.StatusRequestEntityTooLarge, "file too large")
+		return db.Media{}, false
+	}
+
+	full, thumbnail, err := media.Process(data, side, thumbnailSide)
+	if err != nil {
+		if errors.Is(err, media.ErrUnsupportedType) {
+			api.RespondWithError(w, http.StatusUnsupportedMediaType, err.Error())
+			return db.Media{}, false
+		}
+
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return db.Media{}, false
+	}
+
+	name, err := randomName()
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return db.Media{}, false
+	}
+
+	m := db.Media{
+		OwnerID:      ownerID,
+		Kind:         kind,
+		Key:          fmt.Sprintf("%ss/%d/%s%s", kind, ownerID, name, media.Extension(full.ContentType)),
+		ThumbnailKey: fmt.Sprintf("%ss/%d/%s-thumbnail%s", kind, ownerID, name, media.Extension(thumbnail.ContentType)),
+		ContentType:  full.ContentType,
+		Width:        full.Width,
+		Height:       full.Height,
+		Size:         len(full.Data),
+		Blurhash:     full.Blurhash,
+	}
+
+	if err := h.blobs.Put(m.Key, bytes.NewReader(full.Data)); err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return db.Media{}, false
+	}
+	if err := h.blobs.Put(m.ThumbnailKey, bytes.NewReader(thumbnail.Data)); err != nil {
+		h.blobs.Delete(m.Key)
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return db.Media{}, false
+	}
+
+	created, err := h.db.CreateMedia(m)
+	if err != nil {
+		h.blobs.Delete(m.Key)
+		h.blobs.Delete(m.ThumbnailKey)
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return db.Media{}, false
+	}
+
+	return created, true
+}
+
+// remove deletes a media and its blobs. Failures are only logged,
+// an orphan blob is harmless.
+func (h *Handler) remove(m db.Media) {
+	if err := h.db.DeleteMedia(m.ID); err != nil {
+		log.Printf("delete media %d: %v", m.ID, err)
+	}
+	for _, key := range []string{m.Key, m.ThumbnailKey} {
+		if err := h.blobs.Delete(key); err != nil {
+			log.Printf("delete blob %s: %v", key, err)
+		}
+	}
+}
+
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,154 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/apitest"
+	"github.com/jbdoumenjou/mygoserver/internal/blob"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *db.DB, *blob.FileStore) {
+	t.Helper()
+	store := apitest.NewDB(t, "someone@example.com")
+	blobs := blob.NewFileStore(t.TempDir())
+
+	return NewHandler(store, blobs), store, blobs
+}
+
+// newImage returns a PNG image of the given size.
+func newImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// post uploads the content as the file field of a multipart form, on behalf of the user 1.
+func post(handler http.HandlerFunc, field string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile(field, "upload.png")
+	_, _ = part.Write(content)
+	_ = form.Close()
+
+	req := apitest.NewRequest(http.MethodPost, "/api/media", &body, api.Principal{UserID: 1})
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return apitest.Record(handler, req)
+}
+
+// get serves the media id, on behalf of the user if not 0.
+func get(handler http.HandlerFunc, id string, header http.Header, userID int) *httptest.ResponseRecorder {
+	req := apitest.NewRequest(http.MethodGet, "/api/media/"+id, nil, api.Principal{UserID: userID}, "id", id)
+	req.Header = header
+	return apitest.Record(handler, req)
+}
+
+func created(t *testing.T, rw *httptest.ResponseRecorder) MediaResponse {
+	t.Helper()
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+	var resp MediaResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHandler_Create(t *testing.T) {
+	h, store, _ := newTestHandler(t)
+
+	m := created(t, post(h.Create, "file", newImage(t, 4000, 1000)))
+	if m.Width != mediaSide || m.Height != mediaSide/4 || m.ContentType != "image/png" {
+		t.Errorf("Create() = %+v, want an image fitted in %d pixels", m, mediaSide)
+	}
+
+	rw := get(h.Get, strconv.Itoa(m.ID), http.Header{}, 1)
+	if rw.Code != http.StatusOK || rw.Header().Get("ETag") == "" || rw.Header().Get("Cache-Control") != privateCacheControl {
+		t.Fatalf("Get() = %d %v, want the media privately cached by its owner", rw.Code, rw.Header())
+	}
+	if rw := get(h.Get, strconv.Itoa(m.ID), http.Header{"If-None-Match": {rw.Header().Get("ETag")}}, 1); rw.Code != http.StatusNotModified {
+		t.Errorf("Expected a cached media not to be sent again, got %d", rw.Code)
+	}
+	if rw := get(h.GetThumbnail, strconv.Itoa(m.ID), http.Header{}, 1); rw.Code != http.StatusOK {
+		t.Errorf("Expected the thumbnail, got %d", rw.Code)
+	}
+	// the unattached media are only served to their owner.
+	for _, userID := range []int{0, 2} {
+		if rw := get(h.Get, strconv.Itoa(m.ID), http.Header{}, userID); rw.Code != http.StatusNotFound {
+			t.Errorf("Expected the unattached media not to be found by user %d, got %d", userID, rw.Code)
+		}
+		if rw := get(h.GetThumbnail, strconv.Itoa(m.ID), http.Header{}, userID); rw.Code != http.StatusNotFound {
+			t.Errorf("Expected the unattached thumbnail not to be found by user %d, got %d", userID, rw.Code)
+		}
+	}
+	if _, err := store.CreateChirp(db.Chirp{Body: "look", AuthorID: 1, Attachments: []db.Attachment{{MediaID: m.ID}}}); err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	if rw := get(h.Get, strconv.Itoa(m.ID), http.Header{}, 0); rw.Code != http.StatusOK || rw.Header().Get("Cache-Control") != cacheControl {
+		t.Errorf("Get() = %d %v, want the attached media served to everyone", rw.Code, rw.Header())
+	}
+	if rw := get(h.Get, "42", http.Header{}, 1); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing media not to be found, got %d", rw.Code)
+	}
+	if rw := get(h.Get, "one", http.Header{}, 1); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid id to be rejected, got %d", rw.Code)
+	}
+
+	tests := []struct {
+		name    string
+		field   string
+		content []byte
+		want    int
+	}{
+		{name: "missing file", field: "image", content: newImage(t, 1, 1), want: http.StatusBadRequest},
+		{name: "not an image", field: "file", content: []byte("hello"), want: http.StatusUnsupportedMediaType},
+		{name: "too large", field: "file", content: make([]byte, maxMediaSize+1), want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rw := post(h.Create, tt.field, tt.content); rw.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rw.Code, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_Avatar(t *testing.T) {
+	h, store, blobs := newTestHandler(t)
+
+	first := created(t, post(h.Avatar, "file", newImage(t, 10, 10)))
+	previous, err := store.GetMedia(first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := created(t, post(h.Avatar, "file", newImage(t, 10, 10)))
+
+	// the replaced avatar is deleted with its blobs.
+	if _, err := store.GetMedia(first.ID); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("GetMedia() of the previous avatar error = %v, want %v", err, db.ErrNotFound)
+	}
+	if _, _, err := blobs.Open(previous.Key); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Open() of the previous avatar error = %v, want %v", err, blob.ErrNotFound)
+	}
+	if u, err := store.GetUser(1); err != nil || u.AvatarID != second.ID {
+		t.Errorf("GetUser() = %+v, %v, want the new avatar", u, err)
+	}
+
+	if rw := post(h.Avatar, "file", make([]byte, maxAvatarSize+1)); rw.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected a large avatar to be rejected, got %d", rw.Code)
+	}
+}
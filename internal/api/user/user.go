@@ -3,32 +3,48 @@ package user
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
 
 	"github.com/jbdoumenjou/mygoserver/internal/api"
 	"github.com/jbdoumenjou/mygoserver/internal/api/token"
 	"github.com/jbdoumenjou/mygoserver/internal/db"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/jbdoumenjou/mygoserver/internal/password"
 )
 
 type UserStorer interface {
 	CreateUser(username, password string) (db.User, error)
-	UpdateUser(id int, email, password string) (db.User, error)
+	PatchUser(id int, update db.UserUpdate) (db.User, error)
+	RehashPassword(id int, password string) error
 	GetUserByEmail(email string) (*db.User, error)
 	GetUser(id int) (*db.User, error)
+	GetUserByHandle(handle string) (*db.User, error)
 	RevokeToken(token string) string
 	IsTokenRevoked(token string) bool
-	UpgradeUser(id int) error
+	ApplySubscriptionEvent(userID int, event string, periodEnd time.Time) error
+	ClaimWebhookEvent(id string) (bool, error)
+	ReleaseWebhookEvent(id string) error
+	CreateSession(userID int, id string, expiresAt time.Time) (db.Session, error)
 }
 
 type Handler struct {
-	db           UserStorer
-	tokenManager *token.Manager
+	db             UserStorer
+	tokenManager   *token.Manager
+	passwords      *password.Manager
+	passwordPolicy password.Policy
 }
 
-func NewHandler(db UserStorer, tokenManager *token.Manager) *Handler {
-	return &Handler{db: db, tokenManager: tokenManager}
+func NewHandler(db UserStorer, tokenManager *token.Manager, passwords *password.Manager, passwordPolicy password.Policy) *Handler {
+	return &Handler{db: db, tokenManager: tokenManager, passwords: passwords, passwordPolicy: passwordPolicy}
 }
 
 type Parameters struct {
@@ -40,8 +56,19 @@ type UserResponse struct {
 	ID          int    `json:"id"`
 	Email       string `json:"email"`
 	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Handle      string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
 }
 
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+// handlePattern is the allowed format of a handle.
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,15}$`)
+
 func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 	decoder := json.NewDecoder(r.Body)
 	params := Parameters{}
@@ -52,66 +79,249 @@ func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bcryptPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+	var fieldErrors []api.FieldError
+	if params.Email == "" {
+		fieldErrors = append(fieldErrors, api.FieldError{Field: "email", Code: "required", Message: "email is required"})
+	}
+
+	passwordErrors, err := h.validatePassword(params.Password, nil)
 	if err != nil {
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	fieldErrors = append(fieldErrors, passwordErrors...)
+
+	if len(fieldErrors) > 0 {
+		api.RespondWithFieldErrors(w, http.StatusBadRequest, fieldErrors)
+		return
+	}
 
-	user, err := h.db.CreateUser(params.Email, string(bcryptPassword))
+	hashedPassword, err := h.passwords.Hash(params.Password)
 	if err != nil {
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	resp := UserResponse{
-		Email: user.Email,
-		ID:    user.ID,
+	user, err := h.db.CreateUser(params.Email, hashedPassword)
+	if err != nil {
+		if errors.Is(err, db.ErrAlreadyExists) {
+			api.RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	api.RespondWithJSON(w, http.StatusCreated, resp)
+	api.RespondWithJSON(w, http.StatusCreated, newUserResponse(user))
+}
+
+type UpdateParameters struct {
+	Password        string `json:"password"`
+	Email           string `json:"email"`
+	CurrentPassword string `json:"current_password"`
 }
 
+// Update replaces the email and the password of the authenticated user.
 func (h *Handler) Update(w http.ResponseWriter, r *http.Request) {
-	token, err := h.tokenManager.GetAccessToken(r.Header)
+	decoder := json.NewDecoder(r.Body)
+	params := UpdateParameters{}
+
+	err := decoder.Decode(&params)
 	if err != nil {
-		api.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	userID, err := h.tokenManager.GetUserID(token)
+	h.update(w, r, PatchParameters{
+		Email:           &params.Email,
+		Password:        &params.Password,
+		CurrentPassword: params.CurrentPassword,
+	})
+}
+
+// PatchParameters are the fields of a partial update. Missing fields are left unchanged.
+type PatchParameters struct {
+	Email       *string `json:"email"`
+	Password    *string `json:"password"`
+	Handle      *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	// CurrentPassword is required to change the email or the password.
+	CurrentPassword string `json:"current_password"`
+}
+
+// Patch partially updates the authenticated user.
+func (h *Handler) Patch(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	params := PatchParameters{}
+
+	err := decoder.Decode(&params)
 	if err != nil {
-		api.RespondWithError(w, http.StatusUnauthorized, err.Error())
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := Parameters{}
+	h.update(w, r, params)
+}
 
-	err = decoder.Decode(&params)
+func (h *Handler) update(w http.ResponseWriter, r *http.Request, params PatchParameters) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	user, err := h.db.GetUser(principal.UserID)
 	if err != nil {
-		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	emailChanged := params.Email != nil && *params.Email != user.Email
+	if emailChanged || params.Password != nil {
+		// sensitive changes require a re-authentication.
+		if params.CurrentPassword == "" {
+			api.RespondWithFieldErrors(w, http.StatusBadRequest, []api.FieldError{
+				{Field: "current_password", Code: "required", Message: "current password is required"},
+			})
+			return
+		}
+		if _, err := h.passwords.Verify(user.Password, params.CurrentPassword); err != nil {
+			api.RespondWithError(w, http.StatusForbidden, "invalid current password")
+			return
+		}
+	}
+
+	email := user.Email
+	if params.Email != nil {
+		email = *params.Email
+	}
+
+	var fieldErrors []api.FieldError
+	if email == "" {
+		fieldErrors = append(fieldErrors, api.FieldError{Field: "email", Code: "required", Message: "email is required"})
+	}
+
+	hashedPassword := user.Password
+	if params.Password != nil {
+		passwordErrors, err := h.validatePassword(*params.Password, append([]string{user.Password}, user.PasswordHistory...))
+		if err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		fieldErrors = append(fieldErrors, passwordErrors...)
+	}
+
+	handle, displayName, bio := user.Handle, user.DisplayName, user.Bio
+	if params.Handle != nil {
+		handle = *params.Handle
+		if handle != "" && !handlePattern.MatchString(handle) {
+			fieldErrors = append(fieldErrors, api.FieldError{Field: "handle", Code: "invalid", Message: "handle must be 3 to 15 letters, digits or underscores"})
+		}
+	}
+	if params.DisplayName != nil {
+		displayName = strings.TrimSpace(*params.DisplayName)
+		if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+			fieldErrors = append(fieldErrors, api.FieldError{Field: "display_name", Code: "too_long", Message: fmt.Sprintf("display name must be at most %d characters long", maxDisplayNameLength)})
+		}
+	}
+	if params.Bio != nil {
+		bio = strings.TrimSpace(*params.Bio)
+		if utf8.RuneCountInString(bio) > maxBioLength {
+			fieldErrors = append(fieldErrors, api.FieldError{Field: "bio", Code: "too_long", Message: fmt.Sprintf("bio must be at most %d characters long", maxBioLength)})
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		api.RespondWithFieldErrors(w, http.StatusBadRequest, fieldErrors)
 		return
 	}
 
-	bcryptPassword, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+	if params.Password != nil {
+		hashedPassword, err = h.passwords.Hash(*params.Password)
+		if err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// a single update, nothing is changed when the email or the handle is taken.
+	updatedUser, err := h.db.PatchUser(principal.UserID, db.UserUpdate{
+		Email:       email,
+		Password:    hashedPassword,
+		Handle:      handle,
+		DisplayName: displayName,
+		Bio:         bio,
+	})
 	if err != nil {
+		if errors.Is(err, db.ErrAlreadyExists) {
+			api.RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	updatedUser, err := h.db.UpdateUser(userID, params.Email, string(bcryptPassword))
+	api.RespondWithJSON(w, http.StatusOK, newUserResponse(updatedUser))
+}
+
+func newUserResponse(user db.User) UserResponse {
+	return UserResponse{
+		ID:          user.ID,
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed(),
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+	}
+}
+
+// Get returns the public profile of a user.
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	resp := UserResponse{
-		Email: updatedUser.Email,
-		ID:    updatedUser.ID,
+	user, err := h.db.GetUser(id)
+	if err != nil {
+		// the only error we can get is not found
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
 	}
 
-	api.RespondWithJSON(w, http.StatusOK, resp)
+	api.RespondWithJSON(w, http.StatusOK, user.PublicProfile())
+}
+
+// GetByHandle returns the public profile of a user from its handle.
+func (h *Handler) GetByHandle(w http.ResponseWriter, r *http.Request) {
+	user, err := h.db.GetUserByHandle(chi.URLParam(r, "handle"))
+	if err != nil {
+		// the only error we can get is not found
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, user.PublicProfile())
+}
+
+// validatePassword checks the password against the password policy.
+// passwordHistory holds the current and previous password hashes of an existing user.
+func (h *Handler) validatePassword(pwd string, passwordHistory []string) ([]api.FieldError, error) {
+	violations, err := h.passwordPolicy.Check(h.passwords, pwd, passwordHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	var fieldErrors []api.FieldError
+	for _, violation := range violations {
+		fieldErrors = append(fieldErrors, api.FieldError{Field: "password", Code: violation.Code, Message: violation.Message})
+	}
+
+	return fieldErrors, nil
 }
 
 type UserLoginParameters struct {
@@ -144,15 +354,25 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password))
+	needsRehash, err := h.passwords.Verify(user.Password, params.Password)
 	if err != nil {
 		api.RespondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 
+	if needsRehash {
+		// the stored hash is outdated, we replace it while we know the password.
+		// A failure only delays the upgrade to the next login.
+		if hashedPassword, err := h.passwords.Hash(params.Password); err != nil {
+			log.Printf("rehash password of user %d: %v", user.ID, err)
+		} else if err := h.db.RehashPassword(user.ID, hashedPassword); err != nil {
+			log.Printf("update password of user %d: %v", user.ID, err)
+		}
+	}
+
 	// ok we can create a token accessToken
 	// access token
-	accessToken, err := h.tokenManager.CreateAccessToken(user.ID)
+	accessToken, err := h.tokenManager.CreateAccessToken(user.ID, user.Roles...)
 	if err != nil {
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -165,12 +385,18 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, err = h.db.CreateSession(user.ID, token.HashToken(refreshToken), time.Now().UTC().Add(token.RefreshTokenTTL))
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	resp := UserLoginResponse{
 		ID:           user.ID,
 		Email:        user.Email,
 		Token:        accessToken,
 		RefreshToken: refreshToken,
-		ISChirpyRed:  user.IsChirpyRed,
+		ISChirpyRed:  user.IsChirpyRed(),
 	}
 
 	api.RespondWithJSON(w, http.StatusOK, resp)
@@ -204,9 +430,16 @@ func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := h.db.GetUser(userId)
+	if err != nil {
+		// the account has been deleted
+		api.RespondWithError(w, http.StatusUnauthorized, "token revoked")
+		return
+	}
+
 	// ok we can refresh a token accessToken
 	// access token for 1 hour
-	accessToken, err := h.tokenManager.CreateAccessToken(userId)
+	accessToken, err := h.tokenManager.CreateAccessToken(userId, user.Roles...)
 	if err != nil {
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -242,29 +475,91 @@ func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
 }
 
 type UpgradeParams struct {
+	ID    string `json:"id"`
 	Event string `json:"event"`
 	Data  struct {
-		UserID int `json:"user_id"`
+		UserID    int        `json:"user_id"`
+		PeriodEnd *time.Time `json:"period_end,omitempty"`
 	} `json:"data"`
 }
 
+// maxWebhookSize is the maximum size of a webhook body.
+const maxWebhookSize = 1 << 20
+
+// Upgrade handles the Polka webhooks driving the Chirpy Red subscriptions.
+// Polka authenticates either with a signature over the raw body or with the api key.
+// The events carrying an id are processed at most once, and the signed events must carry one.
+// The legacy events without id are applied each time: two legitimate events can share a body,
+// and the replays of unsigned events are left to the signature and its timestamp.
 func (h *Handler) Upgrade(w http.ResponseWriter, r *http.Request) {
-	if err := h.tokenManager.CheckAPIKey(r.Header); err != nil {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookSize))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	signed := token.HasWebhookSignature(r.Header)
+	if signed {
+		err = h.tokenManager.CheckWebhookSignature(r.Header, body)
+	} else {
+		err = h.tokenManager.CheckAPIKey(r.Header)
+	}
+	if err != nil {
 		api.RespondWithError(w, http.StatusUnauthorized, err.Error())
 		return
 	}
 
-	decoder := json.NewDecoder(r.Body)
 	var params UpgradeParams
-	decoder.Decode(&params)
+	if err := json.Unmarshal(body, &params); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	eventID := params.ID
+	if eventID == "" && signed {
+		api.RespondWithError(w, http.StatusBadRequest, "missing event id")
+		return
+	}
 
-	if params.Event != "user.upgraded" {
+	switch params.Event {
+	case db.EventUpgraded, db.EventRenewed, db.EventPaymentFailed, db.EventDowngraded:
+	default:
 		// nothing to do
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	if err := h.db.UpgradeUser(params.Data.UserID); err != nil {
+	if eventID != "" {
+		claimed, err := h.db.ClaimWebhookEvent(eventID)
+		if err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !claimed {
+			// already processed, this is a replay
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	var periodEnd time.Time
+	if params.Data.PeriodEnd != nil {
+		periodEnd = params.Data.PeriodEnd.UTC()
+	}
+
+	if err := h.db.ApplySubscriptionEvent(params.Data.UserID, params.Event, periodEnd); err != nil {
+		// the event has not been processed, let Polka retry it.
+		if eventID != "" {
+			if err := h.db.ReleaseWebhookEvent(eventID); err != nil {
+				log.Printf("release webhook event %s: %v", eventID, err)
+			}
+		}
+
+		if errors.Is(err, db.ErrInvalidTransition) {
+			// the event does not apply to the current subscription, retrying won't help.
+			api.RespondWithError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
 		if errors.Is(err, db.ErrNotFound) {
 			api.RespondWithError(w, http.StatusNotFound, err.Error())
 			return
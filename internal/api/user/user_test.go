@@ -0,0 +1,219 @@
+package user
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/apitest"
+	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/password"
+)
+
+func newTestHandler(t *testing.T) (*Handler, *db.DB, *token.Manager) {
+	t.Helper()
+	store := apitest.NewDB(t, "red@example.com")
+	tokenManager := token.NewManager("mysecret", "polkakey").WithWebhookSecret("webhooksecret")
+
+	return NewHandler(store, tokenManager, password.NewManager(password.NewBcrypt(4)), password.Policy{}), store, tokenManager
+}
+
+// serve calls the handler with the body and header, on behalf of the user if not 0.
+func serve(handler http.HandlerFunc, body string, header http.Header, userID int) *httptest.ResponseRecorder {
+	req := apitest.NewRequest(http.MethodPost, "/api/users", strings.NewReader(body), api.Principal{UserID: userID})
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return apitest.Record(handler, req)
+}
+
+func TestHandler_Create(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+
+	if rw := serve(h.Create, `{"email":"new@example.com","password":"secret"}`, nil, 0); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "taken email", body: `{"email":"new@example.com","password":"secret"}`, want: http.StatusConflict},
+		{name: "missing email", body: `{"password":"secret"}`, want: http.StatusBadRequest},
+		{name: "invalid body", body: `{`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rw := serve(h.Create, tt.body, nil, 0); rw.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rw.Code, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandler_Login(t *testing.T) {
+	h, _, _ := newTestHandler(t)
+	if rw := serve(h.Create, `{"email":"new@example.com","password":"secret"}`, nil, 0); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	if rw := serve(h.Login, `{"email":"new@example.com","password":"wrong"}`, nil, 0); rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a wrong password to be rejected, got %d", rw.Code)
+	}
+	if rw := serve(h.Login, `{"email":"nobody@example.com","password":"secret"}`, nil, 0); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown email to be rejected, got %d", rw.Code)
+	}
+	rw := serve(h.Login, `{"email":"new@example.com","password":"secret"}`, nil, 0)
+	var login UserLoginResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &login); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Login() = %d %v", rw.Code, err)
+	}
+	refresh := http.Header{"Authorization": {"Bearer " + login.RefreshToken}}
+
+	if rw := serve(h.Refresh, "", http.Header{"Authorization": {"Bearer " + login.Token}}, 0); rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an access token not to refresh, got %d", rw.Code)
+	}
+	if rw := serve(h.Refresh, "", refresh, 0); rw.Code != http.StatusOK {
+		t.Errorf("Expected the refresh token to refresh, got %d: %s", rw.Code, rw.Body.String())
+	}
+	// revoking twice is a no-op.
+	for i := 0; i < 2; i++ {
+		if rw := serve(h.Revoke, "", refresh, 0); rw.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d: %s", rw.Code, rw.Body.String())
+		}
+	}
+	if rw := serve(h.Refresh, "", refresh, 0); rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token not to refresh, got %d", rw.Code)
+	}
+}
+
+func TestHandler_Patch(t *testing.T) {
+	h, store, _ := newTestHandler(t)
+	if rw := serve(h.Create, `{"email":"new@example.com","password":"secret"}`, nil, 0); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rw.Code, rw.Body.String())
+	}
+	if rw := serve(h.Patch, `{"handle":"taken"}`, nil, 1); rw.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "email without current password", body: `{"email":"other@example.com"}`, want: http.StatusBadRequest},
+		{name: "wrong current password", body: `{"password":"changed","current_password":"wrong"}`, want: http.StatusForbidden},
+		{name: "invalid handle", body: `{"handle":"no spaces"}`, want: http.StatusBadRequest},
+		{name: "taken handle", body: `{"handle":"TAKEN"}`, want: http.StatusConflict},
+		{name: "long bio", body: `{"bio":"` + strings.Repeat("b", maxBioLength+1) + `"}`, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rw := serve(h.Patch, tt.body, nil, 2); rw.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rw.Code, rw.Body.String())
+			}
+		})
+	}
+
+	// a taken email leaves the rest of the changes out.
+	if rw := serve(h.Patch, `{"handle":"fresh","email":"red@example.com","current_password":"secret"}`, nil, 2); rw.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d: %s", rw.Code, rw.Body.String())
+	}
+	if got, err := store.GetUser(2); err != nil || got.Handle != "" {
+		t.Errorf("GetUser() = %+v, %v, want the handle unchanged", got, err)
+	}
+
+	// the same email needs no re-authentication.
+	rw := serve(h.Patch, `{"email":"new@example.com","display_name":"  New  "}`, nil, 2)
+	var user UserResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &user); err != nil || rw.Code != http.StatusOK || user.DisplayName != "New" {
+		t.Errorf("Patch() = %d %+v, want the trimmed display name", rw.Code, user)
+	}
+}
+
+func TestHandler_Upgrade(t *testing.T) {
+	h, store, tokenManager := newTestHandler(t)
+	legacy := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+		req.Header.Set("Authorization", "ApiKey polkakey")
+		rw := httptest.NewRecorder()
+		h.Upgrade(rw, req)
+		return rw.Code
+	}
+	signed := func(body string) int {
+		now := strconv.FormatInt(time.Now().Unix(), 10)
+		req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", strings.NewReader(body))
+		req.Header.Set(token.WebhookTimestampHeader, now)
+		req.Header.Set(token.WebhookSignatureHeader, "sha256="+hex.EncodeToString(tokenManager.SignWebhook(now, []byte(body))))
+		rw := httptest.NewRecorder()
+		h.Upgrade(rw, req)
+		return rw.Code
+	}
+	status := func() string {
+		u, err := store.GetUser(1)
+		if err != nil {
+			t.Fatalf("GetUser should not have an error %v", err)
+		}
+		if u.Subscription == nil {
+			return ""
+		}
+		return u.Subscription.Status
+	}
+
+	if code := signed(`{"event":"user.upgraded","data":{"user_id":1}}`); code != http.StatusBadRequest {
+		t.Errorf("Expected a signed event without id to be rejected, got %d", code)
+	}
+
+	// an invalid transition releases the event, which is processed again once it applies.
+	renewed := `{"id":"evt_1","event":"user.renewed","data":{"user_id":1}}`
+	if code := signed(renewed); code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected the renewal of a missing subscription to be rejected, got %d", code)
+	}
+	if claimed, err := store.ClaimWebhookEvent("evt_1"); err != nil || !claimed {
+		t.Errorf("Expected the rejected event to be released, got %v, %v", claimed, err)
+	}
+	if err := store.ReleaseWebhookEvent("evt_1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the legacy events without id are applied each time, even with the same body.
+	upgraded := `{"event":"user.upgraded","data":{"user_id":1}}`
+	if code := legacy(upgraded); code != http.StatusOK || status() != db.SubscriptionActive {
+		t.Fatalf("Expected the upgrade to be applied, got %d and %q", code, status())
+	}
+	if code := legacy(`{"event":"user.downgraded","data":{"user_id":1}}`); code != http.StatusOK || status() != db.SubscriptionCanceled {
+		t.Fatalf("Expected the downgrade to be applied, got %d and %q", code, status())
+	}
+	if code := legacy(upgraded); code != http.StatusOK || status() != db.SubscriptionActive {
+		t.Errorf("Expected the second upgrade to be applied, got %d and %q", code, status())
+	}
+
+	// the events with an id are applied once.
+	downgraded := `{"id":"evt_3","event":"user.downgraded","data":{"user_id":1}}`
+	for i := 0; i < 2; i++ {
+		if code := legacy(downgraded); code != http.StatusOK || status() != db.SubscriptionCanceled {
+			t.Fatalf("Expected the downgrade to be applied, got %d and %q", code, status())
+		}
+	}
+	if code := legacy(upgraded); code != http.StatusOK || status() != db.SubscriptionActive {
+		t.Fatalf("Expected the upgrade to be applied, got %d and %q", code, status())
+	}
+	if code := legacy(downgraded); code != http.StatusOK || status() != db.SubscriptionActive {
+		t.Errorf("Expected the replayed downgrade to be ignored, got %d and %q", code, status())
+	}
+
+	if code := legacy(`{"event":"user.upgraded","data":{"user_id":42}}`); code != http.StatusNotFound {
+		t.Errorf("Expected the event of an unknown user to be rejected, got %d", code)
+	}
+	if code := legacy(`{"event":"user.upgraded","data":{"user_id":1},"id":"evt_2"}`); code != http.StatusOK || status() != db.SubscriptionActive {
+		t.Errorf("Expected a new event to be applied, got %d and %q", code, status())
+	}
+}
@@ -0,0 +1,512 @@
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cors"
+	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/stream"
+)
+
+const (
+	// TopicGlobal receives all the chirp events.
+	TopicGlobal = "global"
+	// TopicTimeline receives the chirp events of the authenticated user and of the users they follow.
+	TopicTimeline = "timeline"
+	// TopicNotifications receives the new notifications of the authenticated user.
+	TopicNotifications = "notifications"
+	// topicUserPrefix starts the topics receiving the chirp events of a user, user:<id>.
+	topicUserPrefix = "user:"
+)
+
+const (
+	MessageSubscribe    = "subscribe"
+	MessageUnsubscribe  = "unsubscribe"
+	MessageSubscribed   = "subscribed"
+	MessageUnsubscribed = "unsubscribed"
+	MessageEvent        = "event"
+	MessageError        = "error"
+)
+
+// EventNotificationCreated is the event of a new notification.
+const EventNotificationCreated = "notification.created"
+
+const (
+	// DefaultPingInterval is how often the clients are pinged.
+	// A client that does not answer within two intervals is dropped.
+	DefaultPingInterval = 30 * time.Second
+	// sendQueueSize is the number of messages queued for a client before it is dropped as too slow.
+	sendQueueSize = 64
+	// maxMessageSize is the maximum size of the client messages, in bytes.
+	maxMessageSize = 4096
+	// notificationPollInterval is how often the new notifications are looked up,
+	// and the principal of the connection checked.
+	notificationPollInterval = 2 * time.Second
+	// notificationPollSize is the number of recent notifications looked up.
+	notificationPollSize = 20
+	// writeTimeout bounds the writes of the control messages.
+	writeTimeout = 10 * time.Second
+	// TicketTTL is how long a ticket can be used to open a websocket.
+	TicketTTL = 30 * time.Second
+)
+
+type Storer interface {
+	ListFollowedIDs(userID int) ([]int, error)
+	ListHiddenAuthorIDs(viewerID int) ([]int, error)
+	ListNotifications(userID, beforeID, limit int, unreadOnly bool) ([]db.Notification, bool, error)
+}
+
+type Handler struct {
+	db           Storer
+	broker       *stream.Broker
+	pingInterval time.Duration
+	origins      *cors.Policy
+	check        func(api.Principal) error
+	now          func() time.Time
+
+	ticketsMux sync.Mutex
+	tickets    map[string]ticket
+}
+
+// ticket opens a single websocket on behalf of a principal.
+type ticket struct {
+	principal api.Principal
+	expiresAt time.Time
+}
+
+// NewHandler returns a new handler.
+func NewHandler(db Storer, broker *stream.Broker) *Handler {
+	return &Handler{
+		db:           db,
+		broker:       broker,
+		pingInterval: DefaultPingInterval,
+		origins:      cors.NewPolicy(),
+		check:        func(api.Principal) error { return nil },
+		now:          time.Now,
+		tickets:      map[string]ticket{},
+	}
+}
+
+// WithPingInterval sets how often the clients are pinged.
+func (h *Handler) WithPingInterval(interval time.Duration) *Handler {
+	h.pingInterval = interval
+	return h
+}
+
+// WithOrigins sets the origins allowed to open websockets, besides the one of the server.
+func (h *Handler) WithOrigins(origins *cors.Policy) *Handler {
+	h.origins = origins
+	return h
+}
+
+// WithPrincipalCheck sets how the principals of the open connections are checked, see api.Authenticator.Check.
+// A connection whose principal is no longer valid is closed.
+func (h *Handler) WithPrincipalCheck(check func(api.Principal) error) *Handler {
+	h.check = check
+	return h
+}
+
+// ClientMessage subscribes to or unsubscribes from a topic.
+type ClientMessage struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic"`
+}
+
+// ServerMessage acknowledges a client message, reports an error, or carries an event of a topic.
+type ServerMessage struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic,omitempty"`
+	// Event is the type of the event: chirp.created, chirp.deleted or notification.created.
+	Event string `json:"event,omitempty"`
+	Data  any    `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// DeletedChirp is the data of a chirp.deleted event.
+type DeletedChirp struct {
+	ID int `json:"id"`
+}
+
+// TicketResponse is a ticket opening a websocket.
+type TicketResponse struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateTicket issues a short-lived ticket opening a single websocket on behalf of the caller,
+// as the browsers cannot set headers on the websocket handshakes
+// and the long-lived tokens must not end up in the URLs, nor in the logs.
+func (h *Handler) CreateTicket(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	id := hex.EncodeToString(b)
+	now := h.now()
+
+	h.ticketsMux.Lock()
+	for id, t := range h.tickets {
+		if !now.Before(t.expiresAt) {
+			delete(h.tickets, id)
+		}
+	}
+	h.tickets[id] = ticket{principal: principal, expiresAt: now.Add(TicketTTL)}
+	h.ticketsMux.Unlock()
+
+	api.RespondWithJSON(w, http.StatusCreated, TicketResponse{Ticket: id, ExpiresAt: now.Add(TicketTTL).UTC()})
+}
+
+// Authenticate authenticates the handshakes carrying a ticket query parameter with the ticket,
+// which cannot be used again, and the other ones with the required middleware.
+func (h *Handler) Authenticate(required func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		authenticated := required(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.URL.Query().Get("ticket")
+			if id == "" {
+				authenticated.ServeHTTP(w, r)
+				return
+			}
+
+			h.ticketsMux.Lock()
+			t, ok := h.tickets[id]
+			delete(h.tickets, id)
+			h.ticketsMux.Unlock()
+			if !ok || !h.now().Before(t.expiresAt) {
+				api.RespondWithError(w, http.StatusUnauthorized, "invalid ticket")
+				return
+			}
+			if err := h.check(t.principal); err != nil {
+				api.RespondWithError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(api.WithPrincipal(r.Context(), t.principal)))
+		})
+	}
+}
+
+// checkOrigin accepts the handshakes of the clients that are not browsers, which send no origin,
+// and the ones of the pages of the server or of an allowed origin.
+func (h *Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return h.origins.Allows(origin)
+}
+
+// Serve upgrades the request of an authenticated user to a websocket,
+// then sends the events of the topics the client subscribes to.
+// The connection is closed when the token of the user expires, or when the user is no longer valid.
+func (h *Handler) Serve(w http.ResponseWriter, r *http.Request) {
+	principal, ok := api.PrincipalFromContext(r.Context())
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	// the upgrader responds to the failed handshakes, and to the ones of the origins not allowed.
+	upgrader := websocket.Upgrader{CheckOrigin: h.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("websocket of %d: %v", principal.UserID, err)
+		return
+	}
+	conn.SetReadLimit(maxMessageSize)
+
+	c := &client{
+		h:         h,
+		conn:      conn,
+		userID:    principal.UserID,
+		principal: principal,
+		send:      make(chan ServerMessage, sendQueueSize),
+		done:      make(chan struct{}),
+		topics:    map[string]bool{},
+	}
+	c.followed = stream.NewAuthors(func() ([]int, error) { return h.db.ListFollowedIDs(c.userID) }, 0)
+	c.hidden = stream.NewAuthors(func() ([]int, error) { return h.db.ListHiddenAuthorIDs(c.userID) }, 0)
+	sub, _ := h.broker.Subscribe(0)
+	defer sub.Cancel()
+
+	go c.writeLoop()
+	go c.readLoop()
+	c.eventLoop(sub)
+}
+
+// client is a websocket connection and its subscriptions.
+type client struct {
+	h      *Handler
+	conn   *websocket.Conn
+	userID int
+	// principal authenticated the connection, and grants the scopes of the topics.
+	principal api.Principal
+	// send queues the messages written by writeLoop.
+	send      chan ServerMessage
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mux    sync.Mutex
+	topics map[string]bool
+	// followed and hidden are the users followed by and hidden from the user,
+	// read again every stream.DefaultAuthorsTTL.
+	followed, hidden *stream.Authors
+	// lastNotificationID is the last notification sent to the client.
+	lastNotificationID int
+}
+
+// close closes the connection once, which stops the loops of the client.
+func (c *client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeTimeout))
+		_ = c.conn.Close()
+	})
+}
+
+// enqueue queues a message for the client.
+// A client whose queue is full does not read fast enough: it is dropped, and can reconnect.
+func (c *client) enqueue(msg ServerMessage) {
+	select {
+	case c.send <- msg:
+	case <-c.done:
+	default:
+		c.close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+func (c *client) writeLoop() {
+	ping := time.NewTicker(c.h.pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			payload, err := json.Marshal(msg)
+			if err != nil {
+				log.Printf("websocket of %d: %v", c.userID, err)
+				continue
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				c.close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+func (c *client) readLoop() {
+	// a client that answers neither the pings nor sends anything is dead.
+	extendDeadline := func() {
+		_ = c.conn.SetReadDeadline(time.Now().Add(2 * c.h.pingInterval))
+	}
+	extendDeadline()
+	c.conn.SetPongHandler(func(string) error {
+		extendDeadline()
+		return nil
+	})
+
+	for {
+		_, payload, err := c.conn.ReadMessage()
+		if err != nil {
+			c.close(websocket.CloseNormalClosure, "")
+			return
+		}
+		extendDeadline()
+
+		var msg ClientMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			c.enqueue(ServerMessage{Type: MessageError, Error: "invalid message: " + err.Error()})
+			continue
+		}
+		if err := c.handle(msg); err != nil {
+			c.enqueue(ServerMessage{Type: MessageError, Topic: msg.Topic, Error: err.Error()})
+		}
+	}
+}
+
+// handle subscribes to or unsubscribes from a topic.
+func (c *client) handle(msg ClientMessage) error {
+	if err := validTopic(msg.Topic); err != nil {
+		return err
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	switch msg.Type {
+	case MessageSubscribe:
+		if msg.Topic == TopicNotifications && !c.principal.HasScope(token.ScopeProfileRead) {
+			return fmt.Errorf("missing scope %s", token.ScopeProfileRead)
+		}
+		if msg.Topic == TopicNotifications && !c.topics[msg.Topic] {
+			// only the notifications created from now on are sent.
+			notifications, _, err := c.h.db.ListNotifications(c.userID, 0, 1, false)
+			if err != nil {
+				return err
+			}
+			if len(notifications) > 0 {
+				c.lastNotificationID = notifications[0].ID
+			}
+		}
+		c.topics[msg.Topic] = true
+		c.enqueue(ServerMessage{Type: MessageSubscribed, Topic: msg.Topic})
+	case MessageUnsubscribe:
+		delete(c.topics, msg.Topic)
+		c.enqueue(ServerMessage{Type: MessageUnsubscribed, Topic: msg.Topic})
+	default:
+		return fmt.Errorf("unknown message type %q", msg.Type)
+	}
+
+	return nil
+}
+
+func validTopic(topic string) error {
+	switch topic {
+	case TopicGlobal, TopicTimeline, TopicNotifications:
+		return nil
+	}
+	if id, ok := strings.CutPrefix(topic, topicUserPrefix); ok {
+		if _, err := strconv.Atoi(id); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown topic %q", topic)
+}
+
+func (c *client) eventLoop(sub *stream.Subscription) {
+	poll := time.NewTicker(notificationPollInterval)
+	defer poll.Stop()
+	var expired <-chan time.Time
+	if !c.principal.ExpiresAt.IsZero() {
+		expiry := time.NewTimer(c.principal.ExpiresAt.Sub(c.h.now()))
+		defer expiry.Stop()
+		expired = expiry.C
+	}
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-expired:
+			c.close(websocket.ClosePolicyViolation, "token expired")
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				// the server shuts down, or the client lagged too far behind.
+				c.close(websocket.CloseGoingAway, "event stream ended")
+				return
+			}
+			c.sendChirpEvent(event)
+		case <-poll.C:
+			// the user may have been deleted or suspended, or their token revoked, since the handshake.
+			if err := c.h.check(c.principal); err != nil {
+				c.close(websocket.ClosePolicyViolation, err.Error())
+				return
+			}
+			c.sendNotifications()
+		}
+	}
+}
+
+// sendChirpEvent sends a chirp event to each subscribed topic it belongs to.
+func (c *client) sendChirpEvent(event stream.Event) {
+	var data any = event.Chirp
+	if event.Type == stream.EventChirpDeleted {
+		data = DeletedChirp{ID: event.Chirp.ID}
+	}
+
+	for _, topic := range c.chirpTopics(event.Chirp) {
+		c.enqueue(ServerMessage{Type: MessageEvent, Topic: topic, Event: event.Type, Data: data})
+	}
+}
+
+// chirpTopics returns the subscribed topics of a chirp,
+// none if the user blocked, muted or is blocked by its author.
+func (c *client) chirpTopics(chirp db.Chirp) []string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	hidden, err := c.hidden.Contains(chirp.AuthorID)
+	if err != nil {
+		log.Printf("websocket hidden authors of %d: %v", c.userID, err)
+		return nil
+	}
+	if hidden {
+		return nil
+	}
+
+	var topics []string
+	if c.topics[TopicGlobal] {
+		topics = append(topics, TopicGlobal)
+	}
+	if topic := topicUserPrefix + strconv.Itoa(chirp.AuthorID); c.topics[topic] {
+		topics = append(topics, topic)
+	}
+	if c.topics[TopicTimeline] {
+		inTimeline := chirp.AuthorID == c.userID
+		if !inTimeline {
+			if inTimeline, err = c.followed.Contains(chirp.AuthorID); err != nil {
+				log.Printf("websocket timeline of %d: %v", c.userID, err)
+			}
+		}
+		if inTimeline {
+			topics = append(topics, TopicTimeline)
+		}
+	}
+
+	return topics
+}
+
+// sendNotifications sends the notifications created since the last ones sent, the oldest first.
+func (c *client) sendNotifications() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if !c.topics[TopicNotifications] {
+		return
+	}
+	notifications, _, err := c.h.db.ListNotifications(c.userID, 0, notificationPollSize, false)
+	if err != nil {
+		log.Printf("websocket notifications of %d: %v", c.userID, err)
+		return
+	}
+	for i := len(notifications) - 1; i >= 0; i-- {
+		notification := notifications[i]
+		if notification.ID <= c.lastNotificationID {
+			continue
+		}
+		c.enqueue(ServerMessage{Type: MessageEvent, Topic: TopicNotifications, Event: EventNotificationCreated, Data: notification})
+		c.lastNotificationID = notification.ID
+	}
+}
@@ -0,0 +1,208 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/apitest"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cors"
+	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/stream"
+)
+
+// store is the follow graph of the user 1, who hides the user 3.
+type store struct{}
+
+func (store) ListFollowedIDs(int) ([]int, error) { return nil, nil }
+
+func (store) ListHiddenAuthorIDs(int) ([]int, error) { return []int{3}, nil }
+
+func (store) ListNotifications(int, int, int, bool) ([]db.Notification, bool, error) {
+	return nil, false, nil
+}
+
+// rejectAll stands for the authentication of the requests without ticket.
+func rejectAll(http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.RespondWithError(w, http.StatusUnauthorized, "unauthorized")
+	})
+}
+
+// newTicket issues a ticket on behalf of the principal.
+func newTicket(t *testing.T, h *Handler, principal api.Principal) string {
+	t.Helper()
+	rw := apitest.ServeAs(h.CreateTicket, http.MethodPost, "/api/ws/tickets", "", principal)
+
+	var ticket TicketResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &ticket); err != nil || rw.Code != http.StatusCreated {
+		t.Fatalf("CreateTicket() = %d %v", rw.Code, err)
+	}
+	return ticket.Ticket
+}
+
+func TestHandler_Authenticate(t *testing.T) {
+	h := NewHandler(store{}, stream.NewBroker(0))
+	var principal api.Principal
+	authenticated := h.Authenticate(rejectAll)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = api.PrincipalFromContext(r.Context())
+	}))
+	authenticate := func(ticket string) int {
+		rw := httptest.NewRecorder()
+		authenticated.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/ws?ticket="+ticket, nil))
+		return rw.Code
+	}
+
+	if rw := apitest.Serve(h.CreateTicket, http.MethodPost, "/api/ws/tickets", "", 0); rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an anonymous ticket request to be rejected, got %d", rw.Code)
+	}
+
+	// a ticket opens a single websocket.
+	ticket := newTicket(t, h, api.Principal{UserID: 1})
+	if code := authenticate(ticket); code != http.StatusOK || principal.UserID != 1 {
+		t.Errorf("Expected the ticket to authenticate the user 1, got %d and %+v", code, principal)
+	}
+	if code := authenticate(ticket); code != http.StatusUnauthorized {
+		t.Errorf("Expected a used ticket to be rejected, got %d", code)
+	}
+	if code := authenticate("garbage"); code != http.StatusUnauthorized {
+		t.Errorf("Expected an unknown ticket to be rejected, got %d", code)
+	}
+
+	expired := newTicket(t, h, api.Principal{UserID: 1})
+	h.now = func() time.Time { return time.Now().Add(TicketTTL) }
+	if code := authenticate(expired); code != http.StatusUnauthorized {
+		t.Errorf("Expected an expired ticket to be rejected, got %d", code)
+	}
+	if code := authenticate(""); code != http.StatusUnauthorized {
+		t.Errorf("Expected the requests without ticket to be authenticated otherwise, got %d", code)
+	}
+
+	// the principal may no longer be valid when the ticket is used.
+	h.now = time.Now
+	revoked := newTicket(t, h, api.Principal{UserID: 1})
+	h.WithPrincipalCheck(func(api.Principal) error { return errors.New("token revoked") })
+	if code := authenticate(revoked); code != http.StatusUnauthorized {
+		t.Errorf("Expected the ticket of a revoked token to be rejected, got %d", code)
+	}
+}
+
+func TestHandler_CheckOrigin(t *testing.T) {
+	h := NewHandler(store{}, stream.NewBroker(0)).WithOrigins(cors.NewPolicy("https://app.example.com"))
+	wildcard := NewHandler(store{}, stream.NewBroker(0))
+
+	tests := []struct {
+		name   string
+		h      *Handler
+		origin string
+		want   bool
+	}{
+		{name: "no origin", h: h, want: true},
+		{name: "same origin", h: h, origin: "http://chirpy.example.com", want: true},
+		{name: "allowed origin", h: h, origin: "https://app.example.com", want: true},
+		{name: "other origin", h: h, origin: "https://evil.example.com", want: false},
+		{name: "wildcard", h: wildcard, origin: "https://evil.example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://chirpy.example.com/api/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := tt.h.checkOrigin(req); got != tt.want {
+				t.Errorf("checkOrigin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// dial opens a websocket on behalf of the principal.
+func dial(t *testing.T, h *Handler, principal api.Principal) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(h.Authenticate(rejectAll)(http.HandlerFunc(h.Serve)))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?ticket="+newTicket(t, h, principal), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestHandler_Serve(t *testing.T) {
+	broker := stream.NewBroker(0)
+	h := NewHandler(store{}, broker)
+	conn := dial(t, h, api.Principal{UserID: 1})
+	exchange := func(msg, want string) {
+		t.Helper()
+		if msg != "" {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+		}
+		_, got, err := conn.ReadMessage()
+		if err != nil || string(got) != want {
+			t.Fatalf("Expected message %s, got %s %v", want, got, err)
+		}
+	}
+
+	exchange(`not json`, `{"type":"error","error":"invalid message: invalid character 'o' in literal null (expecting 'u')"}`)
+	exchange(`{"type":"subscribe","topic":"user:me"}`, `{"type":"error","topic":"user:me","error":"unknown topic \"user:me\""}`)
+	exchange(`{"type":"ping","topic":"global"}`, `{"type":"error","topic":"global","error":"unknown message type \"ping\""}`)
+	exchange(`{"type":"subscribe","topic":"notifications"}`, `{"type":"error","topic":"notifications","error":"missing scope profile:read"}`)
+	exchange(`{"type":"subscribe","topic":"global"}`, `{"type":"subscribed","topic":"global"}`)
+
+	// the hidden users are left out.
+	broker.ChirpCreated(db.Chirp{ID: 1, AuthorID: 3, Body: "hidden"})
+	broker.ChirpCreated(db.Chirp{ID: 2, AuthorID: 2, Body: "visible"})
+	exchange("", `{"type":"event","topic":"global","event":"chirp.created","data":{"id":2,"author_id":2,"body":"visible"}}`)
+
+	exchange(`{"type":"unsubscribe","topic":"global"}`, `{"type":"unsubscribed","topic":"global"}`)
+	exchange(`{"type":"subscribe","topic":"user:2"}`, `{"type":"subscribed","topic":"user:2"}`)
+	broker.ChirpCreated(db.Chirp{ID: 3, AuthorID: 4, Body: "unsubscribed"})
+	broker.ChirpDeleted(db.Chirp{ID: 2, AuthorID: 2})
+	exchange("", `{"type":"event","topic":"user:2","event":"chirp.deleted","data":{"id":2}}`)
+}
+
+func TestHandler_Serve_Principal(t *testing.T) {
+	closed := func(conn *websocket.Conn, want string) {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(2 * notificationPollInterval))
+		_, _, err := conn.ReadMessage()
+		if closeErr, ok := err.(*websocket.CloseError); !ok || closeErr.Code != websocket.ClosePolicyViolation || closeErr.Text != want {
+			t.Errorf("Expected the connection to be closed with %q, got %v", want, err)
+		}
+	}
+
+	var suspended atomic.Bool
+	h := NewHandler(store{}, stream.NewBroker(0)).WithPrincipalCheck(func(api.Principal) error {
+		if suspended.Load() {
+			return errors.New("account suspended")
+		}
+		return nil
+	})
+	conn := dial(t, h, api.Principal{UserID: 1, Scopes: []string{token.ScopeProfileRead}})
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","topic":"notifications"}`)); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if _, got, err := conn.ReadMessage(); err != nil || string(got) != `{"type":"subscribed","topic":"notifications"}` {
+		t.Fatalf("Expected the subscription to the notifications, got %s %v", got, err)
+	}
+
+	// the connection ends with its token.
+	expiring := dial(t, h, api.Principal{UserID: 1, ExpiresAt: time.Now().Add(100 * time.Millisecond)})
+	closed(expiring, "token expired")
+
+	// and when its principal is no longer valid.
+	suspended.Store(true)
+	closed(conn, "account suspended")
+}
@@ -0,0 +1,118 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// BlobStore stores immutable binary objects by key.
+// Keys are slash separated paths, such as "avatars/3/9f86d081.png".
+type BlobStore interface {
+	Put(key string, r io.Reader) error
+	// Open returns the content of the blob and its modification time.
+	Open(key string) (io.ReadSeekCloser, time.Time, error)
+	Delete(key string) error
+}
+
+// FileStore is a BlobStore backed by a local directory.
+type FileStore struct {
+	root string
+}
+
+// NewFileStore returns a store writing under root.
+// The directory is created on the first write.
+func NewFileStore(root string) *FileStore {
+	return &FileStore{root: root}
+}
+
+func (s *FileStore) Put(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("create blob dir: %w", err)
+	}
+
+	// write to a temporary file first, so that readers never see a partial blob.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write blob: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename blob: %w", err)
+	}
+
+	return nil
+}
+
+func (s *FileStore) Open(key string) (io.ReadSeekCloser, time.Time, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, time.Time{}, ErrNotFound
+		}
+		return nil, time.Time{}, fmt.Errorf("open blob: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, fmt.Errorf("stat blob: %w", err)
+	}
+
+	return f, info.ModTime(), nil
+}
+
+func (s *FileStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("delete blob: %w", err)
+	}
+
+	return nil
+}
+
+// path returns the file path of a key, refusing keys escaping the root.
+func (s *FileStore) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Policies applied to the chirps of a deleted account.
+const (
+	ChirpsDelete    = "delete"
+	ChirpsAnonymize = "anonymize"
+)
+
+// AnonymousAuthorID is the author of the anonymized chirps.
+const AnonymousAuthorID = 0
+
+// Session is a login session, identified by the hash of its refresh token.
+type Session struct {
+	ID        string    `json:"id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AccountDeletion is the audit record of a deleted account.
+// It holds no personal data.
+type AccountDeletion struct {
+	UserID      int       `json:"user_id"`
+	DeletedAt   time.Time `json:"deleted_at"`
+	ChirpPolicy string    `json:"chirp_policy"`
+	Chirps      int       `json:"chirps"`
+	Tokens      int       `json:"tokens"`
+	Sessions    int       `json:"sessions"`
+	Media       int       `json:"media"`
+}
+
+// GetAccountDeletion returns the audit record of a deleted account.
+func (db *DB) GetAccountDeletion(userID int) (*AccountDeletion, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	deletion, ok := db.data.AccountDeletions[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &deletion, nil
+}
+
+// ListAccountDeletions returns the audit records of the deleted accounts, the most recent first.
+func (db *DB) ListAccountDeletions() ([]AccountDeletion, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	deletions := make([]AccountDeletion, 0, len(db.data.AccountDeletions))
+	for _, deletion := range db.data.AccountDeletions {
+		deletions = append(deletions, deletion)
+	}
+	slices.SortFunc(deletions, func(i, j AccountDeletion) int {
+		if c := j.DeletedAt.Compare(i.DeletedAt); c != 0 {
+			return c
+		}
+		return j.UserID - i.UserID
+	})
+
+	return deletions, nil
+}
+
+// CreateSession records a login session and saves it to disk.
+func (db *DB) CreateSession(userID int, id string, expiresAt time.Time) (Session, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	session := Session{
+		ID:        id,
+		UserID:    userID,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+	db.data.Sessions[id] = session
+	if err := db.writeDB(db.data); err != nil {
+		return Session{}, fmt.Errorf("write db: %w", err)
+	}
+
+	return session, nil
+}
+
+// ListSessions returns the sessions of a user, oldest first.
+func (db *DB) ListSessions(userID int) ([]Session, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	sessions := []Session{}
+	for _, session := range db.data.Sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+
+	slices.SortFunc(sessions, func(i, j Session) int {
+		return i.CreatedAt.Compare(j.CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+// DeleteUser deletes a user, applies the chirp policy to their chirps,
+// revokes their personal access tokens and removes their sessions and media.
+// It returns the deleted chirps, including the plain rechirps of the user's chirps by other users.
+// The media blobs are left to the caller.
+// Deleting an already deleted user returns the original audit record and no chirp.
+func (db *DB) DeleteUser(id int, chirpPolicy string) (AccountDeletion, []Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if deletion, ok := db.data.AccountDeletions[id]; ok {
+		return deletion, nil, nil
+	}
+
+	if chirpPolicy != ChirpsDelete && chirpPolicy != ChirpsAnonymize {
+		return AccountDeletion{}, nil, fmt.Errorf("unknown chirp policy %q", chirpPolicy)
+	}
+
+	email := ""
+	for e, user := range db.data.Users {
+		if user.ID == id {
+			email = e
+			break
+		}
+	}
+	if email == "" {
+		return AccountDeletion{}, nil, ErrNotFound
+	}
+
+	now := time.Now().UTC()
+	deletion := AccountDeletion{UserID: id, DeletedAt: now, ChirpPolicy: chirpPolicy}
+
+	var deleted []Chirp
+	for chirpID, chirp := range db.data.Chirps {
+		if chirp.AuthorID != id {
+			continue
+		}
+		if chirpPolicy == ChirpsDelete {
+			deleted = append(deleted, db.deleteChirp(chirp)...)
+		} else {
+			chirp.AuthorID = AnonymousAuthorID
+			db.data.Chirps[chirpID] = chirp
+		}
+		deletion.Chirps++
+	}
+
+	for patID, pat := range db.data.PersonalAccessTokens {
+		if pat.UserID != id || pat.RevokedAt != nil {
+			continue
+		}
+		pat.RevokedAt = &now
+		db.data.PersonalAccessTokens[patID] = pat
+		deletion.Tokens++
+	}
+
+	for sessionID, session := range db.data.Sessions {
+		if session.UserID == id {
+			delete(db.data.Sessions, sessionID)
+			deletion.Sessions++
+		}
+	}
+
+	for likeID, like := range db.data.Likes {
+		if like.UserID == id {
+			delete(db.data.Likes, likeID)
+		}
+	}
+	for followID, follow := range db.data.Follows {
+		if follow.FollowerID == id || follow.FolloweeID == id {
+			delete(db.data.Follows, followID)
+		}
+	}
+	for blockID, block := range db.data.Blocks {
+		if block.BlockerID == id || block.BlockedID == id {
+			delete(db.data.Blocks, blockID)
+		}
+	}
+	for muteID, mute := range db.data.Mutes {
+		if mute.MuterID == id || mute.MutedID == id {
+			delete(db.data.Mutes, muteID)
+		}
+	}
+	db.deleteNotifications(func(n Notification) bool { return n.UserID == id || n.ActorID == id })
+
+	for mediaID, media := range db.data.Media {
+		if media.OwnerID != id {
+			continue
+		}
+		if media.ChirpID != 0 && chirpPolicy == ChirpsAnonymize {
+			// the anonymized chirps keep their attachments.
+			media.OwnerID = AnonymousAuthorID
+			db.data.Media[mediaID] = media
+			continue
+		}
+		delete(db.data.Media, mediaID)
+		deletion.Media++
+	}
+
+	delete(db.data.Users, email)
+	db.data.AccountDeletions[id] = deletion
+	if err := db.writeDB(db.data); err != nil {
+		return AccountDeletion{}, nil, fmt.Errorf("write db: %w", err)
+	}
+
+	return deletion, deleted, nil
+}
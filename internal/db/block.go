@@ -0,0 +1,253 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+var (
+	// ErrSelfBlock is returned when a user tries to block themselves.
+	ErrSelfBlock = errors.New("users cannot block themselves")
+	// ErrSelfMute is returned when a user tries to mute themselves.
+	ErrSelfMute = errors.New("users cannot mute themselves")
+	// ErrBlocked is returned when a user tries to follow a user they blocked, or who blocked them.
+	ErrBlocked = errors.New("blocked")
+)
+
+// Block is a user blocking another one. Neither of them sees the chirps of the other,
+// nor can follow, reply to, quote, rechirp, like or mention the other.
+type Block struct {
+	ID        int       `json:"id"`
+	BlockerID int       `json:"blocker_id"`
+	BlockedID int       `json:"blocked_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Mute is a user muting another one, whose chirps are left out of the feeds of the muter.
+// The muted user is not told.
+type Mute struct {
+	ID        int       `json:"id"`
+	MuterID   int       `json:"muter_id"`
+	MutedID   int       `json:"muted_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BlockUser records that a user blocks another one and saves it to disk.
+// The follows between them and the notifications the blocked user caused to the blocker are removed.
+// Blocking a user twice returns the existing block, created reports whether the block is new.
+func (db *DB) BlockUser(blockerID, blockedID int) (block Block, created bool, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if blockerID == blockedID {
+		return Block{}, false, ErrSelfBlock
+	}
+	if _, err := db.getUser(blockedID); err != nil {
+		return Block{}, false, err
+	}
+	if existing, ok := db.findBlock(blockerID, blockedID); ok {
+		return existing, false, nil
+	}
+
+	block = Block{ID: db.nextID(seqBlocks), BlockerID: blockerID, BlockedID: blockedID, CreatedAt: time.Now().UTC()}
+	db.data.Blocks[block.ID] = block
+	for id, follow := range db.data.Follows {
+		if follow.FollowerID == blockerID && follow.FolloweeID == blockedID ||
+			follow.FollowerID == blockedID && follow.FolloweeID == blockerID {
+			delete(db.data.Follows, id)
+		}
+	}
+	db.deleteNotifications(func(n Notification) bool { return n.UserID == blockerID && n.ActorID == blockedID })
+	if err := db.writeDB(db.data); err != nil {
+		return Block{}, false, fmt.Errorf("write db: %w", err)
+	}
+
+	return block, true, nil
+}
+
+// UnblockUser removes the block of a user on another one and saves it to disk.
+// deleted reports whether there was a block to remove.
+func (db *DB) UnblockUser(blockerID, blockedID int) (deleted bool, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, err := db.getUser(blockedID); err != nil {
+		return false, err
+	}
+	block, ok := db.findBlock(blockerID, blockedID)
+	if !ok {
+		return false, nil
+	}
+
+	delete(db.data.Blocks, block.ID)
+	if err := db.writeDB(db.data); err != nil {
+		return false, fmt.Errorf("write db: %w", err)
+	}
+
+	return true, nil
+}
+
+// MuteUser records that a user mutes another one and saves it to disk.
+// Muting a user twice returns the existing mute, created reports whether the mute is new.
+func (db *DB) MuteUser(muterID, mutedID int) (mute Mute, created bool, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if muterID == mutedID {
+		return Mute{}, false, ErrSelfMute
+	}
+	if _, err := db.getUser(mutedID); err != nil {
+		return Mute{}, false, err
+	}
+	if existing, ok := db.findMute(muterID, mutedID); ok {
+		return existing, false, nil
+	}
+
+	mute = Mute{ID: db.nextID(seqMutes), MuterID: muterID, MutedID: mutedID, CreatedAt: time.Now().UTC()}
+	db.data.Mutes[mute.ID] = mute
+	if err := db.writeDB(db.data); err != nil {
+		return Mute{}, false, fmt.Errorf("write db: %w", err)
+	}
+
+	return mute, true, nil
+}
+
+// UnmuteUser removes the mute of a user on another one and saves it to disk.
+// deleted reports whether there was a mute to remove.
+func (db *DB) UnmuteUser(muterID, mutedID int) (deleted bool, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, err := db.getUser(mutedID); err != nil {
+		return false, err
+	}
+	mute, ok := db.findMute(muterID, mutedID)
+	if !ok {
+		return false, nil
+	}
+
+	delete(db.data.Mutes, mute.ID)
+	if err := db.writeDB(db.data); err != nil {
+		return false, fmt.Errorf("write db: %w", err)
+	}
+
+	return true, nil
+}
+
+// ListBlocks returns a page of the blocks of a user, the most recent first,
+// and whether more blocks follow. beforeID is the exclusive upper bound of the block ids, 0 if not set.
+func (db *DB) ListBlocks(userID, beforeID, limit int) ([]Block, bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var blocks []Block
+	for _, block := range db.data.Blocks {
+		if block.BlockerID == userID && (beforeID == 0 || block.ID < beforeID) {
+			blocks = append(blocks, block)
+		}
+	}
+	slices.SortFunc(blocks, func(i, j Block) int { return j.ID - i.ID })
+
+	if len(blocks) > limit {
+		return blocks[:limit], true, nil
+	}
+	return blocks, false, nil
+}
+
+// ListMutes returns a page of the mutes of a user, the most recent first,
+// and whether more mutes follow. beforeID is the exclusive upper bound of the mute ids, 0 if not set.
+func (db *DB) ListMutes(userID, beforeID, limit int) ([]Mute, bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var mutes []Mute
+	for _, mute := range db.data.Mutes {
+		if mute.MuterID == userID && (beforeID == 0 || mute.ID < beforeID) {
+			mutes = append(mutes, mute)
+		}
+	}
+	slices.SortFunc(mutes, func(i, j Mute) int { return j.ID - i.ID })
+
+	if len(mutes) > limit {
+		return mutes[:limit], true, nil
+	}
+	return mutes, false, nil
+}
+
+// IsBlocked reports whether one of the users blocks the other.
+func (db *DB) IsBlocked(userID, otherID int) (bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.blocked(userID, otherID), nil
+}
+
+// ListHiddenAuthorIDs returns the ids of the users whose chirps are left out of the feeds of a viewer:
+// the users blocked by or blocking the viewer, and the users muted by the viewer.
+func (db *DB) ListHiddenAuthorIDs(viewerID int) ([]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var ids []int
+	for id := range db.hiddenAuthors(viewerID) {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// hiddenAuthors returns the set of the users whose chirps are left out of the feeds of a viewer,
+// nil for an anonymous viewer. The caller must hold the lock.
+func (db *DB) hiddenAuthors(viewerID int) map[int]bool {
+	if viewerID == 0 {
+		return nil
+	}
+
+	hidden := map[int]bool{}
+	for _, block := range db.data.Blocks {
+		if block.BlockerID == viewerID {
+			hidden[block.BlockedID] = true
+		} else if block.BlockedID == viewerID {
+			hidden[block.BlockerID] = true
+		}
+	}
+	for _, mute := range db.data.Mutes {
+		if mute.MuterID == viewerID {
+			hidden[mute.MutedID] = true
+		}
+	}
+
+	return hidden
+}
+
+// blocked reports whether one of the users blocks the other. The caller must hold the lock.
+func (db *DB) blocked(userID, otherID int) bool {
+	_, ok := db.findBlock(userID, otherID)
+	if !ok {
+		_, ok = db.findBlock(otherID, userID)
+	}
+	return ok
+}
+
+// findBlock returns the block of a user on another one. The caller must hold the lock.
+func (db *DB) findBlock(blockerID, blockedID int) (Block, bool) {
+	for _, block := range db.data.Blocks {
+		if block.BlockerID == blockerID && block.BlockedID == blockedID {
+			return block, true
+		}
+	}
+	return Block{}, false
+}
+
+// findMute returns the mute of a user on another one. The caller must hold the lock.
+func (db *DB) findMute(muterID, mutedID int) (Mute, bool) {
+	for _, mute := range db.data.Mutes {
+		if mute.MuterID == muterID && mute.MutedID == mutedID {
+			return mute, true
+		}
+	}
+	return Mute{}, false
+}
@@ -6,16 +6,50 @@ import (
 	"fmt"
 	"os"
 	"slices"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/jbdoumenjou/mygoserver/internal/entity"
 )
 
 type DBStructure struct {
-	Chirps       map[int]Chirp        `json:"chirps"`
-	Users        map[string]User      `json:"users"`
-	RevokedToken map[string]time.Time `json:"revokedToken"`
+	Chirps               map[int]Chirp               `json:"chirps"`
+	Users                map[string]User             `json:"users"`
+	RevokedToken         map[string]time.Time        `json:"revokedToken"`
+	PersonalAccessTokens map[int]PersonalAccessToken `json:"personalAccessTokens"`
+	WebhookEvents        map[string]time.Time        `json:"webhookEvents"`
+	Sessions             map[string]Session          `json:"sessions"`
+	AccountDeletions     map[int]AccountDeletion     `json:"accountDeletions"`
+	Media                map[int]Media               `json:"media"`
+	ChirpRevisions       map[int][]ChirpRevision     `json:"chirpRevisions"`
+	ChirpTombstones      map[int]ChirpTombstone      `json:"chirpTombstones"`
+	Likes                map[int]Like                `json:"likes"`
+	Follows              map[int]Follow              `json:"follows"`
+	Notifications        map[int]Notification        `json:"notifications"`
+	Reports              map[int]Report              `json:"reports"`
+	Decisions            map[int]Decision            `json:"decisions"`
+	Blocks               map[int]Block               `json:"blocks"`
+	Mutes                map[int]Mute                `json:"mutes"`
+	// Sequences hold the last id allocated per collection, so that ids are never reused.
+	Sequences map[string]int `json:"sequences"`
 }
 
+// Sequence names.
+const (
+	seqChirps               = "chirps"
+	seqUsers                = "users"
+	seqPersonalAccessTokens = "personalAccessTokens"
+	seqMedia                = "media"
+	seqLikes                = "likes"
+	seqFollows              = "follows"
+	seqNotifications        = "notifications"
+	seqReports              = "reports"
+	seqDecisions            = "decisions"
+	seqBlocks               = "blocks"
+	seqMutes                = "mutes"
+)
+
 // DB is a simple file database.
 type DB struct {
 	path string
@@ -23,7 +57,17 @@ type DB struct {
 	mux  *sync.RWMutex
 }
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+
+	ErrInvalidAttachment = errors.New("invalid attachment")
+	ErrInvalidReply      = errors.New("invalid reply")
+	ErrInvalidQuote      = errors.New("invalid quote")
+)
+
+// maxPasswordHistory is the number of previous password hashes kept per user.
+const maxPasswordHistory = 24
 
 // NewDB creates a new database connection
 // and creates the database file if it doesn't exist
@@ -34,9 +78,24 @@ func NewDB(path string) (*DB, error) {
 			return nil, fmt.Errorf("stat %s: %w", path, err)
 		}
 		structure := DBStructure{
-			Chirps:       map[int]Chirp{},
-			Users:        map[string]User{},
-			RevokedToken: map[string]time.Time{},
+			Chirps:               map[int]Chirp{},
+			Users:                map[string]User{},
+			RevokedToken:         map[string]time.Time{},
+			PersonalAccessTokens: map[int]PersonalAccessToken{},
+			WebhookEvents:        map[string]time.Time{},
+			Sessions:             map[string]Session{},
+			AccountDeletions:     map[int]AccountDeletion{},
+			Media:                map[int]Media{},
+			ChirpRevisions:       map[int][]ChirpRevision{},
+			ChirpTombstones:      map[int]ChirpTombstone{},
+			Likes:                map[int]Like{},
+			Follows:              map[int]Follow{},
+			Notifications:        map[int]Notification{},
+			Reports:              map[int]Report{},
+			Decisions:            map[int]Decision{},
+			Blocks:               map[int]Block{},
+			Mutes:                map[int]Mute{},
+			Sequences:            map[string]int{},
 		}
 		if err := db.writeDB(structure); err != nil {
 			return nil, fmt.Errorf("write db: %w", err)
@@ -52,19 +111,110 @@ func NewDB(path string) (*DB, error) {
 
 // Chirp is a single chirp.
 type Chirp struct {
-	ID       int    `json:"id"`
-	AuthorID int    `json:"author_id"`
-	Body     string `json:"body"`
+	ID          int          `json:"id"`
+	AuthorID    int          `json:"author_id"`
+	Body        string       `json:"body"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// ReplyToID is the chirp this chirp replies to, 0 if none.
+	ReplyToID int `json:"reply_to_id,omitempty"`
+	// ConversationID is the first chirp of the conversation of a reply, 0 if not a reply.
+	ConversationID int `json:"conversation_id,omitempty"`
+	// RechirpOfID is the chirp shared by a plain rechirp, which has no body, 0 if not a rechirp.
+	RechirpOfID int `json:"rechirp_of_id,omitempty"`
+	// QuoteOfID is the chirp quoted by this chirp, 0 if none. The quoted chirp may have been deleted.
+	QuoteOfID int `json:"quote_of_id,omitempty"`
+	// CreatedAt is nil for the chirps created before it was recorded.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	// EditedAt is the time of the last edit, nil if the chirp was never edited.
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// Entities are the hashtags and mentions of the body.
+	Entities []entity.Entity `json:"entities,omitempty"`
+	// HiddenAt is set when a moderator hides the chirp, which is then only visible to its author and the admins.
+	HiddenAt *time.Time `json:"hidden_at,omitempty"`
+}
+
+// Attachment is a media attached to a chirp.
+type Attachment struct {
+	MediaID     int    `json:"media_id"`
+	AltText     string `json:"alt_text,omitempty"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Blurhash    string `json:"blurhash,omitempty"`
 }
 
-// CreateChirp creates a new chirp and saves it to disk
-func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
+// CreateChirp creates a new chirp and saves it to disk.
+// Only the body, the author, the attachments, ReplyToID and QuoteOfID of the chirp are read.
+// Only the media id and the alt text of the attachments are read, the media must be chirp media
+// uploaded by the author and not attached yet.
+// The replied and quoted chirps must exist and not be hidden, the original of a rechirp is used instead of the rechirp.
+func (db *DB) CreateChirp(params Chirp) (Chirp, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	id := len(db.data.Chirps) + 1
-	chirp := Chirp{ID: id, Body: body, AuthorID: authorID}
+	authorID := params.AuthorID
+	if author, err := db.getUser(authorID); err == nil && author.IsSuspended() {
+		return Chirp{}, ErrSuspended
+	}
+	replyToID, conversationID := params.ReplyToID, 0
+	if replyToID != 0 {
+		parent, ok := db.original(replyToID)
+		// a blocked party cannot tell the chirp exists.
+		if !ok || db.blocked(authorID, parent.AuthorID) {
+			return Chirp{}, fmt.Errorf("%w: chirp %d not found", ErrInvalidReply, replyToID)
+		}
+		replyToID, conversationID = parent.ID, parent.conversationID()
+	}
+
+	quoteOfID := params.QuoteOfID
+	if quoteOfID != 0 {
+		quoted, ok := db.original(quoteOfID)
+		if !ok || db.blocked(authorID, quoted.AuthorID) {
+			return Chirp{}, fmt.Errorf("%w: chirp %d not found", ErrInvalidQuote, quoteOfID)
+		}
+		quoteOfID = quoted.ID
+	}
+
+	var chirpAttachments []Attachment
+	for _, attachment := range params.Attachments {
+		media, ok := db.data.Media[attachment.MediaID]
+		if !ok || media.OwnerID != authorID || media.Kind != MediaChirp || media.ChirpID != 0 {
+			return Chirp{}, fmt.Errorf("%w: media %d", ErrInvalidAttachment, attachment.MediaID)
+		}
+		if slices.ContainsFunc(chirpAttachments, func(a Attachment) bool { return a.MediaID == media.ID }) {
+			return Chirp{}, fmt.Errorf("%w: media %d attached twice", ErrInvalidAttachment, media.ID)
+		}
+
+		chirpAttachments = append(chirpAttachments, Attachment{
+			MediaID:     media.ID,
+			AltText:     attachment.AltText,
+			ContentType: media.ContentType,
+			Width:       media.Width,
+			Height:      media.Height,
+			Blurhash:    media.Blurhash,
+		})
+	}
+
+	id := db.nextID(seqChirps)
+	now := time.Now().UTC()
+	chirp := Chirp{
+		ID:             id,
+		Body:           params.Body,
+		AuthorID:       authorID,
+		Attachments:    chirpAttachments,
+		ReplyToID:      replyToID,
+		ConversationID: conversationID,
+		QuoteOfID:      quoteOfID,
+		CreatedAt:      &now,
+		Entities:       db.parseEntities(authorID, params.Body),
+	}
+	for _, attachment := range chirpAttachments {
+		media := db.data.Media[attachment.MediaID]
+		media.ChirpID = id
+		db.data.Media[media.ID] = media
+	}
 	db.data.Chirps[id] = chirp
+	db.notifyChirp(chirp)
 	if err := db.writeDB(db.data); err != nil {
 		return Chirp{}, fmt.Errorf("write db: %w", err)
 	}
@@ -72,14 +222,18 @@ func (db *DB) CreateChirp(body string, authorID int) (Chirp, error) {
 	return chirp, nil
 }
 
-// ListChirps returns all chirps in the database
-func (db *DB) ListChirps(authorId int, sort string) ([]Chirp, error) {
+// ListChirps returns all chirps in the database, but the hidden ones.
+// viewerID leaves out the chirps of the users the viewer blocked, muted or is blocked by, 0 for anonymous viewers.
+func (db *DB) ListChirps(authorId, viewerID int, sort string) ([]Chirp, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 
+	hidden := db.hiddenAuthors(viewerID)
 	var chirps []Chirp
 	for _, chirp := range db.data.Chirps {
-
+		if chirp.IsHidden() || hidden[chirp.AuthorID] {
+			continue
+		}
 		if authorId == -1 || chirp.AuthorID == authorId {
 			chirps = append(chirps, chirp)
 		}
@@ -96,6 +250,81 @@ func (db *DB) ListChirps(authorId int, sort string) ([]Chirp, error) {
 	return chirps, nil
 }
 
+// ChirpPage selects a page of chirps.
+type ChirpPage struct {
+	// AuthorID filters the chirps of an author, -1 for all the authors.
+	AuthorID int
+	// AuthorIDs filters the chirps of several authors when not nil, AuthorID must then be -1.
+	AuthorIDs []int
+	// Tag filters the chirps using a hashtag, normalized with entity.NormalizeTag, when not empty.
+	Tag string
+	// MentionedID filters the chirps mentioning a user when not 0.
+	MentionedID int
+	// ViewerID leaves out the chirps of the users the viewer blocked, muted or is blocked by, 0 for anonymous viewers.
+	ViewerID int
+	Sort     string
+	// AfterID and BeforeID are exclusive bounds in the sort order, 0 if not set.
+	// The bound chirps do not need to exist anymore.
+	AfterID  int
+	BeforeID int
+	Limit    int
+}
+
+// ListChirpsPage returns a page of chirps and whether more chirps follow it
+// in the direction of the pagination: after AfterID, or before BeforeID.
+// Chirp ids are never reused, so a page does not shift when other chirps are created or deleted.
+func (db *DB) ListChirpsPage(page ChirpPage) ([]Chirp, bool, error) {
+	chirps, err := db.ListChirps(page.AuthorID, page.ViewerID, page.Sort)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// follows reports whether the chirp id comes after the bound id in the sort order.
+	follows := func(id, bound int) bool {
+		if page.Sort == "asc" {
+			return id > bound
+		}
+		return id < bound
+	}
+
+	var authors map[int]bool
+	if page.AuthorIDs != nil {
+		authors = make(map[int]bool, len(page.AuthorIDs))
+		for _, id := range page.AuthorIDs {
+			authors[id] = true
+		}
+	}
+
+	selected := chirps[:0]
+	for _, chirp := range chirps {
+		if authors != nil && !authors[chirp.AuthorID] {
+			continue
+		}
+		if page.Tag != "" && !chirp.hasTag(page.Tag) {
+			continue
+		}
+		if page.MentionedID != 0 && !chirp.mentions(page.MentionedID) {
+			continue
+		}
+		if page.AfterID != 0 && !follows(chirp.ID, page.AfterID) {
+			continue
+		}
+		if page.BeforeID != 0 && !follows(page.BeforeID, chirp.ID) {
+			continue
+		}
+		selected = append(selected, chirp)
+	}
+
+	if len(selected) <= page.Limit {
+		return selected, false, nil
+	}
+	// a page before a bound ends next to it.
+	if page.BeforeID != 0 && page.AfterID == 0 {
+		return selected[len(selected)-page.Limit:], true, nil
+	}
+	return selected[:page.Limit], true, nil
+}
+
 // GetChirp returns a single chirp.
 func (db *DB) GetChirp(id int) (*Chirp, error) {
 	db.mux.RLock()
@@ -109,22 +338,110 @@ func (db *DB) GetChirp(id int) (*Chirp, error) {
 	return &chirp, nil
 }
 
-// DeleteChirp returns a single chirp.
-func (db *DB) DeleteChirp(id int) {
-	db.mux.RLock()
-	defer db.mux.RUnlock()
+// DeleteChirp deletes a single chirp and saves it to disk.
+// It returns the deleted chirps, the chirp first, then its plain rechirps deleted with it.
+// The media attached to the chirp are deleted too, and returned
+// so that the caller can release their content.
+func (db *DB) DeleteChirp(id int) ([]Chirp, []Media, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	chirp, ok := db.data.Chirps[id]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
 
-	if _, ok := db.data.Chirps[id]; ok {
-		delete(db.data.Chirps, id)
+	var released []Media
+	for _, attachment := range chirp.Attachments {
+		if media, ok := db.data.Media[attachment.MediaID]; ok && media.ChirpID == id {
+			delete(db.data.Media, media.ID)
+			released = append(released, media)
+		}
 	}
+
+	deleted := db.deleteChirp(chirp)
+	if err := db.writeDB(db.data); err != nil {
+		return nil, nil, fmt.Errorf("write db: %w", err)
+	}
+
+	return deleted, released, nil
+}
+
+// deleteChirp deletes a chirp, its revisions, its likes and its plain rechirps.
+// A chirp of a conversation or a quoted chirp leaves a tombstone,
+// so that the conversation keeps its shape and the quotes their reference.
+// It returns the deleted chirps, the chirp first.
+// The caller must hold the lock.
+func (db *DB) deleteChirp(chirp Chirp) []Chirp {
+	deleted := []Chirp{chirp}
+	delete(db.data.Chirps, chirp.ID)
+	delete(db.data.ChirpRevisions, chirp.ID)
+	for id, like := range db.data.Likes {
+		if like.ChirpID == chirp.ID {
+			delete(db.data.Likes, id)
+		}
+	}
+	for _, rechirp := range db.data.Chirps {
+		if rechirp.RechirpOfID == chirp.ID {
+			deleted = append(deleted, db.deleteChirp(rechirp)...)
+		}
+	}
+	db.deleteNotifications(func(n Notification) bool { return n.ChirpID == chirp.ID })
+
+	if chirp.ReplyToID == 0 && !db.hasReplies(chirp.ID) && !db.isQuoted(chirp.ID) {
+		return deleted
+	}
+	db.data.ChirpTombstones[chirp.ID] = ChirpTombstone{
+		ID:             chirp.ID,
+		ReplyToID:      chirp.ReplyToID,
+		ConversationID: chirp.ConversationID,
+		DeletedAt:      time.Now().UTC(),
+	}
+
+	return deleted
 }
 
 // User is a single user.
 type User struct {
-	ID          int    `json:"id"`
-	Password    string `json:"password"`
-	Email       string `json:"email"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	ID           int           `json:"id"`
+	Password     string        `json:"password"`
+	Email        string        `json:"email"`
+	Handle       string        `json:"handle,omitempty"`
+	DisplayName  string        `json:"display_name,omitempty"`
+	Bio          string        `json:"bio,omitempty"`
+	AvatarID     int           `json:"avatar_id,omitempty"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Subscription *Subscription `json:"subscription,omitempty"`
+	// PasswordHistory holds the previous password hashes, most recent first.
+	PasswordHistory []string `json:"password_history,omitempty"`
+	// LegacyChirpyRed is the flag stored before subscriptions existed.
+	// It is migrated to a subscription when the database is loaded.
+	LegacyChirpyRed bool `json:"is_chirpy_red,omitempty"`
+	// MutedNotifications are the notification types the user does not receive.
+	MutedNotifications []string `json:"muted_notifications,omitempty"`
+	// Roles are carried by the access tokens of the user, like the admin role.
+	Roles []string `json:"roles,omitempty"`
+	// Suspension is the last suspension of the user, which may have expired.
+	Suspension *Suspension `json:"suspension,omitempty"`
+}
+
+// clone returns a copy of the user sharing no subscription nor suspension with the stored one.
+func (u User) clone() User {
+	u.Subscription = u.Subscription.clone()
+	if u.Suspension != nil {
+		suspension := *u.Suspension
+		if suspension.Appeal != nil {
+			appeal := *suspension.Appeal
+			suspension.Appeal = &appeal
+		}
+		u.Suspension = &suspension
+	}
+	return u
+}
+
+// IsChirpyRed reports whether the user currently has a Chirpy Red subscription.
+func (u User) IsChirpyRed() bool {
+	return u.Subscription.IsActive(time.Now().UTC())
 }
 
 // CreateUser creates a new user and saves it to disk
@@ -132,15 +449,16 @@ func (db *DB) CreateUser(email, password string) (User, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 	if _, ok := db.data.Users[email]; ok {
-		return User{}, errors.New("user already exists")
+		return User{}, fmt.Errorf("user %w", ErrAlreadyExists)
 	}
 
-	id := len(db.data.Users) + 1
+	id := db.nextID(seqUsers)
 
 	user := User{
-		ID:       id,
-		Password: password,
-		Email:    email,
+		ID:        id,
+		Password:  password,
+		Email:     email,
+		CreatedAt: time.Now().UTC(),
 	}
 	db.data.Users[email] = user
 	if err := db.writeDB(db.data); err != nil {
@@ -150,37 +468,88 @@ func (db *DB) CreateUser(email, password string) (User, error) {
 	return user, nil
 }
 
-// UpdateUser creates a new user and saves it to disk
+// UserUpdate is the email, password hash and public profile of a user, updated together by PatchUser.
+type UserUpdate struct {
+	Email       string
+	Password    string
+	Handle      string
+	DisplayName string
+	Bio         string
+}
+
+// UpdateUser updates the email and the password of a user and saves it to disk
 func (db *DB) UpdateUser(id int, email, password string) (User, error) {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
-	for _, user := range db.data.Users {
-		if user.ID == id {
-			if user.Email != email {
-				delete(db.data.Users, email)
-			}
-			user.Email = email
-			user.Password = password
-			db.data.Users[email] = user
-			if err := db.writeDB(db.data); err != nil {
-				return User{}, fmt.Errorf("write db: %w", err)
+	user, err := db.getUser(id)
+	if err != nil {
+		return User{}, err
+	}
+
+	return db.patchUser(*user, UserUpdate{Email: email, Password: password, Handle: user.Handle, DisplayName: user.DisplayName, Bio: user.Bio})
+}
+
+// PatchUser updates the email, the password and the public profile of a user at once and saves it to disk.
+// Nothing is updated when the email or the handle is taken.
+func (db *DB) PatchUser(id int, update UserUpdate) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, err := db.getUser(id)
+	if err != nil {
+		return User{}, err
+	}
+
+	return db.patchUser(*user, update)
+}
+
+// patchUser checks that the email and the handle are free before updating the user and saving it to disk.
+// Handles are unique, regardless of their case. The caller must hold the lock.
+func (db *DB) patchUser(user User, update UserUpdate) (User, error) {
+	if update.Email != user.Email {
+		if _, ok := db.data.Users[update.Email]; ok {
+			return User{}, fmt.Errorf("email %w", ErrAlreadyExists)
+		}
+	}
+	if update.Handle != "" {
+		for _, other := range db.data.Users {
+			if other.ID != user.ID && strings.EqualFold(other.Handle, update.Handle) {
+				return User{}, fmt.Errorf("handle %w", ErrAlreadyExists)
 			}
-			return user, nil
 		}
 	}
 
-	return User{}, errors.New("user not found")
+	// users are indexed by email, the old entry must go.
+	delete(db.data.Users, user.Email)
+	if user.Password != update.Password {
+		user.PasswordHistory = append([]string{user.Password}, user.PasswordHistory...)
+		if len(user.PasswordHistory) > maxPasswordHistory {
+			user.PasswordHistory = user.PasswordHistory[:maxPasswordHistory]
+		}
+	}
+	user.Email = update.Email
+	user.Password = update.Password
+	user.Handle = update.Handle
+	user.DisplayName = update.DisplayName
+	user.Bio = update.Bio
+	db.data.Users[user.Email] = user
+	if err := db.writeDB(db.data); err != nil {
+		return User{}, fmt.Errorf("write db: %w", err)
+	}
+
+	return user.clone(), nil
 }
 
-// CreateUser creates a new user and saves it to disk
-func (db *DB) UpgradeUser(id int) error {
+// RehashPassword replaces the password hash of a user with a new hash of the same password.
+// Unlike UpdateUser, the password history is left untouched.
+func (db *DB) RehashPassword(id int, password string) error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
 	for email, user := range db.data.Users {
 		if user.ID == id {
-			user.IsChirpyRed = true
+			user.Password = password
 			db.data.Users[email] = user
 			if err := db.writeDB(db.data); err != nil {
 				return fmt.Errorf("write db: %w", err)
@@ -201,6 +570,7 @@ func (db *DB) GetUserByEmail(email string) (*User, error) {
 	if !ok {
 		return nil, ErrNotFound
 	}
+	user = user.clone()
 
 	return &user, nil
 }
@@ -210,8 +580,14 @@ func (db *DB) GetUser(id int) (*User, error) {
 	db.mux.RLock()
 	defer db.mux.RUnlock()
 
+	return db.getUser(id)
+}
+
+// getUser returns a single user. The caller must hold the lock.
+func (db *DB) getUser(id int) (*User, error) {
 	for _, user := range db.data.Users {
 		if user.ID == id {
+			user = user.clone()
 			return &user, nil
 		}
 	}
@@ -240,6 +616,173 @@ func (db *DB) IsTokenRevoked(token string) bool {
 	return ok
 }
 
+// PersonalAccessToken is a long-lived token created by a user for automation.
+// Only the hash of the token is stored.
+type PersonalAccessToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"hash"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// CreatePersonalAccessToken stores a new personal access token and saves it to disk.
+func (db *DB) CreatePersonalAccessToken(userID int, name, hash string, scopes []string) (PersonalAccessToken, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	id := db.nextID(seqPersonalAccessTokens)
+	pat := PersonalAccessToken{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	db.data.PersonalAccessTokens[id] = pat
+	if err := db.writeDB(db.data); err != nil {
+		return PersonalAccessToken{}, fmt.Errorf("write db: %w", err)
+	}
+
+	return pat, nil
+}
+
+// ListPersonalAccessTokens returns the personal access tokens of a user, sorted by id.
+func (db *DB) ListPersonalAccessTokens(userID int) ([]PersonalAccessToken, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	pats := []PersonalAccessToken{}
+	for _, pat := range db.data.PersonalAccessTokens {
+		if pat.UserID == userID {
+			pats = append(pats, pat)
+		}
+	}
+
+	slices.SortFunc(pats, func(i, j PersonalAccessToken) int {
+		return i.ID - j.ID
+	})
+
+	return pats, nil
+}
+
+// lastUsedResolution is how often the usage of a personal access token is saved,
+// so that the requests authenticated with it do not all rewrite the database.
+const lastUsedResolution = time.Minute
+
+// GetPersonalAccessTokenByHash returns the personal access token matching the hash
+// and records its usage, at most once per lastUsedResolution. The usage of revoked tokens is not recorded.
+func (db *DB) GetPersonalAccessTokenByHash(hash string) (*PersonalAccessToken, error) {
+	db.mux.RLock()
+	pat, ok := db.findPersonalAccessToken(hash)
+	db.mux.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	now := time.Now().UTC()
+	if pat.RevokedAt != nil || pat.LastUsedAt != nil && now.Sub(*pat.LastUsedAt) < lastUsedResolution {
+		return &pat, nil
+	}
+
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	// the token may have been revoked, or its usage recorded, since it was read.
+	pat, ok = db.findPersonalAccessToken(hash)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if pat.RevokedAt != nil || pat.LastUsedAt != nil && now.Sub(*pat.LastUsedAt) < lastUsedResolution {
+		return &pat, nil
+	}
+	pat.LastUsedAt = &now
+	db.data.PersonalAccessTokens[pat.ID] = pat
+	if err := db.writeDB(db.data); err != nil {
+		return nil, fmt.Errorf("write db: %w", err)
+	}
+
+	return &pat, nil
+}
+
+// findPersonalAccessToken returns the personal access token matching the hash. The caller must hold the lock.
+func (db *DB) findPersonalAccessToken(hash string) (PersonalAccessToken, bool) {
+	for _, pat := range db.data.PersonalAccessTokens {
+		if pat.Hash == hash {
+			return pat, true
+		}
+	}
+	return PersonalAccessToken{}, false
+}
+
+// RevokePersonalAccessToken revokes a personal access token owned by the user.
+func (db *DB) RevokePersonalAccessToken(userID, id int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	pat, ok := db.data.PersonalAccessTokens[id]
+	if !ok || pat.UserID != userID {
+		return ErrNotFound
+	}
+	if pat.RevokedAt != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	pat.RevokedAt = &now
+	db.data.PersonalAccessTokens[id] = pat
+	if err := db.writeDB(db.data); err != nil {
+		return fmt.Errorf("write db: %w", err)
+	}
+
+	return nil
+}
+
+// webhookEventRetention is how long processed webhook event ids are kept.
+const webhookEventRetention = 7 * 24 * time.Hour
+
+// ClaimWebhookEvent records a webhook event id as processed.
+// It returns false if the event has already been claimed.
+func (db *DB) ClaimWebhookEvent(id string) (bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, ok := db.data.WebhookEvents[id]; ok {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	for eventID, processedAt := range db.data.WebhookEvents {
+		if now.Sub(processedAt) > webhookEventRetention {
+			delete(db.data.WebhookEvents, eventID)
+		}
+	}
+
+	db.data.WebhookEvents[id] = now
+	if err := db.writeDB(db.data); err != nil {
+		return false, fmt.Errorf("write db: %w", err)
+	}
+
+	return true, nil
+}
+
+// ReleaseWebhookEvent forgets a claimed webhook event id,
+// so that the event can be processed again when it is retried.
+func (db *DB) ReleaseWebhookEvent(id string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	delete(db.data.WebhookEvents, id)
+	if err := db.writeDB(db.data); err != nil {
+		return fmt.Errorf("write db: %w", err)
+	}
+
+	return nil
+}
+
 // loadDB reads the database file into memory
 func (db *DB) loadDB() error {
 	db.mux.Lock()
@@ -254,9 +797,140 @@ func (db *DB) loadDB() error {
 		return fmt.Errorf("unmarshal db: %w", err)
 	}
 
+	// database files written by older versions may miss some collections.
+	if db.data.Chirps == nil {
+		db.data.Chirps = map[int]Chirp{}
+	}
+	if db.data.Users == nil {
+		db.data.Users = map[string]User{}
+	}
+	if db.data.RevokedToken == nil {
+		db.data.RevokedToken = map[string]time.Time{}
+	}
+	if db.data.PersonalAccessTokens == nil {
+		db.data.PersonalAccessTokens = map[int]PersonalAccessToken{}
+	}
+	if db.data.WebhookEvents == nil {
+		db.data.WebhookEvents = map[string]time.Time{}
+	}
+
+	if db.data.Sessions == nil {
+		db.data.Sessions = map[string]Session{}
+	}
+	if db.data.AccountDeletions == nil {
+		db.data.AccountDeletions = map[int]AccountDeletion{}
+	}
+	if db.data.Media == nil {
+		db.data.Media = map[int]Media{}
+	}
+	if db.data.ChirpRevisions == nil {
+		db.data.ChirpRevisions = map[int][]ChirpRevision{}
+	}
+	if db.data.ChirpTombstones == nil {
+		db.data.ChirpTombstones = map[int]ChirpTombstone{}
+	}
+	if db.data.Likes == nil {
+		db.data.Likes = map[int]Like{}
+	}
+	if db.data.Follows == nil {
+		db.data.Follows = map[int]Follow{}
+	}
+	if db.data.Notifications == nil {
+		db.data.Notifications = map[int]Notification{}
+	}
+	if db.data.Reports == nil {
+		db.data.Reports = map[int]Report{}
+	}
+	if db.data.Decisions == nil {
+		db.data.Decisions = map[int]Decision{}
+	}
+	if db.data.Blocks == nil {
+		db.data.Blocks = map[int]Block{}
+	}
+	if db.data.Mutes == nil {
+		db.data.Mutes = map[int]Mute{}
+	}
+	if db.data.Sequences == nil {
+		db.data.Sequences = map[string]int{}
+	}
+
+	// sequences didn't exist in older versions, they start after the highest id.
+	for id := range db.data.Chirps {
+		db.data.Sequences[seqChirps] = max(db.data.Sequences[seqChirps], id)
+	}
+	for _, user := range db.data.Users {
+		db.data.Sequences[seqUsers] = max(db.data.Sequences[seqUsers], user.ID)
+	}
+	for id := range db.data.PersonalAccessTokens {
+		db.data.Sequences[seqPersonalAccessTokens] = max(db.data.Sequences[seqPersonalAccessTokens], id)
+	}
+	for id := range db.data.Likes {
+		db.data.Sequences[seqLikes] = max(db.data.Sequences[seqLikes], id)
+	}
+	for id := range db.data.Follows {
+		db.data.Sequences[seqFollows] = max(db.data.Sequences[seqFollows], id)
+	}
+	for id := range db.data.Notifications {
+		db.data.Sequences[seqNotifications] = max(db.data.Sequences[seqNotifications], id)
+	}
+	for id := range db.data.Media {
+		db.data.Sequences[seqMedia] = max(db.data.Sequences[seqMedia], id)
+	}
+	for id := range db.data.Reports {
+		db.data.Sequences[seqReports] = max(db.data.Sequences[seqReports], id)
+	}
+	for id := range db.data.Decisions {
+		db.data.Sequences[seqDecisions] = max(db.data.Sequences[seqDecisions], id)
+	}
+	for id := range db.data.Blocks {
+		db.data.Sequences[seqBlocks] = max(db.data.Sequences[seqBlocks], id)
+	}
+	for id := range db.data.Mutes {
+		db.data.Sequences[seqMutes] = max(db.data.Sequences[seqMutes], id)
+	}
+
+	// entities didn't exist in older versions, they are parsed from the stored bodies.
+	for id, chirp := range db.data.Chirps {
+		if chirp.Entities == nil && chirp.Body != "" {
+			chirp.Entities = db.parseEntities(chirp.AuthorID, chirp.Body)
+			db.data.Chirps[id] = chirp
+		}
+	}
+
+	// the users flagged Chirpy Red before subscriptions existed get a subscription of a period,
+	// renewed by Polka as any other. The subscriptions migrated without period end get one too.
+	now, migrated := time.Now().UTC(), false
+	for email, user := range db.data.Users {
+		sub := user.Subscription.clone()
+		if user.LegacyChirpyRed && sub == nil {
+			sub = &Subscription{Status: SubscriptionActive}
+		}
+		if sub != nil && (sub.Status == SubscriptionActive || sub.Status == SubscriptionPastDue) && sub.PeriodEnd.IsZero() {
+			sub.PeriodEnd = now.Add(SubscriptionPeriod)
+			sub.record(EventMigrated, now)
+			migrated = true
+		}
+		user.Subscription = sub
+		user.LegacyChirpyRed = false
+		db.data.Users[email] = user
+	}
+	// the period end is saved, a restart must not extend it.
+	if migrated {
+		if err := db.writeDB(db.data); err != nil {
+			return fmt.Errorf("write db: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// nextID allocates the next id of a sequence.
+// The caller must hold the write lock.
+func (db *DB) nextID(sequence string) int {
+	db.data.Sequences[sequence]++
+	return db.data.Sequences[sequence]
+}
+
 // writeDB writes the database file to disk
 func (db *DB) writeDB(dbStructure DBStructure) error {
 	data, err := json.Marshal(dbStructure)
@@ -2,9 +2,13 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"testing"
+	"time"
 )
 
 func TestDB_CreateChirp(t *testing.T) {
@@ -17,13 +21,16 @@ func TestDB_CreateChirp(t *testing.T) {
 	}
 	defer os.Remove(dbPath)
 
-	got, err := db.CreateChirp("I had something interesting for breakfast", 1)
+	got, err := db.CreateChirp(Chirp{Body: "I had something interesting for breakfast", AuthorID: 1})
 	if err != nil {
 		t.Errorf("CreateChirp should not have an error %v", err)
 		return
 	}
 
-	want := Chirp{ID: 1, AuthorID: 1, Body: "I had something interesting for breakfast"}
+	if got.CreatedAt == nil {
+		t.Fatalf("CreateChirp() should set the creation time")
+	}
+	want := Chirp{ID: 1, AuthorID: 1, Body: "I had something interesting for breakfast", CreatedAt: got.CreatedAt}
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("CreateChirp() got = %v, want %v", got, want)
 	}
@@ -53,7 +60,7 @@ func TestDB_GetChirps(t *testing.T) {
 		return
 	}
 
-	got, err := db.ListChirps("", "")
+	got, err := db.ListChirps(-1, 0, "")
 	if err != nil {
 		t.Errorf("ListChirps should not have an error %v", err)
 		return
@@ -66,3 +73,1036 @@ func TestDB_GetChirps(t *testing.T) {
 		t.Errorf("ListChirps() got = %v, want %v", got, want)
 	}
 }
+
+func TestSubscription_Apply(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		events     []string
+		at         time.Time
+		wantStatus string
+		wantActive bool
+		wantErr    bool
+	}{
+		{
+			name:       "Upgraded",
+			events:     []string{EventUpgraded},
+			at:         now.Add(time.Hour),
+			wantStatus: SubscriptionActive,
+			wantActive: true,
+		},
+		{
+			name:       "Upgraded then lapsed",
+			events:     []string{EventUpgraded},
+			at:         now.Add(SubscriptionPeriod + time.Hour),
+			wantStatus: SubscriptionActive,
+			wantActive: false,
+		},
+		{
+			name:       "Renewed",
+			events:     []string{EventUpgraded, EventRenewed},
+			at:         now.Add(SubscriptionPeriod + time.Hour),
+			wantStatus: SubscriptionActive,
+			wantActive: true,
+		},
+		{
+			name:       "Payment failed within grace period",
+			events:     []string{EventUpgraded, EventPaymentFailed},
+			at:         now.Add(SubscriptionPeriod + time.Hour),
+			wantStatus: SubscriptionPastDue,
+			wantActive: true,
+		},
+		{
+			name:       "Downgraded",
+			events:     []string{EventUpgraded, EventDowngraded},
+			at:         now.Add(time.Hour),
+			wantStatus: SubscriptionCanceled,
+			wantActive: false,
+		},
+		{
+			name:    "Renewed without subscription",
+			events:  []string{EventRenewed},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sub := &Subscription{}
+			var err error
+			for _, event := range test.events {
+				if err = sub.Apply(event, now, time.Time{}); err != nil {
+					break
+				}
+			}
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if test.wantErr {
+				return
+			}
+
+			if sub.Status != test.wantStatus {
+				t.Errorf("Apply() status = %s, want %s", sub.Status, test.wantStatus)
+			}
+			if got := sub.IsActive(test.at); got != test.wantActive {
+				t.Errorf("IsActive() = %v, want %v", got, test.wantActive)
+			}
+			if len(sub.History) != len(test.events) {
+				t.Errorf("Apply() history = %d entries, want %d", len(sub.History), len(test.events))
+			}
+		})
+	}
+}
+
+func TestDB_UpdateUserEmail(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+
+	user, err := db.CreateUser("old@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+	if _, err := db.CreateUser("taken@example.com", "hash"); err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+
+	if _, err := db.UpdateUser(user.ID, "taken@example.com", "hash"); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("UpdateUser() error = %v, want %v", err, ErrAlreadyExists)
+	}
+
+	if _, err := db.UpdateUser(user.ID, "new@example.com", "hash"); err != nil {
+		t.Fatalf("UpdateUser should not have an error %v", err)
+	}
+
+	if _, err := db.GetUserByEmail("old@example.com"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUserByEmail() old email error = %v, want %v", err, ErrNotFound)
+	}
+	got, err := db.GetUserByEmail("new@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail() new email error = %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("GetUserByEmail() id = %d, want %d", got.ID, user.ID)
+	}
+	if len(db.data.Users) != 2 {
+		t.Errorf("UpdateUser() users = %d, want 2", len(db.data.Users))
+	}
+}
+
+func TestDB_PatchUser(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+
+	user, err := db.CreateUser("old@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+	other, err := db.CreateUser("taken@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+	if _, err := db.UpdateUserProfile(other.ID, "taken", "", ""); err != nil {
+		t.Fatalf("UpdateUserProfile should not have an error %v", err)
+	}
+
+	// a conflict leaves the user untouched.
+	conflicts := []UserUpdate{
+		{Email: "taken@example.com", Password: "new hash", Handle: "fresh"},
+		{Email: "new@example.com", Password: "new hash", Handle: "TAKEN"},
+	}
+	for _, update := range conflicts {
+		if _, err := db.PatchUser(user.ID, update); !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("PatchUser(%+v) error = %v, want %v", update, err, ErrAlreadyExists)
+		}
+		got, err := db.GetUser(user.ID)
+		if err != nil {
+			t.Fatalf("GetUser should not have an error %v", err)
+		}
+		if got.Email != "old@example.com" || got.Password != "hash" || got.Handle != "" || len(got.PasswordHistory) != 0 {
+			t.Errorf("GetUser() = %+v, want the user untouched", got)
+		}
+	}
+
+	got, err := db.PatchUser(user.ID, UserUpdate{Email: "new@example.com", Password: "new hash", Handle: "fresh", Bio: "bio"})
+	if err != nil {
+		t.Fatalf("PatchUser should not have an error %v", err)
+	}
+	if got.Email != "new@example.com" || got.Password != "new hash" || got.Handle != "fresh" || got.Bio != "bio" {
+		t.Errorf("PatchUser() = %+v, want every field updated", got)
+	}
+	if len(got.PasswordHistory) != 1 || got.PasswordHistory[0] != "hash" {
+		t.Errorf("PatchUser() password history = %v, want the previous hash", got.PasswordHistory)
+	}
+}
+
+func TestDB_GetPersonalAccessTokenByHash(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	pat, err := db.CreatePersonalAccessToken(1, "ci", "hash", []string{"chirps:read"})
+	if err != nil {
+		t.Fatalf("CreatePersonalAccessToken should not have an error %v", err)
+	}
+
+	first, err := db.GetPersonalAccessTokenByHash("hash")
+	if err != nil || first.LastUsedAt == nil {
+		t.Fatalf("GetPersonalAccessTokenByHash() = %v, %v, want the usage recorded", first, err)
+	}
+	// the usage is only recorded once per minute.
+	second, err := db.GetPersonalAccessTokenByHash("hash")
+	if err != nil || !second.LastUsedAt.Equal(*first.LastUsedAt) {
+		t.Errorf("GetPersonalAccessTokenByHash() last used = %v, want %v", second.LastUsedAt, first.LastUsedAt)
+	}
+
+	if err := db.RevokePersonalAccessToken(1, pat.ID); err != nil {
+		t.Fatalf("RevokePersonalAccessToken should not have an error %v", err)
+	}
+	revoked, err := db.GetPersonalAccessTokenByHash("hash")
+	if err != nil || revoked.RevokedAt == nil {
+		t.Errorf("GetPersonalAccessTokenByHash() = %v, %v, want the revoked token", revoked, err)
+	}
+	if _, err := db.GetPersonalAccessTokenByHash("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetPersonalAccessTokenByHash() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDB_DeleteUser(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+
+	user, err := db.CreateUser("leaving@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+	chirp, err := db.CreateChirp(Chirp{Body: "goodbye", AuthorID: user.ID})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	if _, err := db.CreatePersonalAccessToken(user.ID, "ci", "hash", []string{"chirps:read"}); err != nil {
+		t.Fatalf("CreatePersonalAccessToken should not have an error %v", err)
+	}
+
+	deletion, deleted, err := db.DeleteUser(user.ID, ChirpsAnonymize)
+	if err != nil {
+		t.Fatalf("DeleteUser should not have an error %v", err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("DeleteUser() deleted %v, want the chirps anonymized", deleted)
+	}
+	want := AccountDeletion{UserID: user.ID, DeletedAt: deletion.DeletedAt, ChirpPolicy: ChirpsAnonymize, Chirps: 1, Tokens: 1}
+	if !reflect.DeepEqual(deletion, want) {
+		t.Errorf("DeleteUser() got = %v, want %v", deletion, want)
+	}
+
+	again, _, err := db.DeleteUser(user.ID, ChirpsDelete)
+	if err != nil {
+		t.Fatalf("DeleteUser should be idempotent, got error %v", err)
+	}
+	if !reflect.DeepEqual(again, deletion) {
+		t.Errorf("DeleteUser() got = %v, want %v", again, deletion)
+	}
+	if audit, err := db.GetAccountDeletion(user.ID); err != nil || !reflect.DeepEqual(*audit, deletion) {
+		t.Errorf("GetAccountDeletion() = %v, %v, want %v", audit, err, deletion)
+	}
+
+	if _, err := db.GetUser(user.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetUser() error = %v, want %v", err, ErrNotFound)
+	}
+	got, err := db.GetChirp(chirp.ID)
+	if err != nil {
+		t.Fatalf("GetChirp should not have an error %v", err)
+	}
+	if got.AuthorID != AnonymousAuthorID {
+		t.Errorf("GetChirp() author = %d, want %d", got.AuthorID, AnonymousAuthorID)
+	}
+
+	newUser, err := db.CreateUser("leaving@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+	if newUser.ID == user.ID {
+		t.Errorf("CreateUser() reused the id %d of a deleted user", user.ID)
+	}
+}
+
+func TestDB_DeleteUser_Chirps(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	for _, email := range []string{"leaving@example.com", "staying@example.com"} {
+		if _, err := db.CreateUser(email, "hash"); err != nil {
+			t.Fatalf("CreateUser should not have an error %v", err)
+		}
+	}
+	chirp, err := db.CreateChirp(Chirp{Body: "goodbye", AuthorID: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	rechirp, _, err := db.Rechirp(2, chirp.ID)
+	if err != nil {
+		t.Fatalf("Rechirp should not have an error %v", err)
+	}
+
+	// the rechirps of the deleted chirps are returned with them.
+	_, deleted, err := db.DeleteUser(1, ChirpsDelete)
+	if err != nil {
+		t.Fatalf("DeleteUser should not have an error %v", err)
+	}
+	if len(deleted) != 2 || deleted[0].ID != chirp.ID || deleted[1].ID != rechirp.ID {
+		t.Errorf("DeleteUser() deleted %v, want the chirp and its rechirp", deleted)
+	}
+	if _, err := db.GetChirp(rechirp.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetChirp() of the rechirp error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDB_ChirpAttachments(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+
+	media, err := db.CreateMedia(Media{OwnerID: 1, Kind: MediaChirp, Key: "chirps/1.png", ThumbnailKey: "chirps/1_thumb.png", ContentType: "image/png"})
+	if err != nil {
+		t.Fatalf("CreateMedia should not have an error %v", err)
+	}
+
+	if _, err := db.CreateChirp(Chirp{Body: "not mine", AuthorID: 2, Attachments: []Attachment{{MediaID: media.ID}}}); !errors.Is(err, ErrInvalidAttachment) {
+		t.Errorf("CreateChirp() error = %v, want %v", err, ErrInvalidAttachment)
+	}
+
+	chirp, err := db.CreateChirp(Chirp{Body: "look at this", AuthorID: 1, Attachments: []Attachment{{MediaID: media.ID, AltText: "a cat"}}})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	want := []Attachment{{MediaID: media.ID, AltText: "a cat", ContentType: "image/png"}}
+	if !reflect.DeepEqual(chirp.Attachments, want) {
+		t.Errorf("CreateChirp() attachments = %v, want %v", chirp.Attachments, want)
+	}
+
+	if _, err := db.CreateChirp(Chirp{Body: "again", AuthorID: 1, Attachments: []Attachment{{MediaID: media.ID}}}); !errors.Is(err, ErrInvalidAttachment) {
+		t.Errorf("CreateChirp() error = %v, want %v", err, ErrInvalidAttachment)
+	}
+
+	_, released, err := db.DeleteChirp(chirp.ID)
+	if err != nil {
+		t.Fatalf("DeleteChirp should not have an error %v", err)
+	}
+	if len(released) != 1 || released[0].ID != media.ID {
+		t.Errorf("DeleteChirp() released = %v, want media %d", released, media.ID)
+	}
+	if _, err := db.GetMedia(media.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetMedia() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDB_ListChirpsPage(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	for _, body := range []string{"one", "two", "three", "four", "five"} {
+		if _, err := db.CreateChirp(Chirp{Body: body, AuthorID: 1}); err != nil {
+			t.Fatalf("CreateChirp should not have an error %v", err)
+		}
+	}
+	// the bound chirp can be deleted between two pages.
+	if _, _, err := db.DeleteChirp(3); err != nil {
+		t.Fatalf("DeleteChirp should not have an error %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		page     ChirpPage
+		wantIDs  []int
+		wantMore bool
+	}{
+		{name: "first page", page: ChirpPage{AuthorID: -1, Sort: "asc", Limit: 2}, wantIDs: []int{1, 2}, wantMore: true},
+		{name: "after a deleted chirp", page: ChirpPage{AuthorID: -1, Sort: "asc", AfterID: 3, Limit: 2}, wantIDs: []int{4, 5}},
+		{name: "before", page: ChirpPage{AuthorID: -1, Sort: "asc", BeforeID: 5, Limit: 2}, wantIDs: []int{2, 4}, wantMore: true},
+		{name: "desc after", page: ChirpPage{AuthorID: -1, Sort: "desc", AfterID: 4, Limit: 2}, wantIDs: []int{2, 1}},
+		{name: "desc before", page: ChirpPage{AuthorID: -1, Sort: "desc", BeforeID: 2, Limit: 1}, wantIDs: []int{4}, wantMore: true},
+		{name: "other author", page: ChirpPage{AuthorID: 2, Sort: "asc", Limit: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chirps, more, err := db.ListChirpsPage(tt.page)
+			if err != nil {
+				t.Fatalf("ListChirpsPage should not have an error %v", err)
+			}
+			var ids []int
+			for _, chirp := range chirps {
+				ids = append(ids, chirp.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) || more != tt.wantMore {
+				t.Errorf("ListChirpsPage() got = %v %v, want %v %v", ids, more, tt.wantIDs, tt.wantMore)
+			}
+		})
+	}
+}
+
+func TestDB_UpdateChirp(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+
+	chirp, err := db.CreateChirp(Chirp{Body: "first", AuthorID: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	for _, body := range []string{"second", "third"} {
+		if chirp, err = db.UpdateChirp(chirp.ID, body); err != nil {
+			t.Fatalf("UpdateChirp should not have an error %v", err)
+		}
+	}
+	if chirp.Body != "third" || chirp.EditedAt == nil {
+		t.Errorf("UpdateChirp() got = %v, want an edited chirp with body third", chirp)
+	}
+
+	revisions, err := db.ListChirpRevisions(chirp.ID)
+	if err != nil {
+		t.Fatalf("ListChirpRevisions should not have an error %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Body != "first" || revisions[1].Body != "second" {
+		t.Fatalf("ListChirpRevisions() got = %v, want first and second", revisions)
+	}
+	if !revisions[1].CreatedAt.Equal(revisions[0].ReplacedAt) {
+		t.Errorf("ListChirpRevisions() the second revision should be created when the first is replaced, got %v", revisions)
+	}
+
+	if _, _, err := db.DeleteChirp(chirp.ID); err != nil {
+		t.Fatalf("DeleteChirp should not have an error %v", err)
+	}
+	if _, err := db.ListChirpRevisions(chirp.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ListChirpRevisions() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := db.UpdateChirp(chirp.ID, "fourth"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateChirp() error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDB_Conversation(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+
+	root, err := db.CreateChirp(Chirp{Body: "root", AuthorID: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	reply, err := db.CreateChirp(Chirp{Body: "reply", AuthorID: 2, ReplyToID: root.ID})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	nested, err := db.CreateChirp(Chirp{Body: "nested", AuthorID: 1, ReplyToID: reply.ID})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	if nested.ReplyToID != reply.ID || nested.ConversationID != root.ID {
+		t.Errorf("CreateChirp() got = %v, want a reply to %d in conversation %d", nested, reply.ID, root.ID)
+	}
+	if _, err := db.CreateChirp(Chirp{Body: "orphan", AuthorID: 1, ReplyToID: 42}); !errors.Is(err, ErrInvalidReply) {
+		t.Errorf("CreateChirp() error = %v, want %v", err, ErrInvalidReply)
+	}
+
+	if _, _, err := db.DeleteChirp(reply.ID); err != nil {
+		t.Fatalf("DeleteChirp should not have an error %v", err)
+	}
+
+	conversation, err := db.GetConversation(nested.ID)
+	if err != nil {
+		t.Fatalf("GetConversation should not have an error %v", err)
+	}
+	if conversation.ID != root.ID || len(conversation.Chirps) != 2 || len(conversation.Deleted) != 1 || conversation.Deleted[0].ID != reply.ID {
+		t.Errorf("GetConversation() got = %v, want the root, the nested reply and the deleted reply", conversation)
+	}
+	if _, err := db.GetConversation(reply.ID); err != nil {
+		t.Errorf("GetConversation() of a deleted reply should not have an error %v", err)
+	}
+
+	counts, err := db.CountReplies([]int{root.ID, nested.ID})
+	if err != nil {
+		t.Fatalf("CountReplies should not have an error %v", err)
+	}
+	if !reflect.DeepEqual(counts, map[int]int{root.ID: 0, nested.ID: 0}) {
+		t.Errorf("CountReplies() got = %v, want no live reply", counts)
+	}
+}
+
+func TestDB_Likes(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	chirp, err := db.CreateChirp(Chirp{Body: "like me", AuthorID: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+
+	first, created, err := db.LikeChirp(2, chirp.ID)
+	if err != nil || !created {
+		t.Fatalf("LikeChirp() = %v, %v, want a new like", created, err)
+	}
+	again, created, err := db.LikeChirp(2, chirp.ID)
+	if err != nil || created || again.ID != first.ID {
+		t.Errorf("LikeChirp() twice = %v, %v, %v, want the existing like", again, created, err)
+	}
+	if _, _, err := db.LikeChirp(3, chirp.ID); err != nil {
+		t.Fatalf("LikeChirp should not have an error %v", err)
+	}
+	if _, _, err := db.LikeChirp(2, 42); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LikeChirp() error = %v, want %v", err, ErrNotFound)
+	}
+
+	likes, more, err := db.ListLikes(chirp.ID, 0, 1)
+	if err != nil || !more || len(likes) != 1 || likes[0].UserID != 3 {
+		t.Errorf("ListLikes() = %v, %v, %v, want the most recent like and more", likes, more, err)
+	}
+	if likes, more, _ := db.ListLikes(chirp.ID, likes[0].ID, 1); more || len(likes) != 1 || likes[0].UserID != 2 {
+		t.Errorf("ListLikes() second page = %v, %v, want the first like", likes, more)
+	}
+
+	if deleted, err := db.UnlikeChirp(2, chirp.ID); err != nil || !deleted {
+		t.Errorf("UnlikeChirp() = %v, %v, want a deleted like", deleted, err)
+	}
+	if deleted, err := db.UnlikeChirp(2, chirp.ID); err != nil || deleted {
+		t.Errorf("UnlikeChirp() twice = %v, %v, want nothing to delete", deleted, err)
+	}
+
+	counts, _ := db.CountLikes([]int{chirp.ID})
+	liked, _ := db.ListLikedChirps(3, []int{chirp.ID})
+	if counts[chirp.ID] != 1 || !liked[chirp.ID] {
+		t.Errorf("CountLikes() = %v, ListLikedChirps() = %v, want one like by user 3", counts, liked)
+	}
+
+	if _, err := db.Decide(Decision{AdminID: 4, Action: ActionHideChirp, ChirpID: chirp.ID}); err != nil {
+		t.Fatalf("Decide should not have an error %v", err)
+	}
+	if _, _, err := db.LikeChirp(2, chirp.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("LikeChirp() hidden chirp error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDB_Rechirps(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	original, err := db.CreateChirp(Chirp{Body: "share me", AuthorID: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+
+	rechirp, created, err := db.Rechirp(2, original.ID)
+	if err != nil || !created || rechirp.RechirpOfID != original.ID || rechirp.Body != "" {
+		t.Fatalf("Rechirp() = %v, %v, %v, want a new plain rechirp", rechirp, created, err)
+	}
+	if again, created, _ := db.Rechirp(2, rechirp.ID); created || again.ID != rechirp.ID {
+		t.Errorf("Rechirp() of the rechirp = %v, %v, want the existing rechirp", again, created)
+	}
+	quote, err := db.CreateChirp(Chirp{Body: "look", AuthorID: 3, QuoteOfID: rechirp.ID})
+	if err != nil || quote.QuoteOfID != original.ID {
+		t.Fatalf("CreateChirp() = %v, %v, want a quote of the original", quote, err)
+	}
+	if _, err := db.CreateChirp(Chirp{Body: "look", AuthorID: 3, QuoteOfID: 42}); !errors.Is(err, ErrInvalidQuote) {
+		t.Errorf("CreateChirp() error = %v, want %v", err, ErrInvalidQuote)
+	}
+
+	deleted, _, err := db.DeleteChirp(original.ID)
+	if err != nil {
+		t.Fatalf("DeleteChirp should not have an error %v", err)
+	}
+	if len(deleted) != 2 || deleted[0].ID != original.ID || deleted[1].ID != rechirp.ID {
+		t.Errorf("DeleteChirp() deleted = %v, want the original then its rechirp", deleted)
+	}
+	if _, err := db.GetChirp(rechirp.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetChirp() of the rechirp error = %v, want %v", err, ErrNotFound)
+	}
+	if got, err := db.GetChirp(quote.ID); err != nil || got.QuoteOfID != original.ID {
+		t.Errorf("GetChirp() of the quote = %v, %v, want the quote with its reference", got, err)
+	}
+	if _, err := db.GetConversation(original.ID); err != nil {
+		t.Errorf("GetConversation() of the quoted chirp should find its tombstone, got %v", err)
+	}
+}
+
+func TestDB_HiddenOriginal(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	original, err := db.CreateChirp(Chirp{Body: "hide me", AuthorID: 1})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	rechirp, _, err := db.Rechirp(2, original.ID)
+	if err != nil {
+		t.Fatalf("Rechirp should not have an error %v", err)
+	}
+	if _, err := db.Decide(Decision{AdminID: 4, Action: ActionHideChirp, ChirpID: original.ID}); err != nil {
+		t.Fatalf("Decide should not have an error %v", err)
+	}
+
+	// the hidden chirp cannot be reached through its rechirps either.
+	for _, id := range []int{original.ID, rechirp.ID} {
+		if _, _, err := db.Rechirp(3, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Rechirp(%d) error = %v, want %v", id, err, ErrNotFound)
+		}
+		if _, err := db.CreateChirp(Chirp{Body: "reply", AuthorID: 3, ReplyToID: id}); !errors.Is(err, ErrInvalidReply) {
+			t.Errorf("CreateChirp() reply to %d error = %v, want %v", id, err, ErrInvalidReply)
+		}
+		if _, err := db.CreateChirp(Chirp{Body: "quote", AuthorID: 3, QuoteOfID: id}); !errors.Is(err, ErrInvalidQuote) {
+			t.Errorf("CreateChirp() quote of %d error = %v, want %v", id, err, ErrInvalidQuote)
+		}
+	}
+}
+
+func TestDB_Follows(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	var users []User
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		user, err := db.CreateUser(email, "hash")
+		if err != nil {
+			t.Fatalf("CreateUser should not have an error %v", err)
+		}
+		users = append(users, user)
+	}
+	a, b, c := users[0].ID, users[1].ID, users[2].ID
+
+	if _, _, err := db.FollowUser(a, a); !errors.Is(err, ErrSelfFollow) {
+		t.Errorf("FollowUser() error = %v, want %v", err, ErrSelfFollow)
+	}
+	if _, _, err := db.FollowUser(a, 42); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FollowUser() error = %v, want %v", err, ErrNotFound)
+	}
+	first, created, err := db.FollowUser(a, b)
+	if err != nil || !created {
+		t.Fatalf("FollowUser() = %v, %v, want a new follow", created, err)
+	}
+	if again, created, _ := db.FollowUser(a, b); created || again.ID != first.ID {
+		t.Errorf("FollowUser() twice = %v, %v, want the existing follow", again, created)
+	}
+	if _, _, err := db.FollowUser(c, b); err != nil {
+		t.Fatalf("FollowUser should not have an error %v", err)
+	}
+
+	followers, more, err := db.ListFollowers(b, 0, 1)
+	if err != nil || !more || len(followers) != 1 || followers[0].FollowerID != c {
+		t.Errorf("ListFollowers() = %v, %v, %v, want the most recent follower and more", followers, more, err)
+	}
+	if ids, _ := db.ListFollowedIDs(a); len(ids) != 1 || ids[0] != b {
+		t.Errorf("ListFollowedIDs() = %v, want [%d]", ids, b)
+	}
+
+	for _, authorID := range []int{a, b, c} {
+		if _, err := db.CreateChirp(Chirp{Body: "hello", AuthorID: authorID}); err != nil {
+			t.Fatalf("CreateChirp should not have an error %v", err)
+		}
+	}
+	chirps, _, err := db.ListChirpsPage(ChirpPage{AuthorID: -1, AuthorIDs: []int{a, b}, Sort: "desc", Limit: 10})
+	if err != nil || len(chirps) != 2 || chirps[0].AuthorID != b || chirps[1].AuthorID != a {
+		t.Errorf("ListChirpsPage() = %v, %v, want the chirps of b then a", chirps, err)
+	}
+
+	if deleted, err := db.UnfollowUser(a, b); err != nil || !deleted {
+		t.Errorf("UnfollowUser() = %v, %v, want a deleted follow", deleted, err)
+	}
+	if deleted, err := db.UnfollowUser(a, b); err != nil || deleted {
+		t.Errorf("UnfollowUser() twice = %v, %v, want nothing to delete", deleted, err)
+	}
+
+	if _, _, err := db.DeleteUser(c, ChirpsDelete); err != nil {
+		t.Fatalf("DeleteUser should not have an error %v", err)
+	}
+	if ids, _ := db.ListFollowerIDs(b); len(ids) != 0 {
+		t.Errorf("ListFollowerIDs() = %v, want the follows of the deleted user removed", ids)
+	}
+}
+
+func TestDB_Entities(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	user, err := db.CreateUser("alice@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+	if _, err := db.UpdateUserProfile(user.ID, "Alice", "", ""); err != nil {
+		t.Fatalf("UpdateUserProfile should not have an error %v", err)
+	}
+
+	chirp, err := db.CreateChirp(Chirp{Body: "hi @alice and @nobody #Go #go", AuthorID: 2})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	if len(chirp.Entities) != 3 || chirp.Entities[0].UserID != user.ID || chirp.Entities[1].Tag != "go" {
+		t.Errorf("CreateChirp() entities = %v, want the mention of alice and two hashtags", chirp.Entities)
+	}
+	if _, err := db.CreateChirp(Chirp{Body: "#rust", AuthorID: 2}); err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	if _, err := db.CreateChirp(Chirp{Body: "#rust again", AuthorID: 2}); err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+
+	mentions, _, err := db.ListChirpsPage(ChirpPage{AuthorID: -1, MentionedID: user.ID, Sort: "desc", Limit: 10})
+	if err != nil || len(mentions) != 1 || mentions[0].ID != chirp.ID {
+		t.Errorf("ListChirpsPage() mentions = %v, %v, want the chirp mentioning alice", mentions, err)
+	}
+
+	tags, err := db.TrendingTags(time.Now().Add(-time.Hour), 10)
+	if want := []TagCount{{Tag: "rust", Count: 2}, {Tag: "go", Count: 1}}; err != nil || !reflect.DeepEqual(tags, want) {
+		t.Errorf("TrendingTags() = %v, %v, want %v", tags, err, want)
+	}
+	if tags, _ := db.TrendingTags(time.Now().Add(time.Hour), 10); len(tags) != 0 {
+		t.Errorf("TrendingTags() in the future = %v, want none", tags)
+	}
+
+	if edited, err := db.UpdateChirp(chirp.ID, "no more tags"); err != nil || edited.Entities != nil {
+		t.Errorf("UpdateChirp() entities = %v, %v, want none", edited.Entities, err)
+	}
+}
+
+func TestDB_Notifications(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	var users []User
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		user, err := db.CreateUser(email, "hash")
+		if err != nil {
+			t.Fatalf("CreateUser should not have an error %v", err)
+		}
+		users = append(users, user)
+	}
+	a, b := users[0].ID, users[1].ID
+	if _, err := db.UpdateUserProfile(a, "alice", "", ""); err != nil {
+		t.Fatalf("UpdateUserProfile should not have an error %v", err)
+	}
+
+	chirp, err := db.CreateChirp(Chirp{Body: "mine", AuthorID: a})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	// a reply mentioning its parent author only notifies the reply.
+	reply, err := db.CreateChirp(Chirp{Body: "@alice hi", AuthorID: b, ReplyToID: chirp.ID})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := db.UnlikeChirp(b, chirp.ID); err != nil {
+			t.Fatalf("UnlikeChirp should not have an error %v", err)
+		}
+		if _, _, err := db.LikeChirp(b, chirp.ID); err != nil {
+			t.Fatalf("LikeChirp should not have an error %v", err)
+		}
+	}
+	if _, _, err := db.LikeChirp(a, chirp.ID); err != nil {
+		t.Fatalf("LikeChirp should not have an error %v", err)
+	}
+	if _, err := db.SetMutedNotifications(a, []string{NotificationFollow}); err != nil {
+		t.Fatalf("SetMutedNotifications should not have an error %v", err)
+	}
+	if _, _, err := db.FollowUser(b, a); err != nil {
+		t.Fatalf("FollowUser should not have an error %v", err)
+	}
+
+	notifications, _, err := db.ListNotifications(a, 0, 10, false)
+	if err != nil || len(notifications) != 2 ||
+		notifications[0].Type != NotificationLike || notifications[1].Type != NotificationReply || notifications[1].ChirpID != reply.ID {
+		t.Fatalf("ListNotifications() = %v, %v, want a like then a reply", notifications, err)
+	}
+
+	if marked, err := db.MarkNotificationsRead(a, []int{notifications[0].ID}); err != nil || marked != 1 {
+		t.Errorf("MarkNotificationsRead() = %v, %v, want one marked", marked, err)
+	}
+	if count, _ := db.CountUnreadNotifications(a); count != 1 {
+		t.Errorf("CountUnreadNotifications() = %v, want 1", count)
+	}
+
+	if _, _, err := db.DeleteChirp(reply.ID); err != nil {
+		t.Fatalf("DeleteChirp should not have an error %v", err)
+	}
+	if count, _ := db.CountUnreadNotifications(a); count != 0 {
+		t.Errorf("CountUnreadNotifications() after deleting the reply = %v, want 0", count)
+	}
+
+	if _, err := db.SetMutedNotifications(a, []string{"unknown"}); !errors.Is(err, ErrInvalidNotificationType) {
+		t.Errorf("SetMutedNotifications() error = %v, want %v", err, ErrInvalidNotificationType)
+	}
+}
+
+func TestDB_Moderation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	var users []User
+	for _, email := range []string{"a@example.com", "b@example.com", "admin@example.com"} {
+		user, err := db.CreateUser(email, "hash")
+		if err != nil {
+			t.Fatalf("CreateUser should not have an error %v", err)
+		}
+		users = append(users, user)
+	}
+	a, b, admin := users[0].ID, users[1].ID, users[2].ID
+	if err := db.GrantRole("admin@example.com", "admin"); err != nil {
+		t.Fatalf("GrantRole should not have an error %v", err)
+	}
+
+	chirp, err := db.CreateChirp(Chirp{Body: "buy now", AuthorID: a})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	if _, err := db.CreateReport(Report{ReporterID: a, ChirpID: chirp.ID, Reason: ReasonSpam}); !errors.Is(err, ErrInvalidReport) {
+		t.Errorf("expected a self report to be invalid, got %v", err)
+	}
+	report, err := db.CreateReport(Report{ReporterID: b, ChirpID: chirp.ID, Reason: ReasonSpam})
+	if err != nil {
+		t.Fatalf("CreateReport should not have an error %v", err)
+	}
+	if report.UserID != a {
+		t.Errorf("expected the report to target the author %d, got %d", a, report.UserID)
+	}
+	if _, err := db.CreateReport(Report{ReporterID: b, ChirpID: chirp.ID, Reason: ReasonOther}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected a duplicate open report to be rejected, got %v", err)
+	}
+
+	// the chirp and the user of a decision default to the ones of its report.
+	if _, err := db.Decide(Decision{AdminID: admin, Action: ActionHideChirp, ReportIDs: []int{report.ID}}); err != nil {
+		t.Fatalf("Decide should not have an error %v", err)
+	}
+	if chirps, _ := db.ListChirps(-1, 0, "asc"); len(chirps) != 0 {
+		t.Errorf("expected the hidden chirp not to be listed, got %v", chirps)
+	}
+	if open, _, _ := db.ListReports(ReportOpen, 0, 10); len(open) != 0 {
+		t.Errorf("expected the report to be resolved, got %v", open)
+	}
+
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	suspension, err := db.Decide(Decision{AdminID: admin, Action: ActionSuspend, UserID: a, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatalf("Decide should not have an error %v", err)
+	}
+	if _, err := db.CreateChirp(Chirp{Body: "still here", AuthorID: a}); !errors.Is(err, ErrSuspended) {
+		t.Errorf("expected a suspended user not to chirp, got %v", err)
+	}
+	if _, err := db.AppealSuspension(a, "sorry"); err != nil {
+		t.Fatalf("AppealSuspension should not have an error %v", err)
+	}
+	if _, err := db.AppealSuspension(a, "again"); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected a second appeal to be rejected, got %v", err)
+	}
+	if _, err := db.Decide(Decision{AdminID: admin, Action: ActionGrantAppeal, UserID: a}); err != nil {
+		t.Fatalf("Decide should not have an error %v", err)
+	}
+
+	// the moderation state survives a reload.
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	user, err := db.GetUser(a)
+	if err != nil {
+		t.Fatalf("GetUser should not have an error %v", err)
+	}
+	if user.IsSuspended() || user.Suspension.DecisionID != suspension.ID || user.Suspension.Appeal.Status != AppealGranted {
+		t.Errorf("expected a lifted suspension with a granted appeal, got %+v", user.Suspension)
+	}
+	if adminUser, _ := db.GetUser(admin); !slices.Contains(adminUser.Roles, "admin") {
+		t.Errorf("expected the admin role, got %v", adminUser.Roles)
+	}
+	if decisions, _, _ := db.ListDecisions(0, 10); len(decisions) != 3 || decisions[0].Action != ActionGrantAppeal {
+		t.Errorf("expected 3 decisions, the most recent first, got %v", decisions)
+	}
+}
+
+func TestDB_BlocksAndMutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	var users []User
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		user, err := db.CreateUser(email, "hash")
+		if err != nil {
+			t.Fatalf("CreateUser should not have an error %v", err)
+		}
+		users = append(users, user)
+	}
+	a, b, c := users[0].ID, users[1].ID, users[2].ID
+	if _, _, err := db.FollowUser(b, a); err != nil {
+		t.Fatalf("FollowUser should not have an error %v", err)
+	}
+	chirpA, err := db.CreateChirp(Chirp{Body: "from a", AuthorID: a})
+	if err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	if _, err := db.CreateChirp(Chirp{Body: "from b", AuthorID: b}); err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	if _, err := db.CreateChirp(Chirp{Body: "from c", AuthorID: c}); err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+
+	if _, _, err := db.BlockUser(a, a); !errors.Is(err, ErrSelfBlock) {
+		t.Errorf("expected a self block to be rejected, got %v", err)
+	}
+	if _, created, err := db.BlockUser(a, b); err != nil || !created {
+		t.Fatalf("BlockUser = %v, %v, want a new block", created, err)
+	}
+	if followed, _ := db.ListFollowedIDs(b); len(followed) != 0 {
+		t.Errorf("expected the block to remove the follows, got %v", followed)
+	}
+	if _, _, err := db.FollowUser(b, a); !errors.Is(err, ErrBlocked) {
+		t.Errorf("expected the blocked user not to follow the blocker, got %v", err)
+	}
+	if _, err := db.CreateChirp(Chirp{Body: "reply", AuthorID: b, ReplyToID: chirpA.ID}); !errors.Is(err, ErrInvalidReply) {
+		t.Errorf("expected the blocked user not to reply to the blocker, got %v", err)
+	}
+	if _, _, err := db.MuteUser(a, c); err != nil {
+		t.Fatalf("MuteUser should not have an error %v", err)
+	}
+
+	authors := func(viewerID int) []int {
+		chirps, err := db.ListChirps(-1, viewerID, "asc")
+		if err != nil {
+			t.Fatalf("ListChirps should not have an error %v", err)
+		}
+		var ids []int
+		for _, chirp := range chirps {
+			ids = append(ids, chirp.AuthorID)
+		}
+		return ids
+	}
+	if got := authors(a); !slices.Equal(got, []int{a}) {
+		t.Errorf("ListChirps(a) authors = %v, want [%d]", got, a)
+	}
+	if got := authors(b); !slices.Equal(got, []int{b, c}) {
+		t.Errorf("ListChirps(b) authors = %v, want [%d %d]", got, b, c)
+	}
+	// the muted user does not know about the mute.
+	if got := authors(c); !slices.Equal(got, []int{a, b, c}) {
+		t.Errorf("ListChirps(c) authors = %v, want all", got)
+	}
+
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	if blocked, _ := db.IsBlocked(b, a); !blocked {
+		t.Errorf("expected the block to be saved")
+	}
+	if hidden, _ := db.ListHiddenAuthorIDs(a); !slices.Equal(hidden, []int{b, c}) {
+		t.Errorf("ListHiddenAuthorIDs(a) = %v, want [%d %d]", hidden, b, c)
+	}
+	if deleted, err := db.UnblockUser(a, b); err != nil || !deleted {
+		t.Errorf("UnblockUser = %v, %v, want the block removed", deleted, err)
+	}
+	if _, _, err := db.FollowUser(b, a); err != nil {
+		t.Errorf("expected the follow to be allowed after the unblock, got %v", err)
+	}
+}
+
+func TestDB_SubscriptionsAreNotShared(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	user, err := db.CreateUser("red@example.com", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+	periodEnd := time.Now().UTC().Add(time.Hour)
+	if err := db.ApplySubscriptionEvent(user.ID, EventUpgraded, periodEnd); err != nil {
+		t.Fatalf("ApplySubscriptionEvent should not have an error %v", err)
+	}
+
+	before, err := db.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser should not have an error %v", err)
+	}
+	if expired, err := db.ExpireSubscriptions(periodEnd.Add(time.Minute)); err != nil || expired != 1 {
+		t.Fatalf("ExpireSubscriptions() = %d, %v, want 1", expired, err)
+	}
+	if err := db.ApplySubscriptionEvent(user.ID, EventUpgraded, time.Time{}); err != nil {
+		t.Fatalf("ApplySubscriptionEvent should not have an error %v", err)
+	}
+
+	// the user read before keeps the subscription it was read with.
+	if before.Subscription.Status != SubscriptionActive || len(before.Subscription.History) != 1 {
+		t.Errorf("the subscription read before was modified: %+v", before.Subscription)
+	}
+	after, _ := db.GetUser(user.ID)
+	if len(after.Subscription.History) != 3 {
+		t.Errorf("expected 3 subscription events, got %+v", after.Subscription.History)
+	}
+	after.Subscription.Status = SubscriptionCanceled
+	if stored, _ := db.GetUser(user.ID); stored.Subscription.Status != SubscriptionActive {
+		t.Errorf("modifying a returned user changed the stored subscription")
+	}
+}
+
+func TestDB_LegacyChirpyRed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "database.json")
+	// a user flagged before subscriptions existed, and one migrated without period end.
+	content := `{"users":{
+		"legacy@example.com":{"id":1,"email":"legacy@example.com","is_chirpy_red":true},
+		"migrated@example.com":{"id":2,"email":"migrated@example.com","subscription":{"status":"active","history":[]}}
+	}}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().UTC()
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	var periodEnds []time.Time
+	for _, id := range []int{1, 2} {
+		user, err := db.GetUser(id)
+		if err != nil {
+			t.Fatalf("GetUser should not have an error %v", err)
+		}
+		sub := user.Subscription
+		if sub == nil || sub.PeriodEnd.Before(before.Add(SubscriptionPeriod)) || sub.History[len(sub.History)-1].Event != EventMigrated {
+			t.Fatalf("GetUser(%d) subscription = %+v, want a migrated subscription of a period", id, sub)
+		}
+		periodEnds = append(periodEnds, sub.PeriodEnd)
+	}
+
+	// the period end is kept across restarts, and the subscriptions lapse as any other.
+	db, err = NewDB(path)
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	for i, id := range []int{1, 2} {
+		if user, _ := db.GetUser(id); !user.Subscription.PeriodEnd.Equal(periodEnds[i]) || len(user.Subscription.History) != 1 {
+			t.Errorf("GetUser(%d) subscription = %+v, want the period end %v", id, user.Subscription, periodEnds[i])
+		}
+	}
+	if expired, err := db.ExpireSubscriptions(periodEnds[1].Add(time.Minute)); err != nil || expired != 2 {
+		t.Errorf("ExpireSubscriptions() = %d, %v, want 2", expired, err)
+	}
+}
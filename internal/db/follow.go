@@ -0,0 +1,147 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ErrSelfFollow is returned when a user tries to follow themselves.
+var ErrSelfFollow = errors.New("users cannot follow themselves")
+
+// Follow is a user following another one.
+type Follow struct {
+	ID         int       `json:"id"`
+	FollowerID int       `json:"follower_id"`
+	FolloweeID int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FollowUser records that a user follows another one and saves it to disk.
+// Following a user twice returns the existing follow, created reports whether the follow is new.
+func (db *DB) FollowUser(followerID, followeeID int) (follow Follow, created bool, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if followerID == followeeID {
+		return Follow{}, false, ErrSelfFollow
+	}
+	if _, err := db.getUser(followeeID); err != nil {
+		return Follow{}, false, err
+	}
+	if db.blocked(followerID, followeeID) {
+		return Follow{}, false, ErrBlocked
+	}
+	if existing, ok := db.findFollow(followerID, followeeID); ok {
+		return existing, false, nil
+	}
+
+	follow = Follow{ID: db.nextID(seqFollows), FollowerID: followerID, FolloweeID: followeeID, CreatedAt: time.Now().UTC()}
+	db.data.Follows[follow.ID] = follow
+	db.notify(Notification{UserID: followeeID, Type: NotificationFollow, ActorID: followerID})
+	if err := db.writeDB(db.data); err != nil {
+		return Follow{}, false, fmt.Errorf("write db: %w", err)
+	}
+
+	return follow, true, nil
+}
+
+// UnfollowUser removes the follow of a user on another one and saves it to disk.
+// deleted reports whether there was a follow to remove.
+func (db *DB) UnfollowUser(followerID, followeeID int) (deleted bool, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, err := db.getUser(followeeID); err != nil {
+		return false, err
+	}
+	follow, ok := db.findFollow(followerID, followeeID)
+	if !ok {
+		return false, nil
+	}
+
+	delete(db.data.Follows, follow.ID)
+	if err := db.writeDB(db.data); err != nil {
+		return false, fmt.Errorf("write db: %w", err)
+	}
+
+	return true, nil
+}
+
+// findFollow returns the follow of a user on another one.
+// The caller must hold the lock.
+func (db *DB) findFollow(followerID, followeeID int) (Follow, bool) {
+	for _, follow := range db.data.Follows {
+		if follow.FollowerID == followerID && follow.FolloweeID == followeeID {
+			return follow, true
+		}
+	}
+	return Follow{}, false
+}
+
+// ListFollowedIDs returns the ids of the users followed by a user.
+func (db *DB) ListFollowedIDs(userID int) ([]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var ids []int
+	for _, follow := range db.data.Follows {
+		if follow.FollowerID == userID {
+			ids = append(ids, follow.FolloweeID)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// ListFollowerIDs returns the ids of the users following a user.
+func (db *DB) ListFollowerIDs(userID int) ([]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var ids []int
+	for _, follow := range db.data.Follows {
+		if follow.FolloweeID == userID {
+			ids = append(ids, follow.FollowerID)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids, nil
+}
+
+// ListFollowers returns a page of the follows on a user, the most recent first,
+// and whether more follows follow. beforeID is the exclusive upper bound of the follow ids, 0 if not set.
+func (db *DB) ListFollowers(userID, beforeID, limit int) ([]Follow, bool, error) {
+	return db.listFollows(userID, beforeID, limit, func(follow Follow) bool { return follow.FolloweeID == userID })
+}
+
+// ListFollowing returns a page of the follows of a user, the most recent first,
+// and whether more follows follow. beforeID is the exclusive upper bound of the follow ids, 0 if not set.
+func (db *DB) ListFollowing(userID, beforeID, limit int) ([]Follow, bool, error) {
+	return db.listFollows(userID, beforeID, limit, func(follow Follow) bool { return follow.FollowerID == userID })
+}
+
+func (db *DB) listFollows(userID, beforeID, limit int, match func(Follow) bool) ([]Follow, bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	if _, err := db.getUser(userID); err != nil {
+		return nil, false, err
+	}
+
+	var follows []Follow
+	for _, follow := range db.data.Follows {
+		if match(follow) && (beforeID == 0 || follow.ID < beforeID) {
+			follows = append(follows, follow)
+		}
+	}
+	slices.SortFunc(follows, func(i, j Follow) int { return j.ID - i.ID })
+
+	if len(follows) > limit {
+		return follows[:limit], true, nil
+	}
+	return follows, false, nil
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Like is a user liking a chirp.
+type Like struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	ChirpID   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LikeChirp records that a user likes a chirp and saves it to disk.
+// Liking a chirp twice returns the existing like, created reports whether the like is new.
+// The hidden chirps cannot be liked.
+func (db *DB) LikeChirp(userID, chirpID int) (like Like, created bool, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	chirp, ok := db.data.Chirps[chirpID]
+	if !ok || chirp.IsHidden() || db.blocked(userID, chirp.AuthorID) {
+		return Like{}, false, ErrNotFound
+	}
+	if existing, ok := db.findLike(userID, chirpID); ok {
+		return existing, false, nil
+	}
+
+	like = Like{ID: db.nextID(seqLikes), UserID: userID, ChirpID: chirpID, CreatedAt: time.Now().UTC()}
+	db.data.Likes[like.ID] = like
+	db.notify(Notification{UserID: chirp.AuthorID, Type: NotificationLike, ActorID: userID, ChirpID: chirpID})
+	if err := db.writeDB(db.data); err != nil {
+		return Like{}, false, fmt.Errorf("write db: %w", err)
+	}
+
+	return like, true, nil
+}
+
+// UnlikeChirp removes the like of a user on a chirp and saves it to disk.
+// deleted reports whether there was a like to remove.
+func (db *DB) UnlikeChirp(userID, chirpID int) (deleted bool, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, ok := db.data.Chirps[chirpID]; !ok {
+		return false, ErrNotFound
+	}
+	like, ok := db.findLike(userID, chirpID)
+	if !ok {
+		return false, nil
+	}
+
+	delete(db.data.Likes, like.ID)
+	if err := db.writeDB(db.data); err != nil {
+		return false, fmt.Errorf("write db: %w", err)
+	}
+
+	return true, nil
+}
+
+// findLike returns the like of a user on a chirp.
+// The caller must hold the lock.
+func (db *DB) findLike(userID, chirpID int) (Like, bool) {
+	for _, like := range db.data.Likes {
+		if like.UserID == userID && like.ChirpID == chirpID {
+			return like, true
+		}
+	}
+	return Like{}, false
+}
+
+// CountLikes returns the number of likes of each of the given chirps.
+func (db *DB) CountLikes(chirpIDs []int) (map[int]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	counts := make(map[int]int, len(chirpIDs))
+	for _, id := range chirpIDs {
+		counts[id] = 0
+	}
+	for _, like := range db.data.Likes {
+		if _, ok := counts[like.ChirpID]; ok {
+			counts[like.ChirpID]++
+		}
+	}
+
+	return counts, nil
+}
+
+// ListLikedChirps reports which of the given chirps the user likes.
+func (db *DB) ListLikedChirps(userID int, chirpIDs []int) (map[int]bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	liked := make(map[int]bool, len(chirpIDs))
+	for _, id := range chirpIDs {
+		liked[id] = false
+	}
+	for _, like := range db.data.Likes {
+		if _, ok := liked[like.ChirpID]; ok && like.UserID == userID {
+			liked[like.ChirpID] = true
+		}
+	}
+
+	return liked, nil
+}
+
+// ListLikes returns a page of the likes of a chirp, the most recent first,
+// and whether more likes follow. beforeID is the exclusive upper bound of the like ids, 0 if not set.
+func (db *DB) ListLikes(chirpID, beforeID, limit int) ([]Like, bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	if _, ok := db.data.Chirps[chirpID]; !ok {
+		return nil, false, ErrNotFound
+	}
+
+	var likes []Like
+	for _, like := range db.data.Likes {
+		if like.ChirpID == chirpID && (beforeID == 0 || like.ID < beforeID) {
+			likes = append(likes, like)
+		}
+	}
+	slices.SortFunc(likes, func(i, j Like) int { return j.ID - i.ID })
+
+	if len(likes) > limit {
+		return likes[:limit], true, nil
+	}
+	return likes, false, nil
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Media kinds.
+const (
+	MediaAvatar = "avatar"
+	MediaChirp  = "chirp"
+)
+
+// Media is an uploaded image. Its content lives in the blob store.
+type Media struct {
+	ID           int    `json:"id"`
+	OwnerID      int    `json:"owner_id"`
+	Kind         string `json:"kind"`
+	Key          string `json:"key"`
+	ThumbnailKey string `json:"thumbnail_key"`
+	ContentType  string `json:"content_type"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	Size         int    `json:"size"`
+	Blurhash     string `json:"blurhash,omitempty"`
+	// ChirpID is the chirp the media is attached to, 0 if none.
+	ChirpID   int       `json:"chirp_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MediaURL returns the path under which a media is served.
+func MediaURL(id int) string {
+	return fmt.Sprintf("/api/media/%d", id)
+}
+
+// MediaThumbnailURL returns the path under which the thumbnail of a media is served.
+func MediaThumbnailURL(id int) string {
+	return MediaURL(id) + "/thumbnail"
+}
+
+// CreateMedia stores the metadata of an uploaded media and saves it to disk.
+func (db *DB) CreateMedia(media Media) (Media, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	media.ID = db.nextID(seqMedia)
+	media.CreatedAt = time.Now().UTC()
+	db.data.Media[media.ID] = media
+	if err := db.writeDB(db.data); err != nil {
+		return Media{}, fmt.Errorf("write db: %w", err)
+	}
+
+	return media, nil
+}
+
+// GetMedia returns a single media.
+func (db *DB) GetMedia(id int) (*Media, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	media, ok := db.data.Media[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &media, nil
+}
+
+// ListMedia returns the media uploaded by a user, sorted by id.
+func (db *DB) ListMedia(ownerID int) ([]Media, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	media := []Media{}
+	for _, m := range db.data.Media {
+		if m.OwnerID == ownerID {
+			media = append(media, m)
+		}
+	}
+
+	slices.SortFunc(media, func(i, j Media) int {
+		return i.ID - j.ID
+	})
+
+	return media, nil
+}
+
+// DeleteMedia deletes the metadata of a media and saves it to disk.
+func (db *DB) DeleteMedia(id int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, ok := db.data.Media[id]; !ok {
+		return ErrNotFound
+	}
+
+	delete(db.data.Media, id)
+	if err := db.writeDB(db.data); err != nil {
+		return fmt.Errorf("write db: %w", err)
+	}
+
+	return nil
+}
+
+// SetUserAvatar sets the avatar of a user and saves it to disk.
+// It returns the id of the previous avatar, 0 if none.
+func (db *DB) SetUserAvatar(userID, mediaID int) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	for email, user := range db.data.Users {
+		if user.ID == userID {
+			previous := user.AvatarID
+			user.AvatarID = mediaID
+			db.data.Users[email] = user
+			if err := db.writeDB(db.data); err != nil {
+				return 0, fmt.Errorf("write db: %w", err)
+			}
+			return previous, nil
+		}
+	}
+
+	return 0, ErrNotFound
+}
@@ -0,0 +1,427 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Report reasons.
+const (
+	ReasonSpam          = "spam"
+	ReasonHarassment    = "harassment"
+	ReasonHate          = "hate"
+	ReasonViolence      = "violence"
+	ReasonSexual        = "sexual"
+	ReasonImpersonation = "impersonation"
+	ReasonOther         = "other"
+)
+
+// ReportReasons are the reasons a chirp or a user can be reported for.
+var ReportReasons = []string{
+	ReasonSpam,
+	ReasonHarassment,
+	ReasonHate,
+	ReasonViolence,
+	ReasonSexual,
+	ReasonImpersonation,
+	ReasonOther,
+}
+
+// Report statuses.
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Moderation actions.
+const (
+	ActionDismiss     = "dismiss"
+	ActionHideChirp   = "hide_chirp"
+	ActionUnhideChirp = "unhide_chirp"
+	ActionSuspend     = "suspend"
+	ActionUnsuspend   = "unsuspend"
+	ActionGrantAppeal = "grant_appeal"
+	ActionDenyAppeal  = "deny_appeal"
+)
+
+// ModerationActions are the actions an admin can decide.
+var ModerationActions = []string{
+	ActionDismiss,
+	ActionHideChirp,
+	ActionUnhideChirp,
+	ActionSuspend,
+	ActionUnsuspend,
+	ActionGrantAppeal,
+	ActionDenyAppeal,
+}
+
+// Appeal statuses.
+const (
+	AppealPending = "pending"
+	AppealGranted = "granted"
+	AppealDenied  = "denied"
+)
+
+var (
+	ErrInvalidReport   = errors.New("invalid report")
+	ErrInvalidDecision = errors.New("invalid decision")
+	// ErrSuspended is returned when a suspended user tries to chirp.
+	ErrSuspended = errors.New("account suspended")
+)
+
+// Report flags a chirp or a user to the moderators.
+type Report struct {
+	ID         int `json:"id"`
+	ReporterID int `json:"reporter_id"`
+	// ChirpID is the reported chirp, 0 when a user is reported.
+	ChirpID int `json:"chirp_id,omitempty"`
+	// UserID is the reported user, the author of the reported chirp.
+	UserID    int       `json:"user_id"`
+	Reason    string    `json:"reason"`
+	Comment   string    `json:"comment,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	// DecisionID is the decision that resolved the report, 0 while it is open.
+	DecisionID int `json:"decision_id,omitempty"`
+}
+
+// Decision records a moderation action taken by an admin.
+type Decision struct {
+	ID      int    `json:"id"`
+	AdminID int    `json:"admin_id"`
+	Action  string `json:"action"`
+	// ReportIDs are the reports resolved by the decision.
+	ReportIDs []int  `json:"report_ids,omitempty"`
+	ChirpID   int    `json:"chirp_id,omitempty"`
+	UserID    int    `json:"user_id,omitempty"`
+	Note      string `json:"note,omitempty"`
+	// ExpiresAt is the end of a suspension, nil for a permanent one.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Suspension prevents a user from using the API, except to see and appeal it.
+type Suspension struct {
+	DecisionID int       `json:"decision_id"`
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	// ExpiresAt is nil for a permanent suspension.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Appeal    *Appeal    `json:"appeal,omitempty"`
+}
+
+// Appeal is the request of a suspended user to lift their suspension.
+type Appeal struct {
+	Message   string     `json:"message"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"created_at"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// IsActive reports whether the suspension is in effect at the given time.
+func (s *Suspension) IsActive(now time.Time) bool {
+	return s != nil && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt))
+}
+
+// IsSuspended reports whether the user is currently suspended.
+func (u User) IsSuspended() bool {
+	return u.Suspension.IsActive(time.Now().UTC())
+}
+
+// IsHidden reports whether the chirp has been hidden by a moderator.
+func (c Chirp) IsHidden() bool {
+	return c.HiddenAt != nil
+}
+
+// CreateReport reports a chirp, or a user when ChirpID is 0, and saves it to disk.
+// Only the reporter, the chirp or the user, the reason and the comment of the report are read.
+// The user of a chirp report is its author.
+// A reporter cannot report the same chirp or user twice while the first report is open.
+func (db *DB) CreateReport(params Report) (Report, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if !slices.Contains(ReportReasons, params.Reason) {
+		return Report{}, fmt.Errorf("%w: unknown reason %q", ErrInvalidReport, params.Reason)
+	}
+
+	userID := params.UserID
+	if params.ChirpID != 0 {
+		chirp, ok := db.data.Chirps[params.ChirpID]
+		if !ok {
+			return Report{}, fmt.Errorf("chirp %w", ErrNotFound)
+		}
+		userID = chirp.AuthorID
+	} else if _, err := db.getUser(userID); err != nil {
+		return Report{}, fmt.Errorf("user %w", err)
+	}
+	if userID == params.ReporterID {
+		return Report{}, fmt.Errorf("%w: cannot report yourself", ErrInvalidReport)
+	}
+
+	for _, report := range db.data.Reports {
+		if report.ReporterID == params.ReporterID && report.Status == ReportOpen &&
+			report.ChirpID == params.ChirpID && report.UserID == userID {
+			return Report{}, fmt.Errorf("report %w", ErrAlreadyExists)
+		}
+	}
+
+	report := Report{
+		ID:         db.nextID(seqReports),
+		ReporterID: params.ReporterID,
+		ChirpID:    params.ChirpID,
+		UserID:     userID,
+		Reason:     params.Reason,
+		Comment:    params.Comment,
+		Status:     ReportOpen,
+		CreatedAt:  time.Now().UTC(),
+	}
+	db.data.Reports[report.ID] = report
+	if err := db.writeDB(db.data); err != nil {
+		return Report{}, fmt.Errorf("write db: %w", err)
+	}
+
+	return report, nil
+}
+
+// GetReport returns a single report.
+func (db *DB) GetReport(id int) (*Report, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	report, ok := db.data.Reports[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &report, nil
+}
+
+// ListReports returns the reports with the given status, all of them when empty,
+// the oldest first, after the report afterID, and whether more reports follow.
+func (db *DB) ListReports(status string, afterID, limit int) ([]Report, bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var reports []Report
+	for _, report := range db.data.Reports {
+		if (status != "" && report.Status != status) || report.ID <= afterID {
+			continue
+		}
+		reports = append(reports, report)
+	}
+	slices.SortFunc(reports, func(i, j Report) int { return i.ID - j.ID })
+
+	if len(reports) > limit {
+		return reports[:limit], true, nil
+	}
+	return reports, false, nil
+}
+
+// ListDecisions returns the decisions, the most recent first, before the decision beforeID,
+// and whether more decisions follow.
+func (db *DB) ListDecisions(beforeID, limit int) ([]Decision, bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var decisions []Decision
+	for _, decision := range db.data.Decisions {
+		if beforeID != 0 && decision.ID >= beforeID {
+			continue
+		}
+		decisions = append(decisions, decision)
+	}
+	slices.SortFunc(decisions, func(i, j Decision) int { return j.ID - i.ID })
+
+	if len(decisions) > limit {
+		return decisions[:limit], true, nil
+	}
+	return decisions, false, nil
+}
+
+// Decide applies a moderation action, resolves its reports and saves the decision to disk.
+// The chirp and the user of the decision default to the ones of its first report.
+// Hiding a chirp needs a chirp, the other actions but dismiss need a user.
+// A suspension without ExpiresAt is permanent.
+func (db *DB) Decide(params Decision) (Decision, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if !slices.Contains(ModerationActions, params.Action) {
+		return Decision{}, fmt.Errorf("%w: unknown action %q", ErrInvalidDecision, params.Action)
+	}
+
+	reportIDs := slices.Clone(params.ReportIDs)
+	slices.Sort(reportIDs)
+	reportIDs = slices.Compact(reportIDs)
+	for _, id := range reportIDs {
+		report, ok := db.data.Reports[id]
+		if !ok {
+			return Decision{}, fmt.Errorf("report %d %w", id, ErrNotFound)
+		}
+		if report.Status != ReportOpen {
+			return Decision{}, fmt.Errorf("%w: report %d is already resolved", ErrInvalidDecision, id)
+		}
+	}
+
+	chirpID, userID := params.ChirpID, params.UserID
+	if chirpID == 0 && userID == 0 && len(reportIDs) > 0 {
+		first := db.data.Reports[reportIDs[0]]
+		chirpID, userID = first.ChirpID, first.UserID
+	}
+	if params.Action == ActionDismiss && len(reportIDs) == 0 {
+		return Decision{}, fmt.Errorf("%w: nothing to dismiss", ErrInvalidDecision)
+	}
+
+	now := time.Now().UTC()
+	decision := Decision{
+		ID:        db.nextID(seqDecisions),
+		AdminID:   params.AdminID,
+		Action:    params.Action,
+		ReportIDs: reportIDs,
+		ChirpID:   chirpID,
+		UserID:    userID,
+		Note:      params.Note,
+		CreatedAt: now,
+	}
+
+	switch params.Action {
+	case ActionHideChirp, ActionUnhideChirp:
+		chirp, ok := db.data.Chirps[chirpID]
+		if !ok {
+			return Decision{}, fmt.Errorf("chirp %w", ErrNotFound)
+		}
+		chirp.HiddenAt = nil
+		if params.Action == ActionHideChirp {
+			chirp.HiddenAt = &now
+		}
+		db.data.Chirps[chirp.ID] = chirp
+		decision.UserID = chirp.AuthorID
+	case ActionSuspend, ActionUnsuspend, ActionGrantAppeal, ActionDenyAppeal:
+		email, user, ok := db.userByID(userID)
+		if !ok {
+			return Decision{}, fmt.Errorf("user %w", ErrNotFound)
+		}
+		if err := applySuspension(&user, params, &decision, now); err != nil {
+			return Decision{}, err
+		}
+		db.data.Users[email] = user
+	}
+
+	for _, id := range reportIDs {
+		report := db.data.Reports[id]
+		report.Status = ReportResolved
+		report.DecisionID = decision.ID
+		db.data.Reports[id] = report
+	}
+	db.data.Decisions[decision.ID] = decision
+	if err := db.writeDB(db.data); err != nil {
+		return Decision{}, fmt.Errorf("write db: %w", err)
+	}
+
+	return decision, nil
+}
+
+// applySuspension applies a suspension action to a user.
+func applySuspension(user *User, params Decision, decision *Decision, now time.Time) error {
+	active := user.Suspension.IsActive(now)
+
+	switch params.Action {
+	case ActionSuspend:
+		if params.ExpiresAt != nil && !params.ExpiresAt.After(now) {
+			return fmt.Errorf("%w: the suspension must expire in the future", ErrInvalidDecision)
+		}
+		user.Suspension = &Suspension{
+			DecisionID: decision.ID,
+			Reason:     params.Note,
+			CreatedAt:  now,
+			ExpiresAt:  params.ExpiresAt,
+		}
+		decision.ExpiresAt = params.ExpiresAt
+	case ActionUnsuspend:
+		if !active {
+			return fmt.Errorf("%w: the user is not suspended", ErrInvalidDecision)
+		}
+		user.Suspension = nil
+	case ActionGrantAppeal, ActionDenyAppeal:
+		if !active || user.Suspension.Appeal == nil || user.Suspension.Appeal.Status != AppealPending {
+			return fmt.Errorf("%w: no pending appeal", ErrInvalidDecision)
+		}
+		suspension := *user.Suspension
+		appeal := *suspension.Appeal
+		appeal.DecidedAt = &now
+		appeal.Status = AppealDenied
+		if params.Action == ActionGrantAppeal {
+			appeal.Status = AppealGranted
+			// the suspension ends now, it is kept for the user to see the appeal outcome.
+			suspension.ExpiresAt = &now
+		}
+		suspension.Appeal = &appeal
+		user.Suspension = &suspension
+	}
+
+	return nil
+}
+
+// GrantRole adds a role to the user with the given email and saves it to disk.
+func (db *DB) GrantRole(email, role string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, ok := db.data.Users[email]
+	if !ok {
+		return fmt.Errorf("user %w", ErrNotFound)
+	}
+	if slices.Contains(user.Roles, role) {
+		return nil
+	}
+	user.Roles = append(user.Roles, role)
+	db.data.Users[email] = user
+	if err := db.writeDB(db.data); err != nil {
+		return fmt.Errorf("write db: %w", err)
+	}
+
+	return nil
+}
+
+// AppealSuspension records the appeal of a suspended user and saves it to disk.
+// A suspension can only be appealed once.
+func (db *DB) AppealSuspension(userID int, message string) (Suspension, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	email, user, ok := db.userByID(userID)
+	if !ok {
+		return Suspension{}, fmt.Errorf("user %w", ErrNotFound)
+	}
+	now := time.Now().UTC()
+	if !user.Suspension.IsActive(now) {
+		return Suspension{}, fmt.Errorf("suspension %w", ErrNotFound)
+	}
+	if user.Suspension.Appeal != nil {
+		return Suspension{}, fmt.Errorf("appeal %w", ErrAlreadyExists)
+	}
+
+	suspension := *user.Suspension
+	suspension.Appeal = &Appeal{Message: message, Status: AppealPending, CreatedAt: now}
+	user.Suspension = &suspension
+	db.data.Users[email] = user
+	if err := db.writeDB(db.data); err != nil {
+		return Suspension{}, fmt.Errorf("write db: %w", err)
+	}
+
+	return suspension, nil
+}
+
+// userByID returns a user and the email it is stored under. The caller must hold the lock.
+func (db *DB) userByID(id int) (string, User, bool) {
+	for email, user := range db.data.Users {
+		if user.ID == id {
+			return email, user, true
+		}
+	}
+
+	return "", User{}, false
+}
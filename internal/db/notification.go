@@ -0,0 +1,197 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jbdoumenjou/mygoserver/internal/entity"
+)
+
+const (
+	NotificationMention      = "mention"
+	NotificationReply        = "reply"
+	NotificationLike         = "like"
+	NotificationFollow       = "follow"
+	NotificationSubscription = "subscription"
+)
+
+// NotificationTypes are the types of the notifications, which users can mute.
+var NotificationTypes = []string{
+	NotificationMention,
+	NotificationReply,
+	NotificationLike,
+	NotificationFollow,
+	NotificationSubscription,
+}
+
+// ErrInvalidNotificationType is returned when muting an unknown notification type.
+var ErrInvalidNotificationType = errors.New("invalid notification type")
+
+// Notification tells a user that something happened to them.
+type Notification struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Type   string `json:"type"`
+	// ActorID is the user who caused the notification, 0 for the subscription changes.
+	ActorID int `json:"actor_id,omitempty"`
+	// ChirpID is the reply, the mentioning chirp or the liked chirp.
+	ChirpID int `json:"chirp_id,omitempty"`
+	// SubscriptionStatus is the new status of a subscription change.
+	SubscriptionStatus string     `json:"subscription_status,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	ReadAt             *time.Time `json:"read_at,omitempty"`
+}
+
+// notify records a notification, unless its user muted its type or caused it.
+// An unread notification of the same event is not repeated, when a chirp is liked again for instance.
+// The caller must hold the lock and save the database.
+func (db *DB) notify(notification Notification) {
+	if notification.ActorID == notification.UserID {
+		return
+	}
+	user, err := db.getUser(notification.UserID)
+	if err != nil || slices.Contains(user.MutedNotifications, notification.Type) {
+		return
+	}
+	for _, existing := range db.data.Notifications {
+		if existing.ReadAt == nil && existing.UserID == notification.UserID && existing.Type == notification.Type &&
+			existing.ActorID == notification.ActorID && existing.ChirpID == notification.ChirpID &&
+			existing.SubscriptionStatus == notification.SubscriptionStatus {
+			return
+		}
+	}
+
+	notification.ID = db.nextID(seqNotifications)
+	notification.CreatedAt = time.Now().UTC()
+	db.data.Notifications[notification.ID] = notification
+}
+
+// notifyChirp notifies the author of the replied chirp and the mentioned users of a new chirp.
+// The caller must hold the lock and save the database.
+func (db *DB) notifyChirp(chirp Chirp) {
+	notified := map[int]bool{}
+	if chirp.ReplyToID != 0 {
+		if parent, ok := db.data.Chirps[chirp.ReplyToID]; ok {
+			db.notify(Notification{UserID: parent.AuthorID, Type: NotificationReply, ActorID: chirp.AuthorID, ChirpID: chirp.ID})
+			notified[parent.AuthorID] = true
+		}
+	}
+	for _, e := range chirp.Entities {
+		if e.Type != entity.TypeMention || notified[e.UserID] {
+			continue
+		}
+		db.notify(Notification{UserID: e.UserID, Type: NotificationMention, ActorID: chirp.AuthorID, ChirpID: chirp.ID})
+		notified[e.UserID] = true
+	}
+}
+
+// deleteNotifications deletes the notifications matching a predicate.
+// The caller must hold the lock and save the database.
+func (db *DB) deleteNotifications(match func(Notification) bool) {
+	for id, notification := range db.data.Notifications {
+		if match(notification) {
+			delete(db.data.Notifications, id)
+		}
+	}
+}
+
+// ListNotifications returns a page of the notifications of a user, the most recent first,
+// and whether more notifications follow. beforeID is the exclusive upper bound of the notification ids, 0 if not set.
+func (db *DB) ListNotifications(userID, beforeID, limit int, unreadOnly bool) ([]Notification, bool, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var notifications []Notification
+	for _, notification := range db.data.Notifications {
+		if notification.UserID != userID || (beforeID != 0 && notification.ID >= beforeID) {
+			continue
+		}
+		if unreadOnly && notification.ReadAt != nil {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	slices.SortFunc(notifications, func(i, j Notification) int { return j.ID - i.ID })
+
+	if len(notifications) > limit {
+		return notifications[:limit], true, nil
+	}
+	return notifications, false, nil
+}
+
+// CountUnreadNotifications returns the number of unread notifications of a user.
+func (db *DB) CountUnreadNotifications(userID int) (int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	count := 0
+	for _, notification := range db.data.Notifications {
+		if notification.UserID == userID && notification.ReadAt == nil {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// MarkNotificationsRead marks notifications of a user as read and saves them to disk,
+// all of them when ids is nil. The ids of other users' notifications are ignored.
+// It returns the number of notifications marked.
+func (db *DB) MarkNotificationsRead(userID int, ids []int) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	now := time.Now().UTC()
+	marked := 0
+	for id, notification := range db.data.Notifications {
+		if notification.UserID != userID || notification.ReadAt != nil {
+			continue
+		}
+		if ids != nil && !slices.Contains(ids, id) {
+			continue
+		}
+		notification.ReadAt = &now
+		db.data.Notifications[id] = notification
+		marked++
+	}
+
+	if marked == 0 {
+		return 0, nil
+	}
+	if err := db.writeDB(db.data); err != nil {
+		return 0, fmt.Errorf("write db: %w", err)
+	}
+
+	return marked, nil
+}
+
+// SetMutedNotifications sets the notification types a user does not want to receive and saves it to disk.
+func (db *DB) SetMutedNotifications(userID int, types []string) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	for _, t := range types {
+		if !slices.Contains(NotificationTypes, t) {
+			return User{}, fmt.Errorf("%w: %q", ErrInvalidNotificationType, t)
+		}
+	}
+	muted := slices.Clone(types)
+	slices.Sort(muted)
+	muted = slices.Compact(muted)
+
+	for email, user := range db.data.Users {
+		if user.ID != userID {
+			continue
+		}
+		user.MutedNotifications = muted
+		db.data.Users[email] = user
+		if err := db.writeDB(db.data); err != nil {
+			return User{}, fmt.Errorf("write db: %w", err)
+		}
+		return user.clone(), nil
+	}
+
+	return User{}, ErrNotFound
+}
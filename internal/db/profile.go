@@ -0,0 +1,64 @@
+package db
+
+import "time"
+
+// PublicProfile is the part of a user visible to everyone.
+// It never contains the email.
+type PublicProfile struct {
+	ID          int        `json:"id"`
+	Handle      string     `json:"handle,omitempty"`
+	DisplayName string     `json:"display_name,omitempty"`
+	Bio         string     `json:"bio,omitempty"`
+	AvatarURL   string     `json:"avatar_url,omitempty"`
+	IsChirpyRed bool       `json:"is_chirpy_red"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+}
+
+// PublicProfile returns the public profile of the user.
+func (u User) PublicProfile() PublicProfile {
+	profile := PublicProfile{
+		ID:          u.ID,
+		Handle:      u.Handle,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		IsChirpyRed: u.IsChirpyRed(),
+	}
+	if u.AvatarID != 0 {
+		profile.AvatarURL = MediaURL(u.AvatarID)
+	}
+	// users created before profiles existed have no creation date.
+	if !u.CreatedAt.IsZero() {
+		createdAt := u.CreatedAt
+		profile.CreatedAt = &createdAt
+	}
+
+	return profile
+}
+
+// UpdateUserProfile updates the public profile of a user and saves it to disk.
+// Handles are unique, regardless of their case.
+func (db *DB) UpdateUserProfile(id int, handle, displayName, bio string) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, err := db.getUser(id)
+	if err != nil {
+		return User{}, err
+	}
+
+	return db.patchUser(*user, UserUpdate{Email: user.Email, Password: user.Password, Handle: handle, DisplayName: displayName, Bio: bio})
+}
+
+// GetUserByHandle returns a single user, the handle is case-insensitive.
+func (db *DB) GetUserByHandle(handle string) (*User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	user, ok := db.findUserByHandle(handle)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user = user.clone()
+
+	return &user, nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rechirp shares a chirp as a plain rechirp of the user and saves it to disk.
+// Rechirping a rechirp shares its original. Rechirping a chirp twice returns the existing rechirp,
+// created reports whether the rechirp is new.
+func (db *DB) Rechirp(userID, chirpID int) (rechirp Chirp, created bool, err error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if user, err := db.getUser(userID); err == nil && user.IsSuspended() {
+		return Chirp{}, false, ErrSuspended
+	}
+	original, ok := db.original(chirpID)
+	if !ok || db.blocked(userID, original.AuthorID) {
+		return Chirp{}, false, ErrNotFound
+	}
+	for _, chirp := range db.data.Chirps {
+		if chirp.AuthorID == userID && chirp.RechirpOfID == original.ID {
+			return chirp, false, nil
+		}
+	}
+
+	now := time.Now().UTC()
+	rechirp = Chirp{ID: db.nextID(seqChirps), AuthorID: userID, RechirpOfID: original.ID, CreatedAt: &now}
+	db.data.Chirps[rechirp.ID] = rechirp
+	if err := db.writeDB(db.data); err != nil {
+		return Chirp{}, false, fmt.Errorf("write db: %w", err)
+	}
+
+	return rechirp, true, nil
+}
+
+// original returns a chirp, or its original if it is a plain rechirp.
+// A hidden original is not found: it cannot be rechirped, replied to or quoted.
+// The caller must hold the lock.
+func (db *DB) original(id int) (Chirp, bool) {
+	chirp, ok := db.data.Chirps[id]
+	if ok && chirp.RechirpOfID != 0 {
+		chirp, ok = db.data.Chirps[chirp.RechirpOfID]
+	}
+	return chirp, ok && !chirp.IsHidden()
+}
+
+// isQuoted reports whether a chirp is quoted by another chirp.
+// The caller must hold the lock.
+func (db *DB) isQuoted(id int) bool {
+	for _, chirp := range db.data.Chirps {
+		if chirp.QuoteOfID == id {
+			return true
+		}
+	}
+	return false
+}
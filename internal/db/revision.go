@@ -0,0 +1,58 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// ChirpRevision is a previous version of an edited chirp.
+type ChirpRevision struct {
+	Body string `json:"body"`
+	// CreatedAt is when the version was published, nil for a chirp created before it was recorded.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	// ReplacedAt is when the version was replaced by an edit.
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// UpdateChirp replaces the body of a chirp, keeps the previous one as a revision and saves it to disk.
+func (db *DB) UpdateChirp(id int, body string) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	chirp, ok := db.data.Chirps[id]
+	if !ok {
+		return Chirp{}, ErrNotFound
+	}
+
+	now := time.Now().UTC()
+	revision := ChirpRevision{Body: chirp.Body, CreatedAt: chirp.CreatedAt, ReplacedAt: now}
+	if chirp.EditedAt != nil {
+		revision.CreatedAt = chirp.EditedAt
+	}
+	db.data.ChirpRevisions[id] = append(db.data.ChirpRevisions[id], revision)
+
+	chirp.Body = body
+	chirp.Entities = db.parseEntities(chirp.AuthorID, body)
+	chirp.EditedAt = &now
+	db.data.Chirps[id] = chirp
+	if err := db.writeDB(db.data); err != nil {
+		return Chirp{}, fmt.Errorf("write db: %w", err)
+	}
+
+	return chirp, nil
+}
+
+// ListChirpRevisions returns the previous versions of a chirp, the oldest first.
+func (db *DB) ListChirpRevisions(id int) ([]ChirpRevision, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	if _, ok := db.data.Chirps[id]; !ok {
+		return nil, ErrNotFound
+	}
+
+	revisions := make([]ChirpRevision, len(db.data.ChirpRevisions[id]))
+	copy(revisions, db.data.ChirpRevisions[id])
+
+	return revisions, nil
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Subscription statuses.
+const (
+	SubscriptionActive   = "active"
+	SubscriptionPastDue  = "past_due"
+	SubscriptionCanceled = "canceled"
+	SubscriptionExpired  = "expired"
+)
+
+// Subscription events sent by Polka.
+const (
+	EventUpgraded      = "user.upgraded"
+	EventRenewed       = "user.renewed"
+	EventPaymentFailed = "user.payment_failed"
+	EventDowngraded    = "user.downgraded"
+)
+
+// EventMigrated records the migration of a Chirpy Red flag stored before subscriptions existed.
+const EventMigrated = "legacy.migrated"
+
+const (
+	// SubscriptionPeriod is the billing period used when Polka does not send a period end.
+	SubscriptionPeriod = 30 * 24 * time.Hour
+	// PaymentGracePeriod is how long a past due subscription keeps its benefits.
+	PaymentGracePeriod = 3 * 24 * time.Hour
+)
+
+var ErrInvalidTransition = errors.New("invalid subscription transition")
+
+// Subscription is the Chirpy Red subscription of a user.
+type Subscription struct {
+	Status    string              `json:"status"`
+	PeriodEnd time.Time           `json:"period_end"`
+	History   []SubscriptionEvent `json:"history"`
+}
+
+// SubscriptionEvent is an entry of the subscription history.
+type SubscriptionEvent struct {
+	Event     string    `json:"event"`
+	Status    string    `json:"status"`
+	PeriodEnd time.Time `json:"period_end"`
+	At        time.Time `json:"at"`
+}
+
+// IsActive reports whether the subscription grants Chirpy Red at the given time.
+func (s *Subscription) IsActive(now time.Time) bool {
+	if s == nil {
+		return false
+	}
+
+	switch s.Status {
+	case SubscriptionActive:
+		return now.Before(s.PeriodEnd)
+	case SubscriptionPastDue:
+		return now.Before(s.PeriodEnd.Add(PaymentGracePeriod))
+	default:
+		return false
+	}
+}
+
+// Apply applies a Polka event to the subscription.
+// A zero periodEnd lets the subscription compute the next period end.
+func (s *Subscription) Apply(event string, now, periodEnd time.Time) error {
+	switch event {
+	case EventUpgraded:
+		if periodEnd.IsZero() {
+			periodEnd = now.Add(SubscriptionPeriod)
+		}
+		s.Status = SubscriptionActive
+		s.PeriodEnd = periodEnd
+	case EventRenewed:
+		if s.Status == "" || s.Status == SubscriptionCanceled {
+			return fmt.Errorf("%w: renew a %q subscription", ErrInvalidTransition, s.Status)
+		}
+		if periodEnd.IsZero() {
+			start := now
+			if s.PeriodEnd.After(now) {
+				start = s.PeriodEnd
+			}
+			periodEnd = start.Add(SubscriptionPeriod)
+		}
+		s.Status = SubscriptionActive
+		s.PeriodEnd = periodEnd
+	case EventPaymentFailed:
+		if s.Status != SubscriptionActive {
+			return fmt.Errorf("%w: payment failed on a %q subscription", ErrInvalidTransition, s.Status)
+		}
+		s.Status = SubscriptionPastDue
+	case EventDowngraded:
+		if s.Status == "" {
+			return fmt.Errorf("%w: downgrade without subscription", ErrInvalidTransition)
+		}
+		s.Status = SubscriptionCanceled
+		s.PeriodEnd = now
+	default:
+		return fmt.Errorf("%w: unknown event %q", ErrInvalidTransition, event)
+	}
+
+	s.record(event, now)
+
+	return nil
+}
+
+// clone returns a copy of the subscription sharing nothing with it, nil for a nil subscription.
+// The stored subscriptions are replaced by modified copies, never modified in place,
+// since the users handed out read them without the lock.
+func (s *Subscription) clone() *Subscription {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.History = slices.Clone(s.History)
+	return &c
+}
+
+func (s *Subscription) record(event string, now time.Time) {
+	s.History = append(s.History, SubscriptionEvent{
+		Event:     event,
+		Status:    s.Status,
+		PeriodEnd: s.PeriodEnd,
+		At:        now,
+	})
+}
+
+// ApplySubscriptionEvent applies a Polka event to the subscription of a user and saves it to disk.
+func (db *DB) ApplySubscriptionEvent(userID int, event string, periodEnd time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	for email, user := range db.data.Users {
+		if user.ID == userID {
+			sub := user.Subscription.clone()
+			if sub == nil {
+				sub = &Subscription{}
+			}
+			if err := sub.Apply(event, time.Now().UTC(), periodEnd); err != nil {
+				return err
+			}
+			user.Subscription = sub
+			db.data.Users[email] = user
+			db.notify(Notification{UserID: userID, Type: NotificationSubscription, SubscriptionStatus: user.Subscription.Status})
+			if err := db.writeDB(db.data); err != nil {
+				return fmt.Errorf("write db: %w", err)
+			}
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+// ExpireSubscriptions marks the lapsed subscriptions as expired.
+// It returns the number of expired subscriptions.
+func (db *DB) ExpireSubscriptions(now time.Time) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	expired := 0
+	for email, user := range db.data.Users {
+		if sub := user.Subscription; sub == nil || (sub.Status != SubscriptionActive && sub.Status != SubscriptionPastDue) || sub.IsActive(now) {
+			continue
+		}
+
+		sub := user.Subscription.clone()
+		sub.Status = SubscriptionExpired
+		sub.record(SubscriptionExpired, now)
+		user.Subscription = sub
+		db.data.Users[email] = user
+		db.notify(Notification{UserID: user.ID, Type: NotificationSubscription, SubscriptionStatus: SubscriptionExpired})
+		expired++
+	}
+
+	if expired == 0 {
+		return 0, nil
+	}
+
+	if err := db.writeDB(db.data); err != nil {
+		return 0, fmt.Errorf("write db: %w", err)
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jbdoumenjou/mygoserver/internal/entity"
+)
+
+// TagCount is the number of chirps using a hashtag.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// parseEntities returns the entities of a body, with the users of the mentions.
+// The mentions of unknown handles, and of the users blocking or blocked by the author, are dropped.
+// The caller must hold the lock.
+func (db *DB) parseEntities(authorID int, body string) []entity.Entity {
+	entities := entity.Parse(body)
+
+	resolved := entities[:0]
+	for _, e := range entities {
+		if e.Type == entity.TypeMention {
+			user, ok := db.findUserByHandle(e.Handle)
+			if !ok || db.blocked(authorID, user.ID) {
+				continue
+			}
+			e.UserID = user.ID
+		}
+		resolved = append(resolved, e)
+	}
+	if len(resolved) == 0 {
+		return nil
+	}
+
+	return resolved
+}
+
+// findUserByHandle returns the user of a handle, case insensitive. The caller must hold the lock.
+func (db *DB) findUserByHandle(handle string) (User, bool) {
+	for _, user := range db.data.Users {
+		if user.Handle != "" && strings.EqualFold(user.Handle, handle) {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+// hasTag reports whether the chirp uses a normalized hashtag.
+func (c Chirp) hasTag(tag string) bool {
+	return slices.ContainsFunc(c.Entities, func(e entity.Entity) bool {
+		return e.Type == entity.TypeHashtag && e.Tag == tag
+	})
+}
+
+// mentions reports whether the chirp mentions a user.
+func (c Chirp) mentions(userID int) bool {
+	return slices.ContainsFunc(c.Entities, func(e entity.Entity) bool {
+		return e.Type == entity.TypeMention && e.UserID == userID
+	})
+}
+
+// TrendingTags returns the hashtags used by the most chirps created since a time, the most used first.
+// Ties are broken by the most recent use.
+func (db *DB) TrendingTags(since time.Time, limit int) ([]TagCount, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	counts := map[string]int{}
+	lastUses := map[string]int{}
+	for _, chirp := range db.data.Chirps {
+		if chirp.CreatedAt == nil || chirp.CreatedAt.Before(since) {
+			continue
+		}
+		// a chirp using a hashtag twice counts once.
+		seen := map[string]bool{}
+		for _, e := range chirp.Entities {
+			if e.Type != entity.TypeHashtag || seen[e.Tag] {
+				continue
+			}
+			seen[e.Tag] = true
+			counts[e.Tag]++
+			lastUses[e.Tag] = max(lastUses[e.Tag], chirp.ID)
+		}
+	}
+
+	tags := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, TagCount{Tag: tag, Count: count})
+	}
+	slices.SortFunc(tags, func(i, j TagCount) int {
+		if i.Count != j.Count {
+			return j.Count - i.Count
+		}
+		return lastUses[j.Tag] - lastUses[i.Tag]
+	})
+	if len(tags) > limit {
+		tags = tags[:limit]
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"slices"
+	"time"
+)
+
+// ChirpTombstone is what remains of a deleted chirp of a conversation.
+type ChirpTombstone struct {
+	ID             int       `json:"id"`
+	ReplyToID      int       `json:"reply_to_id,omitempty"`
+	ConversationID int       `json:"conversation_id,omitempty"`
+	DeletedAt      time.Time `json:"deleted_at"`
+}
+
+// Conversation is a chirp and all its direct and indirect replies,
+// with the tombstones of the deleted ones.
+type Conversation struct {
+	ID      int
+	Chirps  []Chirp
+	Deleted []ChirpTombstone
+}
+
+// conversationID returns the id of the conversation the chirp belongs to.
+func (c Chirp) conversationID() int {
+	if c.ConversationID != 0 {
+		return c.ConversationID
+	}
+	return c.ID
+}
+
+// hasReplies reports whether a chirp has replies, deleted or not.
+// The caller must hold the lock.
+func (db *DB) hasReplies(id int) bool {
+	for _, chirp := range db.data.Chirps {
+		if chirp.ReplyToID == id {
+			return true
+		}
+	}
+	for _, tombstone := range db.data.ChirpTombstones {
+		if tombstone.ReplyToID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConversation returns the conversation of a chirp, which can be deleted
+// if it belonged to a conversation. Chirps and tombstones are sorted by id.
+func (db *DB) GetConversation(id int) (Conversation, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	var conversation Conversation
+	if chirp, ok := db.data.Chirps[id]; ok {
+		conversation.ID = chirp.conversationID()
+	} else if tombstone, ok := db.data.ChirpTombstones[id]; ok {
+		conversation.ID = tombstone.ConversationID
+		if conversation.ID == 0 {
+			conversation.ID = tombstone.ID
+		}
+	} else {
+		return Conversation{}, ErrNotFound
+	}
+
+	for _, chirp := range db.data.Chirps {
+		if chirp.conversationID() == conversation.ID {
+			conversation.Chirps = append(conversation.Chirps, chirp)
+		}
+	}
+	for _, tombstone := range db.data.ChirpTombstones {
+		if tombstone.ID == conversation.ID || tombstone.ConversationID == conversation.ID {
+			conversation.Deleted = append(conversation.Deleted, tombstone)
+		}
+	}
+
+	slices.SortFunc(conversation.Chirps, func(i, j Chirp) int { return i.ID - j.ID })
+	slices.SortFunc(conversation.Deleted, func(i, j ChirpTombstone) int { return i.ID - j.ID })
+
+	return conversation, nil
+}
+
+// CountReplies returns the number of direct replies to each of the given chirps.
+func (db *DB) CountReplies(ids []int) (map[int]int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	counts := make(map[int]int, len(ids))
+	for _, id := range ids {
+		counts[id] = 0
+	}
+	for _, chirp := range db.data.Chirps {
+		if _, ok := counts[chirp.ReplyToID]; ok && chirp.ReplyToID != 0 {
+			counts[chirp.ReplyToID]++
+		}
+	}
+
+	return counts, nil
+}
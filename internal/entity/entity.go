@@ -0,0 +1,105 @@
+// Package entity extracts the hashtags and the mentions of the chirp bodies.
+package entity
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	TypeHashtag = "hashtag"
+	TypeMention = "mention"
+)
+
+const (
+	// maxTagLength is the maximum length of a hashtag, in bytes.
+	maxTagLength = 100
+	// minHandleLength and maxHandleLength bound the handles, see the user handles.
+	minHandleLength = 3
+	maxHandleLength = 15
+)
+
+// Entity is a hashtag or a mention of a chirp body.
+// Start and End are the byte offsets of the entity in the body, # and @ included, End excluded.
+type Entity struct {
+	Type  string `json:"type"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// Tag is the hashtag in lowercase, without #.
+	Tag string `json:"tag,omitempty"`
+	// Handle is the mentioned handle as written, without @.
+	Handle string `json:"handle,omitempty"`
+	// UserID is the mentioned user, resolved when the chirp is stored.
+	UserID int `json:"user_id,omitempty"`
+}
+
+// Parse returns the hashtags and mentions of a body, in their order.
+// A # or an @ only starts an entity at the beginning of a word, so that
+// emails and anchors are not taken for mentions and hashtags.
+func Parse(body string) []Entity {
+	var entities []Entity
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		if (r != '#' && r != '@') || !wordStart(body, i) {
+			i += size
+			continue
+		}
+
+		end := i + size
+		for end < len(body) {
+			next, nextSize := utf8.DecodeRuneInString(body[end:])
+			if !isWordRune(next) {
+				break
+			}
+			end += nextSize
+		}
+		word := body[i+size : end]
+
+		switch {
+		case r == '#' && validTag(word):
+			entities = append(entities, Entity{Type: TypeHashtag, Start: i, End: end, Tag: strings.ToLower(word)})
+		case r == '@' && validHandle(word):
+			entities = append(entities, Entity{Type: TypeMention, Start: i, End: end, Handle: word})
+		}
+		i = end
+	}
+
+	return entities
+}
+
+// NormalizeTag returns the form of a hashtag stored in the entities, without # and in lowercase.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+// wordStart reports whether the byte i of the body starts a word.
+func wordStart(body string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	previous, _ := utf8.DecodeLastRuneInString(body[:i])
+	return !isWordRune(previous) && previous != '#' && previous != '@'
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+// validTag reports whether a word is a hashtag: numbers alone, like #1, are not.
+func validTag(word string) bool {
+	return len(word) > 0 && len(word) <= maxTagLength && strings.IndexFunc(word, unicode.IsLetter) >= 0
+}
+
+// validHandle reports whether a word can be a handle: 3 to 15 ASCII letters, digits or underscores.
+func validHandle(word string) bool {
+	if len(word) < minHandleLength || len(word) > maxHandleLength {
+		return false
+	}
+	for _, r := range word {
+		if r != '_' && (r > unicode.MaxASCII || !unicode.IsLetter(r) && !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Entity
+	}{
+		{
+			name: "hashtag and mention",
+			body: "Hi @alice, #GoLang rocks",
+			want: []Entity{
+				{Type: TypeMention, Start: 3, End: 9, Handle: "alice"},
+				{Type: TypeHashtag, Start: 11, End: 18, Tag: "golang"},
+			},
+		},
+		{
+			name: "unicode offsets in bytes",
+			body: "été #café",
+			want: []Entity{{Type: TypeHashtag, Start: 6, End: 12, Tag: "café"}},
+		},
+		{
+			name: "not at a word start",
+			body: "mail bob@example.com or a#b",
+		},
+		{
+			name: "numbers and invalid handles",
+			body: "#1 @ab @this_handle_is_too_long ##double",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.body); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
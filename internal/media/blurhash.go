@@ -0,0 +1,115 @@
+package media
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+const (
+	blurhashComponentsX = 4
+	blurhashComponentsY = 3
+	// blurhashSide is the size of the image the placeholder is computed from.
+	blurhashSide = 32
+
+	base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+)
+
+// Blurhash returns the BlurHash placeholder of the image, see https://blurha.sh.
+func Blurhash(img image.Image) string {
+	img = Fit(img, blurhashSide)
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// decode the pixels once, in linear RGB.
+	pixels := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+			pixels[y*width+x] = [3]float64{srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)}
+		}
+	}
+
+	factors := make([][3]float64, 0, blurhashComponentsX*blurhashComponentsY)
+	for j := 0; j < blurhashComponentsY; j++ {
+		for i := 0; i < blurhashComponentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					p := pixels[y*width+x]
+					factor[0] += basis * p[0]
+					factor[1] += basis * p[1]
+					factor[2] += basis * p[2]
+				}
+			}
+
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	sizeFlag := (blurhashComponentsX - 1) + (blurhashComponentsY-1)*9
+	hash.WriteString(encode83(sizeFlag, 1))
+
+	dc, ac := factors[0], factors[1:]
+	actualMaximum := 0.0
+	for _, f := range ac {
+		actualMaximum = max(actualMaximum, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+	}
+	quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+	maximumValue := float64(quantisedMaximum+1) / 166
+	hash.WriteString(encode83(quantisedMaximum, 1))
+
+	hash.WriteString(encode83(linearToSrgb(dc[0])<<16+linearToSrgb(dc[1])<<8+linearToSrgb(dc[2]), 4))
+
+	for _, f := range ac {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2))
+	}
+
+	return hash.String()
+}
+
+func encode83(value, length int) string {
+	var b strings.Builder
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		b.WriteByte(base83Chars[digit])
+	}
+
+	return b.String()
+}
+
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
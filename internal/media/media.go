@@ -0,0 +1,144 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	ContentTypeJPEG = "image/jpeg"
+	ContentTypePNG  = "image/png"
+	ContentTypeGIF  = "image/gif"
+
+	// maxPixels bounds the decoded size of an image, to refuse decompression bombs.
+	maxPixels = 40_000_000
+	// jpegQuality is the quality of the re-encoded JPEG images.
+	jpegQuality = 85
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported media type")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+)
+
+// Image is an encoded image.
+type Image struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+	// Blurhash is only computed by Process, for the full image.
+	Blurhash string
+}
+
+// Sniff returns the content type of the data, ignoring any client provided type.
+func Sniff(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case ContentTypeJPEG, ContentTypePNG, ContentTypeGIF:
+		return contentType, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+}
+
+// Decode sniffs and decodes an image.
+// The EXIF orientation of JPEG images is applied to the pixels,
+// since the metadata is lost when the image is re-encoded.
+func Decode(data []byte) (image.Image, string, error) {
+	contentType, err := Sniff(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decode image config: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, "", ErrTooManyPixels
+	}
+
+	var img image.Image
+	switch contentType {
+	case ContentTypeJPEG:
+		img, err = jpeg.Decode(bytes.NewReader(data))
+		if err == nil {
+			img = orient(img, jpegOrientation(data))
+		}
+	case ContentTypePNG:
+		img, err = png.Decode(bytes.NewReader(data))
+	case ContentTypeGIF:
+		// only the first frame of animated GIFs is kept.
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("decode image: %w", err)
+	}
+
+	return img, contentType, nil
+}
+
+// Encode re-encodes an image, without any metadata.
+// JPEG images stay JPEG, other images are encoded as PNG.
+func Encode(img image.Image, contentType string) (Image, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == ContentTypeJPEG {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		contentType = ContentTypePNG
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return Image{}, fmt.Errorf("encode image: %w", err)
+	}
+
+	bounds := img.Bounds()
+	return Image{
+		Data:        buf.Bytes(),
+		ContentType: contentType,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+	}, nil
+}
+
+// Process decodes an uploaded image and re-encodes it to strip its metadata.
+// The image is downscaled to fit in maxSide, and a thumbnail fitting in thumbnailSide is generated.
+func Process(data []byte, maxSide, thumbnailSide int) (Image, Image, error) {
+	img, contentType, err := Decode(data)
+	if err != nil {
+		return Image{}, Image{}, err
+	}
+
+	full, err := Encode(Fit(img, maxSide), contentType)
+	if err != nil {
+		return Image{}, Image{}, err
+	}
+	full.Blurhash = Blurhash(img)
+
+	thumbnail, err := Encode(Fit(img, thumbnailSide), contentType)
+	if err != nil {
+		return Image{}, Image{}, err
+	}
+
+	return full, thumbnail, nil
+}
+
+// Extension returns the file extension of a content type.
+func Extension(contentType string) string {
+	switch contentType {
+	case ContentTypeJPEG:
+		return ".jpg"
+	case ContentTypeGIF:
+		return ".gif"
+	default:
+		return ".png"
+	}
+}
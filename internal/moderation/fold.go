@@ -0,0 +1,8 @@
+package moderation
+
+// undecomposed maps the lowercase Latin letters that have no Unicode decomposition,
+// the letters with a stroke and the ligatures, to their base letters.
+var undecomposed = map[rune]string{
+	'æ': "ae", 'ð': "d", 'ø': "o", 'þ': "th", 'đ': "d", 'ħ': "h", 'ı': "i",
+	'ł': "l", 'œ': "oe", 'ŧ': "t", 'ƀ': "b", 'ƶ': "z",
+}
@@ -0,0 +1,232 @@
+// Package moderation masks the forbidden words of the chirps.
+//
+// The words are matched whole, on Unicode word boundaries, after a normalization
+// that ignores the case, the diacritics, the compatibility forms like the fullwidth letters,
+// and the invisible characters, so that "Kerfuffle!", "kérfuffle" and "ｋｅｒｆｕｆｆｌｅ"
+// are all matched by "kerfuffle".
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The masking strategies of the forbidden words.
+const (
+	// MaskFixed replaces the words with four stars, whatever their length.
+	MaskFixed = "fixed"
+	// MaskLength replaces each character of the words with a star.
+	MaskLength = "length"
+	// MaskPartial keeps the first character of the words and replaces the others with stars.
+	MaskPartial = "partial"
+)
+
+// DefaultWords are the words forbidden when no word list is configured.
+var DefaultWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// Filter masks the forbidden words of a text.
+// It is safe for concurrent use, and its words can be reloaded while in use.
+type Filter struct {
+	path string
+	mask string
+
+	mux   sync.RWMutex
+	words map[string]struct{}
+}
+
+// NewFilter returns a filter of the given words, masked with the given strategy, MaskFixed by default.
+func NewFilter(words []string, mask string) (*Filter, error) {
+	if mask == "" {
+		mask = MaskFixed
+	}
+	switch mask {
+	case MaskFixed, MaskLength, MaskPartial:
+	default:
+		return nil, fmt.Errorf("unknown mask %q", mask)
+	}
+
+	f := &Filter{mask: mask}
+	f.setWords(words)
+	return f, nil
+}
+
+// LoadFilter returns a filter of the words listed in a file, one per line.
+// Blank lines and lines starting with # are ignored. The file is read again by Reload.
+func LoadFilter(path, mask string) (*Filter, error) {
+	words, err := readWords(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := NewFilter(words, mask)
+	if err != nil {
+		return nil, err
+	}
+	f.path = path
+	return f, nil
+}
+
+// Reload reads the word list file again.
+// The current words are kept when the file cannot be read, and a filter without file is left unchanged.
+func (f *Filter) Reload() error {
+	if f.path == "" {
+		return nil
+	}
+
+	words, err := readWords(f.path)
+	if err != nil {
+		return err
+	}
+	f.setWords(words)
+	return nil
+}
+
+// Words returns the number of forbidden words.
+func (f *Filter) Words() int {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	return len(f.words)
+}
+
+func (f *Filter) setWords(words []string) {
+	set := make(map[string]struct{}, len(words))
+	for _, word := range words {
+		if normalized := Normalize(word); normalized != "" {
+			set[normalized] = struct{}{}
+		}
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.words = set
+}
+
+func readWords(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open word list: %w", err)
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read word list: %w", err)
+	}
+
+	return words, nil
+}
+
+// Clean returns the text with its forbidden words masked.
+// The text between the words, spaces and punctuation, is kept as is.
+func (f *Filter) Clean(text string) string {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	if len(f.words) == 0 {
+		return text
+	}
+
+	var cleaned strings.Builder
+	cleaned.Grow(len(text))
+	for len(text) > 0 {
+		// copy the separators, up to the next word.
+		end := strings.IndexFunc(text, isWordRune)
+		if end < 0 {
+			cleaned.WriteString(text)
+			break
+		}
+		cleaned.WriteString(text[:end])
+		text = text[end:]
+
+		end = strings.IndexFunc(text, func(r rune) bool { return !isWordRune(r) })
+		if end < 0 {
+			end = len(text)
+		}
+		word := text[:end]
+		text = text[end:]
+
+		if _, forbidden := f.words[Normalize(word)]; forbidden {
+			cleaned.WriteString(f.maskWord(word))
+		} else {
+			cleaned.WriteString(word)
+		}
+	}
+
+	return cleaned.String()
+}
+
+func (f *Filter) maskWord(word string) string {
+	switch f.mask {
+	case MaskLength:
+		return strings.Repeat("*", visibleLength(word))
+	case MaskPartial:
+		first, rest, _, _ := uniseg.FirstGraphemeClusterInString(strings.TrimLeftFunc(word, isInvisible), -1)
+		return first + strings.Repeat("*", visibleLength(rest))
+	default:
+		return "****"
+	}
+}
+
+// visibleLength is the number of characters of a word, without its invisible characters.
+func visibleLength(word string) int {
+	return uniseg.GraphemeClusterCount(strings.Map(func(r rune) rune {
+		if isInvisible(r) {
+			return -1
+		}
+		return r
+	}, word))
+}
+
+// Normalize returns the form under which the words are compared: case folded,
+// in the compatibility decomposition (NFKD) without its combining marks nor the invisible characters,
+// and with the Latin letters that do not decompose, like ø or ł, replaced by their base letters.
+func Normalize(word string) string {
+	decomposed := norm.NFKD.String(cases.Fold().String(word))
+
+	var normalized strings.Builder
+	normalized.Grow(len(decomposed))
+	for _, r := range decomposed {
+		if isInvisible(r) || unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if base, ok := undecomposed[r]; ok {
+			normalized.WriteString(base)
+			continue
+		}
+		normalized.WriteRune(r)
+	}
+
+	return normalized.String()
+}
+
+// isWordRune reports whether r is part of a word: a letter, a digit, a mark,
+// or an invisible character that could be used to split a word without being seen.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || isInvisible(r)
+}
+
+// isInvisible reports whether r is the soft hyphen, a zero width character or the byte order mark.
+func isInvisible(r rune) bool {
+	switch r {
+	case '\u00ad', '\u200b', '\u200c', '\u200d', '\u2060', '\ufeff':
+		return true
+	}
+	return false
+}
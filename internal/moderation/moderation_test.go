@@ -0,0 +1,83 @@
+package moderation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilter_Clean(t *testing.T) {
+	tests := []struct {
+		name string
+		mask string
+		text string
+		want string
+	}{
+		{name: "space separated", text: "This is a kerfuffle opinion", want: "This is a **** opinion"},
+		{name: "punctuation", text: "What a Kerfuffle! Sharbert, fornax.", want: "What a ****! ****, ****."},
+		{name: "substring", text: "kerfuffles are fine", want: "kerfuffles are fine"},
+		{name: "diacritics", text: "a k\u00e9rf\u00fcffle", want: "a ****"},
+		{name: "combining marks", text: "a ke\u0301rfuffle", want: "a ****"},
+		{name: "fullwidth", text: "a \uff2b\uff45\uff52\uff46\uff55\uff46\uff46\uff4c\uff45", want: "a ****"},
+		{name: "zero width", text: "a ker\u200bfuffle", want: "a ****"},
+		{name: "ligature", text: "a kerfu\ufb00le", want: "a ****"},
+		{name: "stroke", text: "a f\u00f8rnax", want: "a ****"},
+		{name: "case fold", text: "a SHARBERT", want: "a ****"},
+		{name: "length mask", mask: MaskLength, text: "Fornax!", want: "******!"},
+		{name: "length mask diacritics", mask: MaskLength, text: "fo\u0301rnax", want: "******"},
+		{name: "partial mask", mask: MaskPartial, text: "(Sharbert)", want: "(S*******)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(DefaultWords, tt.mask)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Clean(tt.text); got != tt.want {
+				t.Errorf("Clean(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFilter_UnknownMask(t *testing.T) {
+	if _, err := NewFilter(DefaultWords, "blur"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestLoadFilter_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# forbidden words\nkerfuffle\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := LoadFilter(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Clean("kerfuffle fornax"); got != "**** fornax" {
+		t.Errorf("got %q", got)
+	}
+
+	if err := os.WriteFile(path, []byte("fornax\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Clean("kerfuffle fornax"); got != "kerfuffle ****" {
+		t.Errorf("got %q after reload", got)
+	}
+
+	// a failed reload keeps the current words.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(); err == nil {
+		t.Error("expected an error")
+	}
+	if f.Words() != 1 {
+		t.Errorf("got %d words, want 1", f.Words())
+	}
+}
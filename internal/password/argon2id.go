@@ -0,0 +1,134 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+var errInvalidArgon2idHash = errors.New("invalid argon2id hash")
+
+// Argon2idParams are the argon2id parameters.
+// Zero values are replaced by the defaults recommended by RFC 9106.
+type Argon2idParams struct {
+	// Memory in KiB.
+	Memory     uint32
+	Iterations uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// Argon2id hashes passwords with argon2id.
+// Hashes use the PHC string format: $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>.
+type Argon2id struct {
+	params Argon2idParams
+}
+
+// NewArgon2id returns an argon2id hasher.
+func NewArgon2id(params Argon2idParams) *Argon2id {
+	if params.Memory == 0 {
+		params.Memory = 64 * 1024
+	}
+	if params.Iterations == 0 {
+		params.Iterations = 1
+	}
+	if params.Threads == 0 {
+		params.Threads = 4
+	}
+	if params.SaltLength == 0 {
+		params.SaltLength = 16
+	}
+	if params.KeyLength == 0 {
+		params.KeyLength = 32
+	}
+
+	return &Argon2id{params: params}
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.params.Iterations, a.params.Memory, a.params.Threads, a.params.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version,
+		a.params.Memory, a.params.Iterations, a.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2id) Verify(hash, password string) error {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	got := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Threads, params.KeyLength)
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return ErrMismatch
+	}
+
+	return nil
+}
+
+func (a *Argon2id) Identify(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func (a *Argon2id) NeedsRehash(hash string) bool {
+	params, salt, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory < a.params.Memory ||
+		params.Iterations < a.params.Iterations ||
+		params.Threads < a.params.Threads ||
+		params.KeyLength < a.params.KeyLength ||
+		uint32(len(salt)) < a.params.SaltLength
+}
+
+func decodeArgon2id(hash string) (Argon2idParams, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=1,p=4", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2idParams{}, nil, nil, errInvalidArgon2idHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2idParams{}, nil, nil, errInvalidArgon2idHash
+	}
+
+	var params Argon2idParams
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Threads); err != nil {
+		return Argon2idParams{}, nil, nil, errInvalidArgon2idHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idParams{}, nil, nil, errInvalidArgon2idHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2idParams{}, nil, nil, errInvalidArgon2idHash
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
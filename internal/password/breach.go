@@ -0,0 +1,130 @@
+package password
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxLineLength bounds the length of a corpus line, a hash followed by its count.
+const maxLineLength = 128
+
+// Corpus checks passwords against a local corpus of breached SHA-1 hashes.
+//
+// Each line of the corpus file is a hex SHA-1 hash, optionally followed by a colon
+// and a count ("<SHA1>:<count>"), and the lines are sorted by hash,
+// as in the files ordered by hash of the Pwned Passwords downloader.
+// The corpus is not loaded in memory: a lookup binary searches the file,
+// reading a few lines from the disk.
+type Corpus struct {
+	file *os.File
+	size int64
+}
+
+// OpenCorpus opens a breached password corpus file, checking its first line.
+func OpenCorpus(path string) (*Corpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open corpus: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("stat corpus: %w", err)
+	}
+
+	c := &Corpus{file: f, size: info.Size()}
+	if _, err := c.hashAt(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Close closes the corpus file.
+func (c *Corpus) Close() error {
+	return c.file.Close()
+}
+
+// IsBreached reports whether the password is in the corpus.
+func (c *Corpus) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	// finds the first line starting at or after lo whose hash is not before the password one.
+	lo, hi := int64(0), c.size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, err := c.hashAt(mid)
+		if err != nil {
+			return false, err
+		}
+		if line != "" && line < hash {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	line, err := c.hashAt(lo)
+	if err != nil {
+		return false, err
+	}
+
+	return line == hash, nil
+}
+
+// hashAt returns the uppercase hash of the first line starting at or after the offset,
+// skipping the blank lines. It returns an empty hash at the end of the corpus.
+func (c *Corpus) hashAt(offset int64) (string, error) {
+	buf := make([]byte, 2*maxLineLength)
+	for offset < c.size {
+		// the previous byte tells whether a line starts at the offset.
+		start := max(offset-1, 0)
+		n, err := c.file.ReadAt(buf, start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("read corpus: %w", err)
+		}
+		chunk := buf[:n]
+		if offset > 0 {
+			i := bytes.IndexByte(chunk, '\n')
+			if i < 0 {
+				if start+int64(n) < c.size {
+					return "", fmt.Errorf("corpus line longer than %d bytes at %d", maxLineLength, start)
+				}
+				break
+			}
+			chunk, offset = chunk[i+1:], start+int64(i)+1
+		}
+
+		end := bytes.IndexByte(chunk, '\n')
+		line := chunk
+		if end >= 0 {
+			line = chunk[:end]
+		} else if offset+int64(len(chunk)) < c.size {
+			return "", fmt.Errorf("corpus line longer than %d bytes at %d", maxLineLength, offset)
+		}
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			if end < 0 {
+				break
+			}
+			offset += int64(end) + 1
+			continue
+		}
+
+		hash, _, _ := bytes.Cut(line, []byte(":"))
+		if len(hash) != sha1.Size*2 {
+			return "", fmt.Errorf("invalid corpus hash %q", hash)
+		}
+
+		return strings.ToUpper(string(hash)), nil
+	}
+
+	return "", nil
+}
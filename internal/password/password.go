@@ -0,0 +1,64 @@
+package password
+
+import (
+	"errors"
+)
+
+var (
+	ErrMismatch         = errors.New("password mismatch")
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+)
+
+// Hasher hashes passwords with a given algorithm.
+// The algorithm and its parameters are encoded in the hash.
+type Hasher interface {
+	// Hash returns the encoded hash of the password.
+	Hash(password string) (string, error)
+	// Verify returns ErrMismatch when the password doesn't match the hash.
+	Verify(hash, password string) error
+	// Identify reports whether the hash has been produced by this algorithm.
+	Identify(hash string) bool
+	// NeedsRehash reports whether the hash uses outdated parameters.
+	NeedsRehash(hash string) bool
+}
+
+// Manager hashes new passwords with a preferred hasher
+// and still verifies the hashes produced by the other ones.
+type Manager struct {
+	preferred Hasher
+	hashers   []Hasher
+}
+
+// NewManager returns a manager hashing with preferred and verifying with preferred and others.
+func NewManager(preferred Hasher, others ...Hasher) *Manager {
+	return &Manager{preferred: preferred, hashers: append([]Hasher{preferred}, others...)}
+}
+
+// Default returns a manager hashing with bcrypt and its default cost,
+// and accepting argon2id hashes.
+func Default() *Manager {
+	return NewManager(NewBcrypt(0), NewArgon2id(Argon2idParams{}))
+}
+
+// Hash hashes the password with the preferred hasher.
+func (m *Manager) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+// Verify checks the password against the hash.
+// It reports whether the hash should be replaced by a hash from the preferred hasher.
+func (m *Manager) Verify(hash, password string) (needsRehash bool, err error) {
+	for _, hasher := range m.hashers {
+		if !hasher.Identify(hash) {
+			continue
+		}
+
+		if err := hasher.Verify(hash, password); err != nil {
+			return false, err
+		}
+
+		return hasher != m.preferred || hasher.NeedsRehash(hash), nil
+	}
+
+	return false, ErrUnknownAlgorithm
+}
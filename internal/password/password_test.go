@@ -0,0 +1,186 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestManager_Verify(t *testing.T) {
+	oldBcrypt := NewBcrypt(4)
+	newBcrypt := NewBcrypt(5)
+	argon2id := NewArgon2id(Argon2idParams{Memory: 1024})
+
+	tests := []struct {
+		name            string
+		hasher          Hasher
+		manager         *Manager
+		password        string
+		wantNeedsRehash bool
+		wantErr         error
+	}{
+		{
+			name:     "Up to date bcrypt hash",
+			hasher:   newBcrypt,
+			manager:  NewManager(newBcrypt, argon2id),
+			password: "secret",
+		},
+		{
+			name:            "Outdated bcrypt cost",
+			hasher:          oldBcrypt,
+			manager:         NewManager(newBcrypt, argon2id),
+			password:        "secret",
+			wantNeedsRehash: true,
+		},
+		{
+			name:            "Bcrypt hash with argon2id preferred",
+			hasher:          newBcrypt,
+			manager:         NewManager(argon2id, newBcrypt),
+			password:        "secret",
+			wantNeedsRehash: true,
+		},
+		{
+			name:     "Up to date argon2id hash",
+			hasher:   argon2id,
+			manager:  NewManager(argon2id, newBcrypt),
+			password: "secret",
+		},
+		{
+			name:     "Wrong password",
+			hasher:   argon2id,
+			manager:  NewManager(argon2id),
+			password: "wrong",
+			wantErr:  ErrMismatch,
+		},
+		{
+			name:     "Unknown algorithm",
+			hasher:   argon2id,
+			manager:  NewManager(newBcrypt),
+			password: "secret",
+			wantErr:  ErrUnknownAlgorithm,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash, err := test.hasher.Hash("secret")
+			if err != nil {
+				t.Fatalf("Hash() error = %v", err)
+			}
+
+			needsRehash, err := test.manager.Verify(hash, test.password)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, test.wantErr)
+			}
+			if needsRehash != test.wantNeedsRehash {
+				t.Errorf("Verify() needsRehash = %v, want %v", needsRehash, test.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestCorpus_IsBreached(t *testing.T) {
+	var hashes []string
+	for i := 0; i < 1000; i++ {
+		password := fmt.Sprintf("password-%d", i)
+		if i%2 == 0 {
+			sum := sha1.Sum([]byte(password))
+			hashes = append(hashes, strings.ToUpper(hex.EncodeToString(sum[:])))
+		}
+	}
+	slices.Sort(hashes)
+	var corpusFile strings.Builder
+	for i, hash := range hashes {
+		fmt.Fprintf(&corpusFile, "%s:%d\r\n", hash, i+1)
+	}
+	corpusFile.WriteString("\n")
+
+	corpusPath := filepath.Join(t.TempDir(), "corpus.txt")
+	if err := os.WriteFile(corpusPath, []byte(corpusFile.String()), 0644); err != nil {
+		t.Fatalf("write corpus: %v", err)
+	}
+	corpus, err := OpenCorpus(corpusPath)
+	if err != nil {
+		t.Fatalf("OpenCorpus() error = %v", err)
+	}
+	t.Cleanup(func() { corpus.Close() })
+
+	for i := 0; i < 1000; i++ {
+		password := fmt.Sprintf("password-%d", i)
+		got, err := corpus.IsBreached(password)
+		if err != nil {
+			t.Fatalf("IsBreached() error = %v", err)
+		}
+		if want := i%2 == 0; got != want {
+			t.Errorf("IsBreached(%q) = %v, want %v", password, got, want)
+		}
+	}
+
+	if err := os.WriteFile(corpusPath, []byte("not a hash\n"), 0644); err != nil {
+		t.Fatalf("write corpus: %v", err)
+	}
+	if _, err := OpenCorpus(corpusPath); err == nil {
+		t.Error("OpenCorpus() expected an error for an invalid corpus")
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	corpusPath := filepath.Join(t.TempDir(), "corpus.txt")
+	// SHA-1 of "password123"
+	if err := os.WriteFile(corpusPath, []byte("CBFDAC6008F9CAB4083784CBD1874F76618D2A97:251682\n"), 0644); err != nil {
+		t.Fatalf("write corpus: %v", err)
+	}
+	corpus, err := OpenCorpus(corpusPath)
+	if err != nil {
+		t.Fatalf("OpenCorpus() error = %v", err)
+	}
+	t.Cleanup(func() { corpus.Close() })
+
+	manager := NewManager(NewBcrypt(4))
+	previous, err := manager.Hash("Previous-1")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+
+	policy := Policy{
+		MinLength:    8,
+		RequireUpper: true,
+		RequireDigit: true,
+		HistorySize:  1,
+		Breached:     corpus,
+	}
+
+	tests := []struct {
+		name      string
+		password  string
+		wantCodes []string
+	}{
+		{name: "Valid", password: "Breakfast-42"},
+		{name: "Empty", password: "", wantCodes: []string{CodeTooShort, CodeMissingUpper, CodeMissingDigit}},
+		{name: "Reused", password: "Previous-1", wantCodes: []string{CodeReused}},
+		{name: "Breached", password: "password123", wantCodes: []string{CodeMissingUpper, CodeBreached}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			violations, err := policy.Check(manager, test.password, []string{previous})
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+
+			var codes []string
+			for _, violation := range violations {
+				codes = append(codes, violation.Code)
+			}
+			if !slices.Equal(codes, test.wantCodes) {
+				t.Errorf("Check() codes = %v, want %v", codes, test.wantCodes)
+			}
+		})
+	}
+}
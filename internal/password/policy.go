@@ -0,0 +1,112 @@
+package password
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation codes.
+const (
+	CodeTooShort      = "too_short"
+	CodeMissingUpper  = "missing_upper"
+	CodeMissingLower  = "missing_lower"
+	CodeMissingDigit  = "missing_digit"
+	CodeMissingSymbol = "missing_symbol"
+	CodeReused        = "reused"
+	CodeBreached      = "breached"
+)
+
+// Violation is a password policy violation.
+type Violation struct {
+	Code    string
+	Message string
+}
+
+// BreachChecker reports whether a password appears in a known data breach.
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// Policy is the set of rules a new password must follow.
+type Policy struct {
+	// MinLength is the minimum number of characters.
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// HistorySize is the number of previous passwords that cannot be reused.
+	HistorySize int
+	// Breached is optional.
+	Breached BreachChecker
+}
+
+// DefaultPolicy returns the policy used when none is configured.
+func DefaultPolicy() Policy {
+	return Policy{MinLength: 8, HistorySize: 5}
+}
+
+// Check returns the violations of the password.
+// history holds the current and previous password hashes, most recent first.
+func (p Policy) Check(m *Manager, password string, history []string) ([]Violation, error) {
+	var violations []Violation
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, Violation{Code: CodeMissingUpper, Message: "password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, Violation{Code: CodeMissingLower, Message: "password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{Code: CodeMissingDigit, Message: "password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{Code: CodeMissingSymbol, Message: "password must contain a symbol"})
+	}
+
+	if len(history) > p.HistorySize {
+		history = history[:p.HistorySize]
+	}
+	for _, hash := range history {
+		if _, err := m.Verify(hash, password); err == nil {
+			violations = append(violations, Violation{
+				Code:    CodeReused,
+				Message: fmt.Sprintf("password must differ from the last %d passwords", p.HistorySize),
+			})
+			break
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, Violation{Code: CodeBreached, Message: "password appears in a known data breach"})
+		}
+	}
+
+	return violations, nil
+}
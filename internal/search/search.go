@@ -0,0 +1,180 @@
+// Package search implements an in-memory full-text index over chirps.
+package search
+
+import (
+	"math"
+	"slices"
+	"sync"
+)
+
+// Orders of the search results.
+const (
+	OrderRelevance = "relevance"
+	OrderRecency   = "recent"
+)
+
+// BM25 parameters.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Hit is a document matching a query.
+type Hit struct {
+	ID    int
+	Score float64
+}
+
+// Index is an inverted index from the terms to the documents containing them.
+// Documents are identified by increasing ids, so the recency of a document is its id.
+type Index struct {
+	mux sync.RWMutex
+	// postings maps a term to the positions of the term in each document.
+	postings map[string]map[int][]int
+	// lengths maps a document to its number of terms.
+	lengths     map[int]int
+	totalLength int
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{
+		postings: make(map[string]map[int][]int),
+		lengths:  make(map[int]int),
+	}
+}
+
+// Add indexes a document, replacing its previous version if any.
+func (i *Index) Add(id int, text string) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	i.remove(id)
+
+	terms := Tokenize(text)
+	for position, term := range terms {
+		docs, ok := i.postings[term]
+		if !ok {
+			docs = make(map[int][]int)
+			i.postings[term] = docs
+		}
+		docs[id] = append(docs[id], position)
+	}
+	i.lengths[id] = len(terms)
+	i.totalLength += len(terms)
+}
+
+// Remove removes a document from the index.
+func (i *Index) Remove(id int) {
+	i.mux.Lock()
+	defer i.mux.Unlock()
+
+	i.remove(id)
+}
+
+func (i *Index) remove(id int) {
+	length, ok := i.lengths[id]
+	if !ok {
+		return
+	}
+
+	for term, docs := range i.postings {
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(i.postings, term)
+		}
+	}
+	delete(i.lengths, id)
+	i.totalLength -= length
+}
+
+// Search returns the documents matching the query, by relevance or by recency.
+// Relevance is the BM25 score of the terms, ties are broken by recency.
+func (i *Index) Search(query Query, order string) []Hit {
+	if query.Empty() {
+		return nil
+	}
+
+	i.mux.RLock()
+	defer i.mux.RUnlock()
+
+	terms := slices.Clone(query.Terms)
+	slices.Sort(terms)
+	terms = slices.Compact(terms)
+	// start from the rarest term to keep the candidates small.
+	slices.SortFunc(terms, func(x, y string) int {
+		return len(i.postings[x]) - len(i.postings[y])
+	})
+
+	var hits []Hit
+	for id := range i.postings[terms[0]] {
+		if !i.matches(id, terms, query.Phrases) {
+			continue
+		}
+		hits = append(hits, Hit{ID: id, Score: i.score(id, terms)})
+	}
+
+	slices.SortFunc(hits, func(x, y Hit) int {
+		if order != OrderRecency && x.Score != y.Score {
+			if x.Score > y.Score {
+				return -1
+			}
+			return 1
+		}
+		return y.ID - x.ID
+	})
+
+	return hits
+}
+
+// matches reports whether the document contains all the terms and phrases.
+func (i *Index) matches(id int, terms []string, phrases [][]string) bool {
+	for _, term := range terms {
+		if _, ok := i.postings[term][id]; !ok {
+			return false
+		}
+	}
+
+	for _, phrase := range phrases {
+		if !i.containsPhrase(id, phrase) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// containsPhrase reports whether the terms of the phrase follow each other in the document.
+func (i *Index) containsPhrase(id int, phrase []string) bool {
+	for _, start := range i.postings[phrase[0]][id] {
+		found := true
+		for offset, term := range phrase[1:] {
+			if !slices.Contains(i.postings[term][id], start+offset+1) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// score returns the BM25 score of the document for the terms.
+func (i *Index) score(id int, terms []string) float64 {
+	count := float64(len(i.lengths))
+	averageLength := float64(i.totalLength) / count
+	length := float64(i.lengths[id])
+
+	var score float64
+	for _, term := range terms {
+		docs := i.postings[term]
+		frequency := float64(len(docs[id]))
+		idf := math.Log(1 + (count-float64(len(docs))+0.5)/(float64(len(docs))+0.5))
+		score += idf * frequency * (k1 + 1) / (frequency + k1*(1-b+b*length/averageLength))
+	}
+
+	return score
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStem(t *testing.T) {
+	tests := map[string]string{
+		"caresses":       "caress",
+		"ponies":         "poni",
+		"cats":           "cat",
+		"feed":           "feed",
+		"agreed":         "agre",
+		"plastered":      "plaster",
+		"motoring":       "motor",
+		"sing":           "sing",
+		"conflated":      "conflat",
+		"sized":          "size",
+		"hopping":        "hop",
+		"falling":        "fall",
+		"filing":         "file",
+		"happy":          "happi",
+		"relational":     "relat",
+		"generalization": "gener",
+		"running":        "run",
+		"connection":     "connect",
+		"connected":      "connect",
+		"controll":       "control",
+		"go":             "go",
+		"café":           "café",
+	}
+	for word, want := range tests {
+		if got := Stem(word); got != want {
+			t.Errorf("Stem(%q) = %q, want %q", word, got, want)
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	got := ParseQuery(`Running "Don't PANIC" now`)
+	want := Query{
+		Terms:   []string{"run", "dont", "panic", "now"},
+		Phrases: [][]string{{"dont", "panic"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseQuery() got = %v, want %v", got, want)
+	}
+}
+
+func TestIndex_Search(t *testing.T) {
+	index := NewIndex()
+	index.Add(1, "I had something interesting for breakfast")
+	index.Add(2, "Breakfast is interesting, breakfast is great")
+	index.Add(3, "Something interesting happened")
+	index.Add(4, "interesting")
+	index.Remove(4)
+
+	tests := []struct {
+		name  string
+		query string
+		order string
+		want  []int
+	}{
+		{name: "relevance", query: "breakfasts", order: OrderRelevance, want: []int{2, 1}},
+		{name: "recency", query: "breakfast", order: OrderRecency, want: []int{2, 1}},
+		{name: "all terms", query: "interesting something", order: OrderRecency, want: []int{3, 1}},
+		{name: "phrase", query: `"something interesting"`, order: OrderRecency, want: []int{3, 1}},
+		{name: "phrase order", query: `"interesting something"`, order: OrderRecency},
+		{name: "no match", query: "dinner", order: OrderRelevance},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for _, hit := range index.Search(ParseQuery(tt.query), tt.order) {
+				got = append(got, hit.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	index.Add(2, "Lunch")
+	if hits := index.Search(ParseQuery("breakfast"), OrderRelevance); len(hits) != 1 || hits[0].ID != 1 {
+		t.Errorf("Search() after update got = %v, want [1]", hits)
+	}
+}
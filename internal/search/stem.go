@@ -0,0 +1,250 @@
+package search
+
+// Stem returns the stem of a lower case English word with the Porter algorithm,
+// see https://tartarus.org/martin/PorterStemmer/.
+// Words of two letters or less and words with other characters than a-z are returned as is.
+func Stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	s := &stemmer{b: []byte(word), k: len(word) - 1}
+	s.step1ab()
+	if s.k > 0 {
+		s.step1c()
+		s.step2()
+		s.step3()
+		s.step4()
+		s.step5()
+	}
+
+	return string(s.b[:s.k+1])
+}
+
+// stemmer holds the word being stemmed in b[0:k+1],
+// j is the end of the stem when a suffix is matched.
+type stemmer struct {
+	b    []byte
+	k, j int
+}
+
+// suffix is a suffix and its replacement.
+type suffix struct {
+	from, to string
+}
+
+// cons reports whether b[i] is a consonant.
+func (s *stemmer) cons(i int) bool {
+	switch s.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !s.cons(i-1)
+	}
+	return true
+}
+
+// m measures the number of vowel-consonant sequences in b[0:j+1].
+func (s *stemmer) m() int {
+	n, i := 0, 0
+	for ; i <= s.j && s.cons(i); i++ {
+	}
+	for i <= s.j {
+		for ; i <= s.j && !s.cons(i); i++ {
+		}
+		if i > s.j {
+			break
+		}
+		n++
+		for ; i <= s.j && s.cons(i); i++ {
+		}
+	}
+	return n
+}
+
+// vowelInStem reports whether b[0:j+1] contains a vowel.
+func (s *stemmer) vowelInStem() bool {
+	for i := 0; i <= s.j; i++ {
+		if !s.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleC reports whether b[j-1:j+1] is a double consonant.
+func (s *stemmer) doubleC(j int) bool {
+	return j >= 1 && s.b[j] == s.b[j-1] && s.cons(j)
+}
+
+// cvc reports whether b[i-2:i+1] is consonant-vowel-consonant
+// and the last consonant is not w, x or y.
+func (s *stemmer) cvc(i int) bool {
+	if i < 2 || !s.cons(i) || s.cons(i-1) || !s.cons(i-2) {
+		return false
+	}
+	switch s.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// ends reports whether b[0:k+1] ends with the suffix, and sets j to the end of the stem.
+func (s *stemmer) ends(suffix string) bool {
+	l := len(suffix)
+	if l > s.k+1 || string(s.b[s.k-l+1:s.k+1]) != suffix {
+		return false
+	}
+	s.j = s.k - l
+	return true
+}
+
+// setTo replaces b[j+1:k+1] by the given string.
+func (s *stemmer) setTo(str string) {
+	s.b = append(s.b[:s.j+1], str...)
+	s.k = s.j + len(str)
+}
+
+// replace replaces the first matching suffix when the stem measure is positive.
+func (s *stemmer) replace(suffixes []suffix) {
+	for _, suffix := range suffixes {
+		if s.ends(suffix.from) {
+			if s.m() > 0 {
+				s.setTo(suffix.to)
+			}
+			return
+		}
+	}
+}
+
+// step1ab removes plurals and -ed or -ing.
+func (s *stemmer) step1ab() {
+	if s.b[s.k] == 's' {
+		switch {
+		case s.ends("sses"):
+			s.k -= 2
+		case s.ends("ies"):
+			s.setTo("i")
+		case s.b[s.k-1] != 's':
+			s.k--
+		}
+	}
+
+	if s.ends("eed") {
+		if s.m() > 0 {
+			s.k--
+		}
+		return
+	}
+	if (s.ends("ed") || s.ends("ing")) && s.vowelInStem() {
+		s.k = s.j
+		switch {
+		case s.ends("at"):
+			s.setTo("ate")
+		case s.ends("bl"):
+			s.setTo("ble")
+		case s.ends("iz"):
+			s.setTo("ize")
+		case s.doubleC(s.k):
+			switch s.b[s.k] {
+			case 'l', 's', 'z':
+			default:
+				s.k--
+			}
+		default:
+			s.j = s.k
+			if s.m() == 1 && s.cvc(s.k) {
+				s.setTo("e")
+			}
+		}
+	}
+}
+
+// step1c turns a terminal y to i when there is another vowel in the stem.
+func (s *stemmer) step1c() {
+	if s.ends("y") && s.vowelInStem() {
+		s.b[s.k] = 'i'
+	}
+}
+
+var step2Suffixes = map[byte][]suffix{
+	'a': {{"ational", "ate"}, {"tional", "tion"}},
+	'c': {{"enci", "ence"}, {"anci", "ance"}},
+	'e': {{"izer", "ize"}},
+	'l': {{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}},
+	'o': {{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}},
+	's': {{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"}},
+	't': {{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}},
+	'g': {{"logi", "log"}},
+}
+
+// step2 maps double suffixes to single ones.
+func (s *stemmer) step2() {
+	s.replace(step2Suffixes[s.b[s.k-1]])
+}
+
+var step3Suffixes = map[byte][]suffix{
+	'e': {{"icate", "ic"}, {"ative", ""}, {"alize", "al"}},
+	'i': {{"iciti", "ic"}},
+	'l': {{"ical", "ic"}, {"ful", ""}},
+	's': {{"ness", ""}},
+}
+
+// step3 handles -ic-, -full, -ness etc.
+func (s *stemmer) step3() {
+	s.replace(step3Suffixes[s.b[s.k]])
+}
+
+var step4Suffixes = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ion", "ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
+
+// step4 removes -ant, -ence etc. when the stem measure is greater than one.
+func (s *stemmer) step4() {
+	if s.k < 1 {
+		return
+	}
+	for _, suffix := range step4Suffixes[s.b[s.k-1]] {
+		if !s.ends(suffix) {
+			continue
+		}
+		// -ion is only removed after s or t.
+		if suffix == "ion" && (s.j < 0 || (s.b[s.j] != 's' && s.b[s.j] != 't')) {
+			continue
+		}
+		if s.m() > 1 {
+			s.k = s.j
+		}
+		return
+	}
+}
+
+// step5 removes a final -e and turns -ll to -l when the stem measure is greater than one.
+func (s *stemmer) step5() {
+	s.j = s.k
+	if s.b[s.k] == 'e' {
+		if m := s.m(); m > 1 || m == 1 && !s.cvc(s.k-1) {
+			s.k--
+		}
+	}
+	if s.b[s.k] == 'l' && s.doubleC(s.k) && s.m() > 1 {
+		s.k--
+	}
+}
@@ -0,0 +1,67 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Tokenize splits a text into lower case, stemmed terms.
+// Terms are runs of letters and digits; apostrophes inside words are dropped
+// so that "don't" and "dont" match.
+func Tokenize(text string) []string {
+	var terms []string
+	var term strings.Builder
+
+	flush := func() {
+		if term.Len() > 0 {
+			terms = append(terms, Stem(term.String()))
+			term.Reset()
+		}
+	}
+
+	runes := []rune(text)
+	for i, r := range runes {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			term.WriteRune(unicode.ToLower(r))
+		case isApostrophe(r) && term.Len() > 0 && i+1 < len(runes) && unicode.IsLetter(runes[i+1]):
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return terms
+}
+
+func isApostrophe(r rune) bool {
+	return r == '\'' || r == '’'
+}
+
+// Query is a parsed search query.
+// A document matches when it contains all the terms, and every phrase as consecutive terms.
+type Query struct {
+	Terms   []string
+	Phrases [][]string
+}
+
+// ParseQuery parses a query where double quoted parts are phrases.
+// An unterminated quote runs until the end of the query.
+func ParseQuery(query string) Query {
+	var q Query
+	for i, part := range strings.Split(query, `"`) {
+		terms := Tokenize(part)
+		// odd parts are quoted.
+		if i%2 == 1 && len(terms) > 1 {
+			q.Phrases = append(q.Phrases, terms)
+		}
+		q.Terms = append(q.Terms, terms...)
+	}
+
+	return q
+}
+
+// Empty reports whether the query has no term.
+func (q Query) Empty() bool {
+	return len(q.Terms) == 0
+}
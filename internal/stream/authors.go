@@ -0,0 +1,46 @@
+package stream
+
+import "time"
+
+// DefaultAuthorsTTL is how long the authors of a stream are kept before being read again:
+// the follows, blocks and mutes apply to the connected clients within this delay.
+const DefaultAuthorsTTL = 10 * time.Second
+
+// Authors caches a set of authors read from the store, such as the users followed by a client
+// or hidden from them, so that the events are not filtered with a read of the store each.
+// The set is read again on the first lookup after its TTL. It is not safe for concurrent use.
+type Authors struct {
+	load     func() ([]int, error)
+	ttl      time.Duration
+	now      func() time.Time
+	ids      map[int]bool
+	loadedAt time.Time
+}
+
+// NewAuthors returns a set of authors read with load, DefaultAuthorsTTL if ttl is 0.
+func NewAuthors(load func() ([]int, error), ttl time.Duration) *Authors {
+	if ttl == 0 {
+		ttl = DefaultAuthorsTTL
+	}
+
+	return &Authors{load: load, ttl: ttl, now: time.Now}
+}
+
+// Contains reports whether the author is in the set, reading it again if it is stale.
+// A failed read is retried on the next lookup.
+func (a *Authors) Contains(authorID int) (bool, error) {
+	if now := a.now(); a.ids == nil || now.Sub(a.loadedAt) >= a.ttl {
+		ids, err := a.load()
+		if err != nil {
+			return false, err
+		}
+
+		a.ids = make(map[int]bool, len(ids))
+		for _, id := range ids {
+			a.ids[id] = true
+		}
+		a.loadedAt = now
+	}
+
+	return a.ids[authorID], nil
+}
@@ -0,0 +1,146 @@
+// Package stream broadcasts the chirp events to the connected clients.
+package stream
+
+import (
+	"sync"
+
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+const (
+	EventChirpCreated = "chirp.created"
+	EventChirpDeleted = "chirp.deleted"
+)
+
+const (
+	// DefaultHistorySize is the number of events kept to resume the streams.
+	DefaultHistorySize = 1000
+	// subscriberBuffer is the number of events a subscriber can lag behind before being dropped.
+	subscriberBuffer = 64
+)
+
+// Event is a chirp created or deleted. The ids increase with the events.
+type Event struct {
+	ID    int64
+	Type  string
+	Chirp db.Chirp
+}
+
+// Subscription receives the events published after it was made.
+type Subscription struct {
+	// Events is closed when the subscription is canceled, when the broker is closed,
+	// or when the subscriber lags too far behind and must resume from its last event.
+	Events <-chan Event
+	events chan Event
+	broker *Broker
+}
+
+// Cancel stops the subscription.
+func (s *Subscription) Cancel() {
+	s.broker.mux.Lock()
+	defer s.broker.mux.Unlock()
+
+	s.broker.drop(s)
+}
+
+// Broker publishes the chirp events to their subscribers, and keeps the last ones
+// so that a subscriber can resume after a disconnection.
+type Broker struct {
+	mux         sync.Mutex
+	lastID      int64
+	history     []Event
+	historySize int
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+// NewBroker returns a broker keeping the last historySize events, DefaultHistorySize if 0.
+func NewBroker(historySize int) *Broker {
+	if historySize == 0 {
+		historySize = DefaultHistorySize
+	}
+
+	return &Broker{historySize: historySize, subscribers: map[*Subscription]bool{}}
+}
+
+// Subscribe returns a subscription to the next events,
+// and the kept events published after the event lastEventID if not 0.
+// The subscription of a closed broker is already closed.
+func (b *Broker) Subscribe(lastEventID int64) (*Subscription, []Event) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	events := make(chan Event, subscriberBuffer)
+	sub := &Subscription{Events: events, events: events, broker: b}
+	if b.closed {
+		close(events)
+		return sub, nil
+	}
+	b.subscribers[sub] = true
+
+	var missed []Event
+	if lastEventID != 0 {
+		for _, event := range b.history {
+			if event.ID > lastEventID {
+				missed = append(missed, event)
+			}
+		}
+	}
+
+	return sub, missed
+}
+
+// ChirpCreated publishes the creation of a chirp.
+func (b *Broker) ChirpCreated(chirp db.Chirp) {
+	b.publish(EventChirpCreated, chirp)
+}
+
+// ChirpDeleted publishes the deletion of a chirp.
+func (b *Broker) ChirpDeleted(chirp db.Chirp) {
+	b.publish(EventChirpDeleted, chirp)
+}
+
+func (b *Broker) publish(eventType string, chirp db.Chirp) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	b.lastID++
+	event := Event{ID: b.lastID, Type: eventType, Chirp: chirp}
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			// a slow subscriber must not block the others, it resumes from its last event.
+			b.drop(sub)
+		}
+	}
+}
+
+// Close closes all the subscriptions and stops publishing, when the server shuts down.
+func (b *Broker) Close() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.closed = true
+	for sub := range b.subscribers {
+		b.drop(sub)
+	}
+}
+
+// drop closes a subscription. The caller must hold the lock.
+func (b *Broker) drop(sub *Subscription) {
+	if !b.subscribers[sub] {
+		return
+	}
+	delete(b.subscribers, sub)
+	close(sub.events)
+}
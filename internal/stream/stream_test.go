@@ -0,0 +1,98 @@
+package stream
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker(2)
+	broker.ChirpCreated(db.Chirp{ID: 1})
+
+	sub, missed := broker.Subscribe(0)
+	if len(missed) != 0 {
+		t.Errorf("Subscribe() missed = %v, want none without last event id", missed)
+	}
+	broker.ChirpCreated(db.Chirp{ID: 2})
+	broker.ChirpDeleted(db.Chirp{ID: 1})
+	if event := <-sub.Events; event.ID != 2 || event.Type != EventChirpCreated || event.Chirp.ID != 2 {
+		t.Errorf("Events = %v, want the creation of chirp 2", event)
+	}
+	if event := <-sub.Events; event.ID != 3 || event.Type != EventChirpDeleted {
+		t.Errorf("Events = %v, want the deletion of chirp 1", event)
+	}
+
+	// only the last two events are kept.
+	resumed, missed := broker.Subscribe(1)
+	if len(missed) != 2 || missed[0].ID != 2 || missed[1].ID != 3 {
+		t.Errorf("Subscribe() missed = %v, want the events 2 and 3", missed)
+	}
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		broker.ChirpCreated(db.Chirp{ID: 10 + i})
+	}
+	count := 0
+	for range resumed.Events {
+		count++
+	}
+	if count != subscriberBuffer {
+		t.Errorf("a lagging subscriber received %d events before being dropped, want %d", count, subscriberBuffer)
+	}
+
+	broker.Close()
+	// the first subscriber was dropped too, its events channel is closed once drained.
+	for range sub.Events {
+	}
+	closed, _ := broker.Subscribe(0)
+	if _, ok := <-closed.Events; ok {
+		t.Error("Subscribe() on a closed broker should return a closed subscription")
+	}
+}
+
+func TestAuthors(t *testing.T) {
+	now := time.Now()
+	loads, ids, loadErr := 0, []int{1}, error(nil)
+	authors := NewAuthors(func() ([]int, error) {
+		loads++
+		return ids, loadErr
+	}, time.Minute)
+	authors.now = func() time.Time { return now }
+
+	contains := func(authorID int, want bool) {
+		t.Helper()
+		if got, err := authors.Contains(authorID); err != nil || got != want {
+			t.Errorf("Contains(%d) = %v, %v, want %v", authorID, got, err, want)
+		}
+	}
+
+	contains(1, true)
+	contains(2, false)
+	if loads != 1 {
+		t.Errorf("loads = %d, want the set read once", loads)
+	}
+
+	// the changes are only read once the set is stale.
+	ids = []int{2}
+	contains(2, false)
+	now = now.Add(time.Minute)
+	contains(2, true)
+	contains(1, false)
+	if loads != 2 {
+		t.Errorf("loads = %d, want the set read again once", loads)
+	}
+
+	// a failed read is retried.
+	now = now.Add(time.Minute)
+	loadErr = errors.New("boom")
+	if _, err := authors.Contains(2); err == nil {
+		t.Error("Contains() expected the read error")
+	}
+	loadErr = nil
+	contains(2, true)
+	if loads != 4 {
+		t.Errorf("loads = %d, want the failed read retried", loads)
+	}
+}
@@ -0,0 +1,187 @@
+// Package timeline reads the home timelines of the users: their chirps and the chirps
+// of the users they follow, the most recent first.
+package timeline
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+// DefaultCacheSize is the number of chirps kept per precomputed timeline.
+const DefaultCacheSize = 500
+
+type Storer interface {
+	ListFollowedIDs(userID int) ([]int, error)
+	ListFollowerIDs(userID int) ([]int, error)
+	ListChirpsPage(page db.ChirpPage) ([]db.Chirp, bool, error)
+	GetChirp(id int) (*db.Chirp, error)
+	ListHiddenAuthorIDs(viewerID int) ([]int, error)
+}
+
+// Timeline reads the timelines with fan-out-on-read: the chirps of the followed users
+// are read from the store at each request.
+// The timelines of the users following at least cacheThreshold users are precomputed
+// on their first read, then kept current as chirps are created.
+type Timeline struct {
+	db             Storer
+	cacheThreshold int
+	cacheSize      int
+
+	mux sync.Mutex
+	// cache maps a user to the ids of the most recent chirps of their timeline, the most recent first.
+	cache map[int][]int
+}
+
+// New returns a timeline reader. A zero cacheThreshold disables the cache.
+func New(db Storer, cacheThreshold int) *Timeline {
+	return &Timeline{
+		db:             db,
+		cacheThreshold: cacheThreshold,
+		cacheSize:      DefaultCacheSize,
+		cache:          make(map[int][]int),
+	}
+}
+
+// Page returns the chirps of the timeline of a user older than the chirp beforeID,
+// 0 for the first page, and whether more chirps follow.
+func (t *Timeline) Page(userID, beforeID, limit int) ([]db.Chirp, bool, error) {
+	followed, err := t.db.ListFollowedIDs(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	// the users blocked or muted since they were followed are left out.
+	hidden, err := t.db.ListHiddenAuthorIDs(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	followed = slices.DeleteFunc(followed, func(id int) bool { return slices.Contains(hidden, id) })
+	authors := append(followed, userID)
+
+	if t.cacheThreshold == 0 || len(followed) < t.cacheThreshold {
+		return t.readPage(authors, beforeID, limit)
+	}
+
+	ids, err := t.cached(userID, authors)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the cache can still hold chirps deleted or hidden, or authors unfollowed, blocked or muted, since it was built.
+	isAuthor := make(map[int]bool, len(authors))
+	for _, id := range authors {
+		isAuthor[id] = true
+	}
+	var chirps []db.Chirp
+	for _, id := range ids {
+		if beforeID != 0 && id >= beforeID {
+			continue
+		}
+		chirp, err := t.db.GetChirp(id)
+		if err != nil || !isAuthor[chirp.AuthorID] || chirp.IsHidden() {
+			continue
+		}
+		if len(chirps) == limit {
+			return chirps, true, nil
+		}
+		chirps = append(chirps, *chirp)
+	}
+
+	// the cache only keeps the most recent chirps, the older ones are read from the store.
+	if len(ids) < t.cacheSize {
+		return chirps, false, nil
+	}
+	if len(chirps) == limit {
+		return chirps, true, nil
+	}
+	bound := ids[len(ids)-1]
+	if beforeID != 0 && beforeID < bound {
+		bound = beforeID
+	}
+	older, more, err := t.readPage(authors, bound, limit-len(chirps))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return append(chirps, older...), more, nil
+}
+
+// readPage reads a page of the timeline from the store.
+func (t *Timeline) readPage(authors []int, beforeID, limit int) ([]db.Chirp, bool, error) {
+	return t.db.ListChirpsPage(db.ChirpPage{
+		AuthorID:  -1,
+		AuthorIDs: authors,
+		Sort:      "desc",
+		AfterID:   beforeID,
+		Limit:     limit,
+	})
+}
+
+// cached returns the cached chirp ids of a timeline, building it if needed.
+func (t *Timeline) cached(userID int, authors []int) ([]int, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if ids, ok := t.cache[userID]; ok {
+		return slices.Clone(ids), nil
+	}
+
+	chirps, _, err := t.readPage(authors, 0, t.cacheSize)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	t.cache[userID] = ids
+
+	return slices.Clone(ids), nil
+}
+
+// Invalidate drops the cached timeline of a user, after they follow or unfollow someone.
+func (t *Timeline) Invalidate(userID int) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	delete(t.cache, userID)
+}
+
+// ChirpCreated adds a new chirp to the cached timelines of its author and their followers.
+func (t *Timeline) ChirpCreated(chirp db.Chirp) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if len(t.cache) == 0 {
+		return
+	}
+	followers, err := t.db.ListFollowerIDs(chirp.AuthorID)
+	if err != nil {
+		// the cached timelines cannot be updated anymore.
+		clear(t.cache)
+		return
+	}
+
+	for _, userID := range append(followers, chirp.AuthorID) {
+		ids, ok := t.cache[userID]
+		if !ok {
+			continue
+		}
+		ids = slices.Insert(ids, 0, chirp.ID)
+		if len(ids) > t.cacheSize {
+			ids = ids[:t.cacheSize]
+		}
+		t.cache[userID] = ids
+	}
+}
+
+// ChirpDeleted removes a deleted chirp from the cached timelines.
+func (t *Timeline) ChirpDeleted(chirp db.Chirp) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for userID, ids := range t.cache {
+		t.cache[userID] = slices.DeleteFunc(ids, func(id int) bool { return id == chirp.ID })
+	}
+}
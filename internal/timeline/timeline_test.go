@@ -0,0 +1,72 @@
+package timeline
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jbdoumenjou/mygoserver/internal/db"
+)
+
+func chirpIDs(chirps []db.Chirp) []int {
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	return ids
+}
+
+func TestTimeline_Cache(t *testing.T) {
+	store, err := db.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	var users []int
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		user, err := store.CreateUser(email, "hash")
+		if err != nil {
+			t.Fatalf("CreateUser should not have an error %v", err)
+		}
+		users = append(users, user.ID)
+	}
+	a, b, c := users[0], users[1], users[2]
+	if _, _, err := store.FollowUser(a, b); err != nil {
+		t.Fatalf("FollowUser should not have an error %v", err)
+	}
+
+	create := func(authorID int) db.Chirp {
+		chirp, err := store.CreateChirp(db.Chirp{Body: "hello", AuthorID: authorID})
+		if err != nil {
+			t.Fatalf("CreateChirp should not have an error %v", err)
+		}
+		return chirp
+	}
+	first, _, second := create(a), create(c), create(b)
+
+	timelines := New(store, 1)
+	timelines.cacheSize = 2
+	chirps, more, err := timelines.Page(a, 0, 10)
+	if err != nil || more || len(chirps) != 2 || chirps[0].ID != second.ID || chirps[1].ID != first.ID {
+		t.Fatalf("Page() = %v, %v, %v, want the chirps of b then a", chirpIDs(chirps), more, err)
+	}
+
+	third := create(b)
+	timelines.ChirpCreated(third)
+	timelines.ChirpCreated(create(c))
+	// the cache only keeps the two most recent chirps, the first one is read from the store.
+	chirps, _, _ = timelines.Page(a, 0, 10)
+	if got := chirpIDs(chirps); len(got) != 3 || got[0] != third.ID || got[1] != second.ID || got[2] != first.ID {
+		t.Errorf("Page() = %v, want [%d %d %d]", got, third.ID, second.ID, first.ID)
+	}
+	chirps, more, _ = timelines.Page(a, third.ID, 1)
+	if !more || len(chirps) != 1 || chirps[0].ID != second.ID {
+		t.Errorf("Page() after the third chirp = %v, %v, want the second chirp and more", chirpIDs(chirps), more)
+	}
+
+	if _, err := store.UnfollowUser(a, b); err != nil {
+		t.Fatalf("UnfollowUser should not have an error %v", err)
+	}
+	timelines.Invalidate(a)
+	if chirps, _, _ := timelines.Page(a, 0, 10); len(chirps) != 1 || chirps[0].ID != first.ID {
+		t.Errorf("Page() after unfollowing = %v, want [%d]", chirpIDs(chirps), first.ID)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jbdoumenjou/mygoserver/internal/moderation"
+)
+
+type SubscriptionExpirer interface {
+	ExpireSubscriptions(now time.Time) (int, error)
+}
+
+// expireSubscriptionsJob periodically expires the lapsed Chirpy Red subscriptions.
+func expireSubscriptionsJob(store SubscriptionExpirer, interval time.Duration) Job {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			expired, err := store.ExpireSubscriptions(time.Now().UTC())
+			if err != nil {
+				log.Printf("expire subscriptions: %v", err)
+			} else if expired > 0 {
+				log.Printf("expired %d subscriptions", expired)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// reloadModerationJob reloads the moderation word list when the process receives SIGHUP.
+func reloadModerationJob(filter *moderation.Filter) Job {
+	return func(ctx context.Context) {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				if err := filter.Reload(); err != nil {
+					log.Printf("reload moderation words: %v", err)
+				} else {
+					log.Printf("reloaded %d moderation words", filter.Words())
+				}
+			}
+		}
+	}
+}
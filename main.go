@@ -1,10 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jbdoumenjou/mygoserver/internal/api/chirp"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cors"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
 	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/blob"
+	"github.com/jbdoumenjou/mygoserver/internal/moderation"
+	"github.com/jbdoumenjou/mygoserver/internal/password"
+	"github.com/jbdoumenjou/mygoserver/internal/stream"
 
 	"github.com/jbdoumenjou/mygoserver/internal/db"
 	"github.com/joho/godotenv"
@@ -16,13 +27,186 @@ func main() {
 	}
 	jwtSecret := os.Getenv("JWT_SECRET")
 	apiKey := os.Getenv("API_KEY")
+	polkaWebhookSecret := os.Getenv("POLKA_WEBHOOK_SECRET")
+	cursorSecret := os.Getenv("CURSOR_SECRET")
+	if cursorSecret == "" {
+		cursorSecret = jwtSecret
+	}
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "media"
+	}
+	editWindow := chirp.DefaultEditWindow
+	if value := os.Getenv("CHIRP_EDIT_WINDOW"); value != "" {
+		var err error
+		if editWindow, err = time.ParseDuration(value); err != nil {
+			panic(fmt.Sprintf("invalid CHIRP_EDIT_WINDOW: %v", err))
+		}
+	}
+	timelineCacheThreshold := 0
+	if value := os.Getenv("TIMELINE_CACHE_THRESHOLD"); value != "" {
+		var err error
+		if timelineCacheThreshold, err = strconv.Atoi(value); err != nil || timelineCacheThreshold < 0 {
+			panic(fmt.Sprintf("invalid TIMELINE_CACHE_THRESHOLD %q", value))
+		}
+	}
+	deletedChirpPolicy := os.Getenv("DELETED_ACCOUNT_CHIRPS")
+	if deletedChirpPolicy != "" && deletedChirpPolicy != db.ChirpsDelete && deletedChirpPolicy != db.ChirpsAnonymize {
+		panic(fmt.Sprintf("unknown DELETED_ACCOUNT_CHIRPS %q", deletedChirpPolicy))
+	}
 
 	db, err := db.NewDB("database.json")
 	if err != nil {
 		panic(err)
 	}
 
-	tokenManager := token.NewManager(jwtSecret, apiKey)
-	router := NewRouter(db, tokenManager)
-	log.Fatal(NewWebServer(":8080", router).Start())
+	tokenManager := token.NewManager(jwtSecret, apiKey).WithWebhookSecret(polkaWebhookSecret)
+	passwords, err := newPasswordManager(os.Getenv("PASSWORD_HASHER"), os.Getenv("BCRYPT_COST"))
+	if err != nil {
+		panic(err)
+	}
+
+	passwordPolicy, err := newPasswordPolicy(
+		os.Getenv("PASSWORD_MIN_LENGTH"),
+		os.Getenv("PASSWORD_REQUIRED_CLASSES"),
+		os.Getenv("PASSWORD_HISTORY"),
+		os.Getenv("BREACHED_PASSWORDS_FILE"),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	moderationFilter, err := newModerationFilter(os.Getenv("MODERATION_WORDS_FILE"), os.Getenv("MODERATION_MASK"))
+	if err != nil {
+		panic(err)
+	}
+
+	if err := grantAdmins(db, os.Getenv("ADMIN_EMAILS")); err != nil {
+		panic(err)
+	}
+
+	broker := stream.NewBroker(0)
+	router := NewRouter(db, tokenManager,
+		WithPasswordManager(passwords),
+		WithPasswordPolicy(passwordPolicy),
+		WithDeletedChirpPolicy(deletedChirpPolicy),
+		WithBlobStore(blob.NewFileStore(mediaDir)),
+		WithCursorSigner(cursor.NewSigner(cursorSecret)),
+		WithChirpEditWindow(editWindow),
+		WithTimelineCacheThreshold(timelineCacheThreshold),
+		WithStreamBroker(broker),
+		WithModerationFilter(moderationFilter),
+		WithCORSPolicy(newCORSPolicy(os.Getenv("CORS_ALLOWED_ORIGINS"))),
+	)
+	server := NewWebServer(":8080", router).
+		AddJob(expireSubscriptionsJob(db, time.Hour)).
+		AddJob(reloadModerationJob(moderationFilter))
+	// the streams and websockets never become idle, they are ended for the server to shut down.
+	server.RegisterOnShutdown(broker.Close)
+	log.Fatal(server.Start())
+}
+
+// grantAdmins grants the admin role to the users of a comma separated list of emails.
+func grantAdmins(store *db.DB, emails string) error {
+	for _, email := range strings.Split(emails, ",") {
+		if email = strings.TrimSpace(email); email == "" {
+			continue
+		}
+		if err := store.GrantRole(email, token.RoleAdmin); err != nil {
+			return fmt.Errorf("grant admin role to %s: %w", email, err)
+		}
+	}
+
+	return nil
+}
+
+// newCORSPolicy returns the policy of a comma separated list of origins, any origin when none is set.
+func newCORSPolicy(origins string) *cors.Policy {
+	var allowed []string
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowed = append(allowed, origin)
+		}
+	}
+	return cors.NewPolicy(allowed...)
+}
+
+// newModerationFilter returns the moderation filter of the words listed in path,
+// or of the default words when no path is set.
+func newModerationFilter(path, mask string) (*moderation.Filter, error) {
+	if path == "" {
+		return moderation.NewFilter(moderation.DefaultWords, mask)
+	}
+	return moderation.LoadFilter(path, mask)
+}
+
+// newPasswordManager returns a password manager hashing with the given algorithm,
+// bcrypt by default, and still verifying the hashes of the other algorithm.
+func newPasswordManager(hasher, bcryptCost string) (*password.Manager, error) {
+	cost := 0
+	if bcryptCost != "" {
+		var err error
+		if cost, err = strconv.Atoi(bcryptCost); err != nil {
+			return nil, fmt.Errorf("invalid BCRYPT_COST: %w", err)
+		}
+	}
+
+	bcryptHasher := password.NewBcrypt(cost)
+	argon2idHasher := password.NewArgon2id(password.Argon2idParams{})
+
+	switch hasher {
+	case "", "bcrypt":
+		return password.NewManager(bcryptHasher, argon2idHasher), nil
+	case "argon2id":
+		return password.NewManager(argon2idHasher, bcryptHasher), nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", hasher)
+	}
+}
+
+// newPasswordPolicy returns the default password policy overridden by the non-empty settings.
+// requiredClasses is a comma separated list of upper, lower, digit and symbol.
+func newPasswordPolicy(minLength, requiredClasses, history, breachedFile string) (password.Policy, error) {
+	policy := password.DefaultPolicy()
+
+	if minLength != "" {
+		var err error
+		if policy.MinLength, err = strconv.Atoi(minLength); err != nil {
+			return password.Policy{}, fmt.Errorf("invalid PASSWORD_MIN_LENGTH: %w", err)
+		}
+	}
+
+	if history != "" {
+		var err error
+		if policy.HistorySize, err = strconv.Atoi(history); err != nil {
+			return password.Policy{}, fmt.Errorf("invalid PASSWORD_HISTORY: %w", err)
+		}
+	}
+
+	if requiredClasses != "" {
+		for _, class := range strings.Split(requiredClasses, ",") {
+			switch strings.TrimSpace(class) {
+			case "upper":
+				policy.RequireUpper = true
+			case "lower":
+				policy.RequireLower = true
+			case "digit":
+				policy.RequireDigit = true
+			case "symbol":
+				policy.RequireSymbol = true
+			default:
+				return password.Policy{}, fmt.Errorf("unknown password class %q", class)
+			}
+		}
+	}
+
+	if breachedFile != "" {
+		corpus, err := password.OpenCorpus(breachedFile)
+		if err != nil {
+			return password.Policy{}, err
+		}
+		policy.Breached = corpus
+	}
+
+	return policy, nil
 }
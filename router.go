@@ -1,15 +1,35 @@
 package main
 
 import (
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jbdoumenjou/mygoserver/internal/api"
+	"github.com/jbdoumenjou/mygoserver/internal/api/account"
+	"github.com/jbdoumenjou/mygoserver/internal/api/block"
 	"github.com/jbdoumenjou/mygoserver/internal/api/chirp"
 	"github.com/jbdoumenjou/mygoserver/internal/api/cors"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/api/follow"
 	"github.com/jbdoumenjou/mygoserver/internal/api/health"
+	"github.com/jbdoumenjou/mygoserver/internal/api/like"
 	"github.com/jbdoumenjou/mygoserver/internal/api/metrics"
+	"github.com/jbdoumenjou/mygoserver/internal/api/notification"
+	"github.com/jbdoumenjou/mygoserver/internal/api/pat"
+	"github.com/jbdoumenjou/mygoserver/internal/api/report"
+	"github.com/jbdoumenjou/mygoserver/internal/api/sse"
 	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/api/upload"
 	"github.com/jbdoumenjou/mygoserver/internal/api/user"
+	"github.com/jbdoumenjou/mygoserver/internal/api/ws"
+	"github.com/jbdoumenjou/mygoserver/internal/blob"
+	"github.com/jbdoumenjou/mygoserver/internal/moderation"
+	"github.com/jbdoumenjou/mygoserver/internal/password"
+	"github.com/jbdoumenjou/mygoserver/internal/search"
+	"github.com/jbdoumenjou/mygoserver/internal/stream"
+	"github.com/jbdoumenjou/mygoserver/internal/timeline"
 )
 
 type ApiConfig struct {
@@ -18,17 +38,143 @@ type ApiConfig struct {
 
 type Storer interface {
 	chirp.ChirpStorer
+	like.LikeStorer
+	follow.FollowStorer
+	block.BlockStorer
+	notification.NotificationStorer
+	report.ReportStorer
 	user.UserStorer
+	pat.PersonalAccessTokenStorer
+	account.AccountStorer
+	upload.MediaStorer
 }
 
-func NewRouter(db Storer, tokenManager *token.Manager) http.Handler {
+// RouterOption customizes the router.
+type RouterOption func(*routerOptions)
+
+type routerOptions struct {
+	passwords      *password.Manager
+	passwordPolicy password.Policy
+	chirpPolicy    string
+	blobs          blob.BlobStore
+	cursors        *cursor.Signer
+	editWindow     time.Duration
+	timelineCache  int
+	broker         *stream.Broker
+	moderation     *moderation.Filter
+	origins        *cors.Policy
+}
+
+// WithPasswordManager sets the password manager used to hash and verify the passwords.
+func WithPasswordManager(passwords *password.Manager) RouterOption {
+	return func(o *routerOptions) {
+		o.passwords = passwords
+	}
+}
+
+// WithPasswordPolicy sets the policy new passwords must follow.
+func WithPasswordPolicy(policy password.Policy) RouterOption {
+	return func(o *routerOptions) {
+		o.passwordPolicy = policy
+	}
+}
+
+// WithDeletedChirpPolicy sets what happens to the chirps of a deleted account,
+// see db.ChirpsDelete and db.ChirpsAnonymize.
+func WithDeletedChirpPolicy(policy string) RouterOption {
+	return func(o *routerOptions) {
+		o.chirpPolicy = policy
+	}
+}
+
+// WithBlobStore sets where the uploaded media are stored.
+func WithBlobStore(blobs blob.BlobStore) RouterOption {
+	return func(o *routerOptions) {
+		o.blobs = blobs
+	}
+}
+
+// WithCursorSigner sets the signer of the pagination cursors.
+func WithCursorSigner(cursors *cursor.Signer) RouterOption {
+	return func(o *routerOptions) {
+		o.cursors = cursors
+	}
+}
+
+// WithChirpEditWindow sets how long after their creation the chirps can be edited.
+// A zero window disables editing.
+func WithChirpEditWindow(window time.Duration) RouterOption {
+	return func(o *routerOptions) {
+		o.editWindow = window
+	}
+}
+
+// WithTimelineCacheThreshold precomputes the home timelines of the users following
+// at least threshold users. A zero threshold disables the cache.
+func WithTimelineCacheThreshold(threshold int) RouterOption {
+	return func(o *routerOptions) {
+		o.timelineCache = threshold
+	}
+}
+
+// WithStreamBroker sets the broker of the chirp events streamed to the clients.
+// The broker must be closed when the server shuts down, to end the streams.
+func WithStreamBroker(broker *stream.Broker) RouterOption {
+	return func(o *routerOptions) {
+		o.broker = broker
+	}
+}
+
+// WithModerationFilter sets the filter masking the forbidden words of the chirps.
+func WithModerationFilter(filter *moderation.Filter) RouterOption {
+	return func(o *routerOptions) {
+		o.moderation = filter
+	}
+}
+
+// WithCORSPolicy sets the origins allowed to call the API from a browser, and to open websockets.
+func WithCORSPolicy(origins *cors.Policy) RouterOption {
+	return func(o *routerOptions) {
+		o.origins = origins
+	}
+}
+
+func NewRouter(db Storer, tokenManager *token.Manager, opts ...RouterOption) http.Handler {
+	options := routerOptions{
+		passwords:      password.Default(),
+		passwordPolicy: password.DefaultPolicy(),
+		blobs:          blob.NewFileStore("media"),
+		editWindow:     chirp.DefaultEditWindow,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.cursors == nil {
+		options.cursors = cursor.NewSigner("")
+	}
+	if options.broker == nil {
+		options.broker = stream.NewBroker(0)
+	}
+	if options.origins == nil {
+		options.origins = cors.NewPolicy()
+	}
+
 	router := chi.NewRouter()
 	apiMetrics := &metrics.Metrics{}
+	authenticator := api.NewAuthenticator(tokenManager, db)
 
 	// Admin routes
 	adminRouter := chi.NewRouter()
 	adminRouter.Get("/metrics", apiMetrics.HTMLHandler)
 
+	// the moderation routes are restricted to the admins.
+	reportHandler := report.NewHandler(db, options.cursors).WithListener(options.broker)
+	moderationRouter := adminRouter.With(authenticator.Required, api.RequireRole(token.RoleAdmin))
+	moderationRouter.Get("/reports", reportHandler.Queue)
+	moderationRouter.Get("/reports/{id}", reportHandler.Get)
+	moderationRouter.Post("/decisions", reportHandler.Decide)
+	moderationRouter.Get("/decisions", reportHandler.Decisions)
+
 	router.Mount("/admin", adminRouter)
 
 	// Serve static files from the . directory
@@ -43,21 +189,116 @@ func NewRouter(db Storer, tokenManager *token.Manager) http.Handler {
 	apiRouter.Get("/metrics", apiMetrics.TextHandler)
 	apiRouter.Get("/reset", apiMetrics.ResetHandler)
 
-	chirpHandler := chirp.NewHandler(db, tokenManager)
-	apiRouter.Get("/chirps", chirpHandler.List)
-	apiRouter.Get("/chirps/{id}", chirpHandler.Get)
-	apiRouter.Delete("/chirps/{id}", chirpHandler.Delete)
-	apiRouter.Post("/chirps", chirpHandler.Create)
+	// authRequired routes reject anonymous requests,
+	// authOptional routes accept them but still read a provided token.
+	// Both reject suspended users, only accepted by the authSuspended routes to see and appeal their suspension,
+	// and deleted users, only accepted by the authDeleted routes to retry the deletion.
+	authRequired := apiRouter.With(authenticator.Required)
+	authOptional := apiRouter.With(authenticator.Optional)
+	authSuspended := apiRouter.With(authenticator.AllowSuspended().Required)
+	authDeleted := apiRouter.With(authenticator.AllowDeleted().Required)
+
+	// the search index is built from the stored chirps, and kept current by the chirp handler.
+	searchIndex := search.NewIndex()
+	chirps, err := db.ListChirps(-1, 0, chirp.SortAsc)
+	if err != nil {
+		log.Printf("index chirps: %v", err)
+	}
+	for _, indexed := range chirps {
+		searchIndex.Add(indexed.ID, indexed.Body)
+	}
+
+	timelines := timeline.New(db, options.timelineCache)
+	chirpHandler := chirp.NewHandler(db, options.blobs, options.cursors, searchIndex).
+		WithEditWindow(options.editWindow).
+		WithTimeline(timelines).
+		WithListener(options.broker)
+	if options.moderation != nil {
+		chirpHandler.WithModeration(options.moderation)
+	}
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/chirps", chirpHandler.List)
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/chirps/search", chirpHandler.Search)
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/chirps/{id}", chirpHandler.Get)
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/chirps/{id}/history", chirpHandler.History)
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/chirps/{id}/thread", chirpHandler.Thread)
+	authRequired.With(api.RequireScope(token.ScopeChirpsWrite)).Put("/chirps/{id}", chirpHandler.Update)
+	authRequired.With(api.RequireScope(token.ScopeChirpsWrite)).Post("/chirps/{id}/rechirp", chirpHandler.Rechirp)
+	authRequired.With(api.RequireScope(token.ScopeChirpsWrite)).Delete("/chirps/{id}", chirpHandler.Delete)
+	authRequired.With(api.RequireScope(token.ScopeChirpsWrite)).Post("/chirps", chirpHandler.Create)
+	authRequired.With(api.RequireScope(token.ScopeChirpsRead)).Get("/timeline", chirpHandler.Timeline)
+	streamHandler := sse.NewHandler(db, options.broker)
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/stream", streamHandler.Stream)
+	wsHandler := ws.NewHandler(db, options.broker).
+		WithOrigins(options.origins).
+		WithPrincipalCheck(authenticator.Check)
+	apiRouter.With(authenticator.Required, api.RequireScope(token.ScopeChirpsRead)).Post("/ws/tickets", wsHandler.CreateTicket)
+	apiRouter.With(wsHandler.Authenticate(authenticator.Required), api.RequireScope(token.ScopeChirpsRead)).Get("/ws", wsHandler.Serve)
 
-	userHandler := user.NewHandler(db, tokenManager)
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/tags/{tag}", chirpHandler.Tag)
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/trending/tags", chirpHandler.Trending)
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/users/{id}/mentions", chirpHandler.Mentions)
+
+	likeHandler := like.NewHandler(db, options.cursors).WithVisibility(chirpHandler.CanSee)
+	authRequired.With(api.RequireScope(token.ScopeChirpsWrite)).Put("/chirps/{id}/like", likeHandler.Like)
+	authRequired.With(api.RequireScope(token.ScopeChirpsWrite)).Delete("/chirps/{id}/like", likeHandler.Unlike)
+	authOptional.With(api.RequireScope(token.ScopeChirpsRead)).Get("/chirps/{id}/likes", likeHandler.Likers)
+
+	userHandler := user.NewHandler(db, tokenManager, options.passwords, options.passwordPolicy)
 	apiRouter.Post("/users", userHandler.Create)
-	apiRouter.Put("/users", userHandler.Update)
+	apiRouter.Get("/users/{id}", userHandler.Get)
+	apiRouter.Get("/users/@{handle}", userHandler.GetByHandle)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Put("/users", userHandler.Update)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Patch("/users", userHandler.Patch)
 	apiRouter.Post("/login", userHandler.Login)
 	apiRouter.Post("/refresh", userHandler.Refresh)
 	apiRouter.Post("/revoke", userHandler.Revoke)
 	apiRouter.Post("/polka/webhooks", userHandler.Upgrade)
 
+	followHandler := follow.NewHandler(db, options.cursors, timelines)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Put("/users/{id}/follow", followHandler.Follow)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Delete("/users/{id}/follow", followHandler.Unfollow)
+	apiRouter.Get("/users/{id}/followers", followHandler.Followers)
+	apiRouter.Get("/users/{id}/following", followHandler.Following)
+
+	blockHandler := block.NewHandler(db, options.cursors, timelines)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Put("/users/{id}/block", blockHandler.Block)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Delete("/users/{id}/block", blockHandler.Unblock)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Put("/users/{id}/mute", blockHandler.Mute)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Delete("/users/{id}/mute", blockHandler.Unmute)
+	authRequired.With(api.RequireScope(token.ScopeProfileRead)).Get("/users/me/blocks", blockHandler.Blocks)
+	authRequired.With(api.RequireScope(token.ScopeProfileRead)).Get("/users/me/mutes", blockHandler.Mutes)
+
+	notificationHandler := notification.NewHandler(db, options.cursors)
+	authRequired.With(api.RequireScope(token.ScopeProfileRead)).Get("/notifications", notificationHandler.List)
+	authRequired.With(api.RequireScope(token.ScopeProfileRead)).Get("/notifications/unread", notificationHandler.Unread)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Post("/notifications/read", notificationHandler.Read)
+	authRequired.With(api.RequireScope(token.ScopeProfileRead)).Get("/notifications/preferences", notificationHandler.GetPreferences)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Put("/notifications/preferences", notificationHandler.UpdatePreferences)
+
+	authRequired.With(api.RequireScope(token.ScopeChirpsWrite)).Post("/reports", reportHandler.Create)
+	authSuspended.Get("/users/me/suspension", reportHandler.Suspension)
+	authSuspended.Post("/users/me/suspension/appeal", reportHandler.Appeal)
+
+	accountHandler := account.NewHandler(db, options.blobs, options.passwords, options.chirpPolicy).
+		WithSearchIndex(searchIndex).
+		WithListener(timelines).
+		WithListener(options.broker)
+	authRequired.With(api.RequireScope(token.ScopeProfileRead)).Get("/users/me/export", accountHandler.Export)
+	authDeleted.With(api.RequireScope(token.ScopeProfileWrite)).Delete("/users/me", accountHandler.Delete)
+	moderationRouter.Get("/account-deletions", accountHandler.Deletions)
+
+	uploadHandler := upload.NewHandler(db, options.blobs)
+	authRequired.With(api.RequireScope(token.ScopeProfileWrite)).Post("/users/me/avatar", uploadHandler.Avatar)
+	authRequired.With(api.RequireScope(token.ScopeChirpsWrite)).Post("/media", uploadHandler.Create)
+	authOptional.Get("/media/{id}", uploadHandler.Get)
+	authOptional.Get("/media/{id}/thumbnail", uploadHandler.GetThumbnail)
+
+	patHandler := pat.NewHandler(db)
+	authRequired.Post("/tokens", patHandler.Create)
+	authRequired.Get("/tokens", patHandler.List)
+	authRequired.Delete("/tokens/{id}", patHandler.Revoke)
+
 	router.Mount("/api", apiRouter)
 
-	return cors.Middleware(router)
+	return options.origins.Middleware(router)
 }
@@ -1,16 +1,39 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"image"
+	pngenc "image/png"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
+	"github.com/jbdoumenjou/mygoserver/internal/api/block"
+	"github.com/jbdoumenjou/mygoserver/internal/api/chirp"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cors"
+	"github.com/jbdoumenjou/mygoserver/internal/api/cursor"
+	"github.com/jbdoumenjou/mygoserver/internal/api/like"
+	"github.com/jbdoumenjou/mygoserver/internal/api/report"
 	"github.com/jbdoumenjou/mygoserver/internal/api/token"
+	"github.com/jbdoumenjou/mygoserver/internal/api/ws"
+	"github.com/jbdoumenjou/mygoserver/internal/blob"
 
 	"github.com/jbdoumenjou/mygoserver/internal/db"
+	"github.com/jbdoumenjou/mygoserver/internal/entity"
+	"github.com/jbdoumenjou/mygoserver/internal/moderation"
+	"github.com/jbdoumenjou/mygoserver/internal/password"
+	"github.com/jbdoumenjou/mygoserver/internal/stream"
 )
 
 func TestAdminMetricsRoute(t *testing.T) {
@@ -89,25 +112,116 @@ func TestAdminMetricsRoute(t *testing.T) {
 }
 
 type MockDB struct {
-	Chirps []db.Chirp
+	Chirps               []db.Chirp
+	Tombstones           []db.ChirpTombstone
+	Likes                []db.Like
+	Follows              []db.Follow
+	Notifications        []db.Notification
+	MutedNotifications   []string
+	PersonalAccessTokens []db.PersonalAccessToken
+	WebhookEvents        map[string]bool
+	UpgradedUsers        []int
+	Media                []db.Media
+	Reports              []db.Report
+	Decisions            []db.Decision
+	Suspensions          map[int]*db.Suspension
+	Blocks               []db.Block
+	Mutes                []db.Mute
 }
 
-func (m *MockDB) UpgradeUser(id int) error {
+func (m *MockDB) CreateMedia(media db.Media) (db.Media, error) {
+	media.ID = len(m.Media) + 1
+	m.Media = append(m.Media, media)
+	return media, nil
+}
+
+func (m *MockDB) GetMedia(id int) (*db.Media, error) {
+	for _, media := range m.Media {
+		if media.ID == id {
+			return &media, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (m *MockDB) DeleteMedia(id int) error {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockDB) ListMedia(ownerID int) ([]db.Media, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockDB) SetUserAvatar(userID, mediaID int) (int, error) {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (m *MockDB) DeleteChirp(id int) {
+func (m *MockDB) ClaimWebhookEvent(id string) (bool, error) {
+	if m.WebhookEvents[id] {
+		return false, nil
+	}
+	m.WebhookEvents[id] = true
+	return true, nil
+}
+
+func (m *MockDB) ReleaseWebhookEvent(id string) error {
+	delete(m.WebhookEvents, id)
+	return nil
+}
+
+func (m *MockDB) CreatePersonalAccessToken(userID int, name, hash string, scopes []string) (db.PersonalAccessToken, error) {
+	pat := db.PersonalAccessToken{ID: len(m.PersonalAccessTokens) + 1, UserID: userID, Name: name, Hash: hash, Scopes: scopes}
+	m.PersonalAccessTokens = append(m.PersonalAccessTokens, pat)
+	return pat, nil
+}
+
+func (m *MockDB) ListPersonalAccessTokens(userID int) ([]db.PersonalAccessToken, error) {
+	return m.PersonalAccessTokens, nil
+}
+
+func (m *MockDB) GetPersonalAccessTokenByHash(hash string) (*db.PersonalAccessToken, error) {
+	for _, pat := range m.PersonalAccessTokens {
+		if pat.Hash == hash {
+			return &pat, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (m *MockDB) RevokePersonalAccessToken(userID, id int) error {
 	//TODO implement me
 	panic("implement me")
 }
 
+func (m *MockDB) ApplySubscriptionEvent(userID int, event string, periodEnd time.Time) error {
+	m.UpgradedUsers = append(m.UpgradedUsers, userID)
+	return nil
+}
+
+func (m *MockDB) DeleteChirp(id int) ([]db.Chirp, []db.Media, error) {
+	var deleted []db.Chirp
+	m.Chirps = slices.DeleteFunc(m.Chirps, func(chirp db.Chirp) bool {
+		if chirp.ID != id && chirp.RechirpOfID != id {
+			return false
+		}
+		deleted = append(deleted, chirp)
+		return true
+	})
+	if len(deleted) == 0 {
+		return nil, nil, db.ErrNotFound
+	}
+	return deleted, nil, nil
+}
+
 func (m *MockDB) CreateUser(username, password string) (db.User, error) {
 	//TODO implement me
 	panic("implement me")
 }
 
-func (m *MockDB) UpdateUser(id int, email, password string) (db.User, error) {
+func (m *MockDB) PatchUser(id int, update db.UserUpdate) (db.User, error) {
 	//TODO implement me
 	panic("implement me")
 }
@@ -117,11 +231,45 @@ func (m *MockDB) GetUserByEmail(email string) (*db.User, error) {
 	panic("implement me")
 }
 
+func (m *MockDB) GetUserByHandle(handle string) (*db.User, error) {
+	if strings.EqualFold(handle, "someone") {
+		return &db.User{ID: 1, Email: "someone@example.com", Handle: "Someone"}, nil
+	}
+	return nil, db.ErrNotFound
+}
+
+func (m *MockDB) RehashPassword(id int, password string) error {
+	//TODO implement me
+	panic("implement me")
+}
+
 func (m *MockDB) GetUser(id int) (*db.User, error) {
+	return &db.User{ID: id, Email: "user@example.com", Suspension: m.Suspensions[id]}, nil
+}
+
+func (m *MockDB) CreateSession(userID int, id string, expiresAt time.Time) (db.Session, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockDB) ListSessions(userID int) ([]db.Session, error) {
+	//TODO implement me
+	panic("implement me")
+}
+
+func (m *MockDB) DeleteUser(id int, chirpPolicy string) (db.AccountDeletion, []db.Chirp, error) {
 	//TODO implement me
 	panic("implement me")
 }
 
+func (m *MockDB) GetAccountDeletion(userID int) (*db.AccountDeletion, error) {
+	return nil, db.ErrNotFound
+}
+
+func (m *MockDB) ListAccountDeletions() ([]db.AccountDeletion, error) {
+	return []db.AccountDeletion{}, nil
+}
+
 func (m *MockDB) RevokeToken(token string) string {
 	//TODO implement me
 	panic("implement me")
@@ -133,17 +281,420 @@ func (m *MockDB) IsTokenRevoked(token string) bool {
 }
 
 func NewMockDB() *MockDB {
-	return &MockDB{Chirps: []db.Chirp{}}
+	return &MockDB{Chirps: []db.Chirp{}, WebhookEvents: map[string]bool{}}
 }
 
-func (m *MockDB) CreateChirp(body string, authorID int) (db.Chirp, error) {
-	chirp := db.Chirp{ID: 1, AuthorID: 1, Body: body}
+func (m *MockDB) CreateChirp(params db.Chirp) (db.Chirp, error) {
+	if m.Suspensions[params.AuthorID].IsActive(time.Now()) {
+		return db.Chirp{}, db.ErrSuspended
+	}
+	for _, attachment := range params.Attachments {
+		if _, err := m.GetMedia(attachment.MediaID); err != nil {
+			return db.Chirp{}, db.ErrInvalidAttachment
+		}
+	}
+	if params.QuoteOfID != 0 {
+		if _, err := m.GetChirp(params.QuoteOfID); err != nil {
+			return db.Chirp{}, db.ErrInvalidQuote
+		}
+	}
+	chirp := db.Chirp{ID: 1, AuthorID: 1, Body: params.Body, Attachments: params.Attachments, QuoteOfID: params.QuoteOfID}
 	m.Chirps = append(m.Chirps, chirp)
 	return chirp, nil
 }
 
-func (m *MockDB) ListChirps() ([]db.Chirp, error) {
-	return m.Chirps, nil
+func (m *MockDB) Rechirp(userID, chirpID int) (db.Chirp, bool, error) {
+	if _, err := m.GetChirp(chirpID); err != nil {
+		return db.Chirp{}, false, err
+	}
+	for _, chirp := range m.Chirps {
+		if chirp.AuthorID == userID && chirp.RechirpOfID == chirpID {
+			return chirp, false, nil
+		}
+	}
+	rechirp := db.Chirp{ID: len(m.Chirps) + 1, AuthorID: userID, RechirpOfID: chirpID}
+	m.Chirps = append(m.Chirps, rechirp)
+	return rechirp, true, nil
+}
+
+func (m *MockDB) ListChirps(authorId, viewerID int, sort string) ([]db.Chirp, error) {
+	hidden, _ := m.ListHiddenAuthorIDs(viewerID)
+	var chirps []db.Chirp
+	for _, chirp := range m.Chirps {
+		if !slices.Contains(hidden, chirp.AuthorID) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	return chirps, nil
+}
+
+func (m *MockDB) ListChirpsPage(page db.ChirpPage) ([]db.Chirp, bool, error) {
+	hidden, _ := m.ListHiddenAuthorIDs(page.ViewerID)
+	var chirps []db.Chirp
+	for _, chirp := range m.Chirps {
+		if page.AuthorIDs != nil && !slices.Contains(page.AuthorIDs, chirp.AuthorID) {
+			continue
+		}
+		if slices.Contains(hidden, chirp.AuthorID) {
+			continue
+		}
+		if page.Tag != "" && !slices.ContainsFunc(chirp.Entities, func(e entity.Entity) bool { return e.Tag == page.Tag }) {
+			continue
+		}
+		if page.Sort == "desc" {
+			if page.AfterID == 0 || chirp.ID < page.AfterID {
+				chirps = append([]db.Chirp{chirp}, chirps...)
+			}
+			continue
+		}
+		if chirp.ID > page.AfterID && (page.BeforeID == 0 || chirp.ID < page.BeforeID) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	if len(chirps) > page.Limit {
+		return chirps[:page.Limit], true, nil
+	}
+	return chirps, false, nil
+}
+
+func (m *MockDB) UpdateChirp(id int, body string) (db.Chirp, error) {
+	for i, chirp := range m.Chirps {
+		if chirp.ID == id {
+			now := time.Now()
+			m.Chirps[i].Body = body
+			m.Chirps[i].EditedAt = &now
+			return m.Chirps[i], nil
+		}
+	}
+	return db.Chirp{}, db.ErrNotFound
+}
+
+func (m *MockDB) ListChirpRevisions(id int) ([]db.ChirpRevision, error) {
+	if _, err := m.GetChirp(id); err != nil {
+		return nil, err
+	}
+	return []db.ChirpRevision{}, nil
+}
+
+func (m *MockDB) GetConversation(id int) (db.Conversation, error) {
+	chirp, err := m.GetChirp(id)
+	if err != nil {
+		return db.Conversation{}, err
+	}
+	conversation := db.Conversation{ID: chirp.ID}
+	if chirp.ConversationID != 0 {
+		conversation.ID = chirp.ConversationID
+	}
+	for _, c := range m.Chirps {
+		if c.ID == conversation.ID || c.ConversationID == conversation.ID {
+			conversation.Chirps = append(conversation.Chirps, c)
+		}
+	}
+	conversation.Deleted = m.Tombstones
+	return conversation, nil
+}
+
+func (m *MockDB) CountReplies(ids []int) (map[int]int, error) {
+	counts := map[int]int{}
+	for _, c := range m.Chirps {
+		if c.ReplyToID != 0 && slices.Contains(ids, c.ReplyToID) {
+			counts[c.ReplyToID]++
+		}
+	}
+	return counts, nil
+}
+
+func (m *MockDB) TrendingTags(since time.Time, limit int) ([]db.TagCount, error) {
+	counts := map[string]int{}
+	for _, chirp := range m.Chirps {
+		for _, e := range chirp.Entities {
+			counts[e.Tag]++
+		}
+	}
+	var tags []db.TagCount
+	for tag, count := range counts {
+		tags = append(tags, db.TagCount{Tag: tag, Count: count})
+	}
+	slices.SortFunc(tags, func(i, j db.TagCount) int { return j.Count - i.Count })
+	return tags, nil
+}
+
+func (m *MockDB) LikeChirp(userID, chirpID int) (db.Like, bool, error) {
+	if _, err := m.GetChirp(chirpID); err != nil {
+		return db.Like{}, false, err
+	}
+	for _, like := range m.Likes {
+		if like.UserID == userID && like.ChirpID == chirpID {
+			return like, false, nil
+		}
+	}
+	like := db.Like{ID: len(m.Likes) + 1, UserID: userID, ChirpID: chirpID, CreatedAt: time.Now()}
+	m.Likes = append(m.Likes, like)
+	return like, true, nil
+}
+
+func (m *MockDB) UnlikeChirp(userID, chirpID int) (bool, error) {
+	if _, err := m.GetChirp(chirpID); err != nil {
+		return false, err
+	}
+	for i, like := range m.Likes {
+		if like.UserID == userID && like.ChirpID == chirpID {
+			m.Likes = slices.Delete(m.Likes, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDB) CountLikes(chirpIDs []int) (map[int]int, error) {
+	counts := map[int]int{}
+	for _, like := range m.Likes {
+		counts[like.ChirpID]++
+	}
+	return counts, nil
+}
+
+func (m *MockDB) ListLikedChirps(userID int, chirpIDs []int) (map[int]bool, error) {
+	liked := map[int]bool{}
+	for _, like := range m.Likes {
+		if like.UserID == userID {
+			liked[like.ChirpID] = true
+		}
+	}
+	return liked, nil
+}
+
+func (m *MockDB) ListLikes(chirpID, beforeID, limit int) ([]db.Like, bool, error) {
+	var likes []db.Like
+	for i := len(m.Likes) - 1; i >= 0; i-- {
+		like := m.Likes[i]
+		if like.ChirpID == chirpID && (beforeID == 0 || like.ID < beforeID) {
+			likes = append(likes, like)
+		}
+	}
+	if len(likes) > limit {
+		return likes[:limit], true, nil
+	}
+	return likes, false, nil
+}
+
+func (m *MockDB) FollowUser(followerID, followeeID int) (db.Follow, bool, error) {
+	if followerID == followeeID {
+		return db.Follow{}, false, db.ErrSelfFollow
+	}
+	if blocked, _ := m.IsBlocked(followerID, followeeID); blocked {
+		return db.Follow{}, false, db.ErrBlocked
+	}
+	for _, follow := range m.Follows {
+		if follow.FollowerID == followerID && follow.FolloweeID == followeeID {
+			return follow, false, nil
+		}
+	}
+	follow := db.Follow{ID: len(m.Follows) + 1, FollowerID: followerID, FolloweeID: followeeID, CreatedAt: time.Now()}
+	m.Follows = append(m.Follows, follow)
+	return follow, true, nil
+}
+
+func (m *MockDB) UnfollowUser(followerID, followeeID int) (bool, error) {
+	for i, follow := range m.Follows {
+		if follow.FollowerID == followerID && follow.FolloweeID == followeeID {
+			m.Follows = slices.Delete(m.Follows, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDB) BlockUser(blockerID, blockedID int) (db.Block, bool, error) {
+	if blockerID == blockedID {
+		return db.Block{}, false, db.ErrSelfBlock
+	}
+	for _, block := range m.Blocks {
+		if block.BlockerID == blockerID && block.BlockedID == blockedID {
+			return block, false, nil
+		}
+	}
+	block := db.Block{ID: len(m.Blocks) + 1, BlockerID: blockerID, BlockedID: blockedID, CreatedAt: time.Now()}
+	m.Blocks = append(m.Blocks, block)
+	m.Follows = slices.DeleteFunc(m.Follows, func(follow db.Follow) bool {
+		return follow.FollowerID == blockerID && follow.FolloweeID == blockedID ||
+			follow.FollowerID == blockedID && follow.FolloweeID == blockerID
+	})
+	return block, true, nil
+}
+
+func (m *MockDB) UnblockUser(blockerID, blockedID int) (bool, error) {
+	for i, block := range m.Blocks {
+		if block.BlockerID == blockerID && block.BlockedID == blockedID {
+			m.Blocks = slices.Delete(m.Blocks, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDB) MuteUser(muterID, mutedID int) (db.Mute, bool, error) {
+	if muterID == mutedID {
+		return db.Mute{}, false, db.ErrSelfMute
+	}
+	for _, mute := range m.Mutes {
+		if mute.MuterID == muterID && mute.MutedID == mutedID {
+			return mute, false, nil
+		}
+	}
+	mute := db.Mute{ID: len(m.Mutes) + 1, MuterID: muterID, MutedID: mutedID, CreatedAt: time.Now()}
+	m.Mutes = append(m.Mutes, mute)
+	return mute, true, nil
+}
+
+func (m *MockDB) UnmuteUser(muterID, mutedID int) (bool, error) {
+	for i, mute := range m.Mutes {
+		if mute.MuterID == muterID && mute.MutedID == mutedID {
+			m.Mutes = slices.Delete(m.Mutes, i, i+1)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDB) ListBlocks(userID, beforeID, limit int) ([]db.Block, bool, error) {
+	var blocks []db.Block
+	for i := len(m.Blocks) - 1; i >= 0; i-- {
+		block := m.Blocks[i]
+		if block.BlockerID == userID && (beforeID == 0 || block.ID < beforeID) {
+			blocks = append(blocks, block)
+		}
+	}
+	if len(blocks) > limit {
+		return blocks[:limit], true, nil
+	}
+	return blocks, false, nil
+}
+
+func (m *MockDB) ListMutes(userID, beforeID, limit int) ([]db.Mute, bool, error) {
+	var mutes []db.Mute
+	for i := len(m.Mutes) - 1; i >= 0; i-- {
+		mute := m.Mutes[i]
+		if mute.MuterID == userID && (beforeID == 0 || mute.ID < beforeID) {
+			mutes = append(mutes, mute)
+		}
+	}
+	if len(mutes) > limit {
+		return mutes[:limit], true, nil
+	}
+	return mutes, false, nil
+}
+
+func (m *MockDB) IsBlocked(userID, otherID int) (bool, error) {
+	for _, block := range m.Blocks {
+		if block.BlockerID == userID && block.BlockedID == otherID || block.BlockerID == otherID && block.BlockedID == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockDB) ListHiddenAuthorIDs(viewerID int) ([]int, error) {
+	if viewerID == 0 {
+		return nil, nil
+	}
+	var ids []int
+	for _, block := range m.Blocks {
+		if block.BlockerID == viewerID {
+			ids = append(ids, block.BlockedID)
+		} else if block.BlockedID == viewerID {
+			ids = append(ids, block.BlockerID)
+		}
+	}
+	for _, mute := range m.Mutes {
+		if mute.MuterID == viewerID {
+			ids = append(ids, mute.MutedID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *MockDB) ListFollowedIDs(userID int) ([]int, error) {
+	var ids []int
+	for _, follow := range m.Follows {
+		if follow.FollowerID == userID {
+			ids = append(ids, follow.FolloweeID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *MockDB) ListFollowerIDs(userID int) ([]int, error) {
+	var ids []int
+	for _, follow := range m.Follows {
+		if follow.FolloweeID == userID {
+			ids = append(ids, follow.FollowerID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *MockDB) ListFollowers(userID, beforeID, limit int) ([]db.Follow, bool, error) {
+	return m.listFollows(beforeID, limit, func(follow db.Follow) bool { return follow.FolloweeID == userID })
+}
+
+func (m *MockDB) ListFollowing(userID, beforeID, limit int) ([]db.Follow, bool, error) {
+	return m.listFollows(beforeID, limit, func(follow db.Follow) bool { return follow.FollowerID == userID })
+}
+
+func (m *MockDB) listFollows(beforeID, limit int, match func(db.Follow) bool) ([]db.Follow, bool, error) {
+	var follows []db.Follow
+	for i := len(m.Follows) - 1; i >= 0; i-- {
+		follow := m.Follows[i]
+		if match(follow) && (beforeID == 0 || follow.ID < beforeID) {
+			follows = append(follows, follow)
+		}
+	}
+	if len(follows) > limit {
+		return follows[:limit], true, nil
+	}
+	return follows, false, nil
+}
+
+func (m *MockDB) ListNotifications(userID, beforeID, limit int, unreadOnly bool) ([]db.Notification, bool, error) {
+	var notifications []db.Notification
+	for i := len(m.Notifications) - 1; i >= 0; i-- {
+		notification := m.Notifications[i]
+		if notification.UserID == userID && (beforeID == 0 || notification.ID < beforeID) && (!unreadOnly || notification.ReadAt == nil) {
+			notifications = append(notifications, notification)
+		}
+	}
+	if len(notifications) > limit {
+		return notifications[:limit], true, nil
+	}
+	return notifications, false, nil
+}
+
+func (m *MockDB) CountUnreadNotifications(userID int) (int, error) {
+	notifications, _, err := m.ListNotifications(userID, 0, len(m.Notifications), true)
+	return len(notifications), err
+}
+
+func (m *MockDB) MarkNotificationsRead(userID int, ids []int) (int, error) {
+	now := time.Now()
+	marked := 0
+	for i, notification := range m.Notifications {
+		if notification.UserID == userID && notification.ReadAt == nil && (ids == nil || slices.Contains(ids, notification.ID)) {
+			m.Notifications[i].ReadAt = &now
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+func (m *MockDB) SetMutedNotifications(userID int, types []string) (db.User, error) {
+	for _, t := range types {
+		if !slices.Contains(db.NotificationTypes, t) {
+			return db.User{}, db.ErrInvalidNotificationType
+		}
+	}
+	m.MutedNotifications = types
+	return db.User{ID: userID, MutedNotifications: types}, nil
 }
 
 func (m *MockDB) GetChirp(id int) (*db.Chirp, error) {
@@ -167,7 +718,7 @@ func TestCreateChirpRoute(t *testing.T) {
 			body: map[string]string{
 				"body": "I had something interesting for breakfast",
 			},
-			wantResp:       `{"id":1,"author_id":1,"body":"I had something interesting for breakfast"}`,
+			wantResp:       `{"id":1,"author_id":1,"body":"I had something interesting for breakfast","reply_count":0,"like_count":0,"liked_by_me":false}`,
 			wantStatusCode: http.StatusCreated,
 		},
 		{
@@ -183,7 +734,7 @@ func TestCreateChirpRoute(t *testing.T) {
 				"body":  "I had something interesting for breakfast",
 				"extra": "should be ignored",
 			},
-			wantResp:       `{"id":1,"author_id":1,"body":"I had something interesting for breakfast"}`,
+			wantResp:       `{"id":1,"author_id":1,"body":"I had something interesting for breakfast","reply_count":0,"like_count":0,"liked_by_me":false}`,
 			wantStatusCode: http.StatusCreated,
 		},
 		{
@@ -191,7 +742,36 @@ func TestCreateChirpRoute(t *testing.T) {
 			body: map[string]string{
 				"body": "I really need a kerfuffle to go to bed sooner, Fornax !",
 			},
-			wantResp:       `{"id":1,"author_id":1,"body":"I really need a **** to go to bed sooner, **** !"}`,
+			wantResp:       `{"id":1,"author_id":1,"body":"I really need a **** to go to bed sooner, **** !","reply_count":0,"like_count":0,"liked_by_me":false}`,
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name: "Unclean chirp with punctuation",
+			body: map[string]string{
+				"body": "What a Kerfuffle!",
+			},
+			wantResp:       `{"id":1,"author_id":1,"body":"What a ****!","reply_count":0,"like_count":0,"liked_by_me":false}`,
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name: "140 emoji",
+			body: map[string]string{
+				"body": strings.Repeat("\U0001F44D\U0001F3FD", 140),
+			},
+			wantStatusCode: http.StatusCreated,
+		},
+		{
+			name: "141 emoji",
+			body: map[string]string{
+				"body": strings.Repeat("\U0001F44D\U0001F3FD", 141),
+			},
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name: "140 flags and families",
+			body: map[string]string{
+				"body": strings.Repeat("\U0001F1EB\U0001F1F7\U0001F468\u200d\U0001F469\u200d\U0001F467", 70),
+			},
 			wantStatusCode: http.StatusCreated,
 		},
 	}
@@ -231,11 +811,32 @@ func TestCreateChirpRoute(t *testing.T) {
 	}
 }
 
+func TestCreateChirp_MaskedLength(t *testing.T) {
+	filter, err := moderation.NewFilter([]string{"abc"}, moderation.MaskFixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, _ := tokenManager.CreateAccessToken(1)
+	router := NewRouter(NewMockDB(), tokenManager, WithModerationFilter(filter))
+
+	// the 140 characters of the body become 175 once the words are masked.
+	body, _ := json.Marshal(map[string]string{"body": strings.Repeat("abc ", 35)})
+	req := httptest.NewRequest(http.MethodPost, "/api/chirps", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected the masked chirp to be too long, got %d", rw.Code)
+	}
+}
+
 func TestGetChirp(t *testing.T) {
 	mockDB := NewMockDB()
 	tokenManager := token.NewManager("mysecret", "")
-	chirp := db.Chirp{ID: 1, Body: "I had something interesting for breakfast"}
-	mockDB.Chirps = []db.Chirp{chirp}
+	stored := db.Chirp{ID: 1, Body: "I had something interesting for breakfast"}
+	mockDB.Chirps = []db.Chirp{stored}
 	router := NewRouter(mockDB, tokenManager)
 	if router == nil {
 		t.Error("Expected router to not be nil")
@@ -248,7 +849,7 @@ func TestGetChirp(t *testing.T) {
 	if rw.Code != http.StatusOK {
 		t.Errorf("Expected StatusOk, got %d", rw.Code)
 	}
-	want, err := json.Marshal(chirp)
+	want, err := json.Marshal(chirp.ChirpResponse{Chirp: stored})
 	if err != nil {
 		t.Errorf("Expected no error, got %s", err.Error())
 	}
@@ -256,3 +857,1241 @@ func TestGetChirp(t *testing.T) {
 		t.Errorf("Expected body to be %s, got %s", string(want), rw.Body.String())
 	}
 }
+
+func TestListChirpsPage(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{{ID: 1, Body: "one"}, {ID: 2, Body: "two"}, {ID: 3, Body: "three"}}
+	router := NewRouter(mockDB, token.NewManager("mysecret", ""), WithCursorSigner(cursor.NewSigner("cursorsecret")))
+
+	get := func(path string) (*httptest.ResponseRecorder, chirp.ChirpPageResponse) {
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+		var page chirp.ChirpPageResponse
+		if rw.Code == http.StatusOK {
+			if err := json.Unmarshal(rw.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+		}
+		return rw, page
+	}
+
+	rw, page := get("/api/chirps?limit=2")
+	if rw.Code != http.StatusOK || len(page.Chirps) != 2 || page.NextCursor == "" || page.PrevCursor != "" {
+		t.Fatalf("Expected a first page of 2 chirps with a next cursor, got %d %s", rw.Code, rw.Body.String())
+	}
+	if link := rw.Header().Get("Link"); !strings.Contains(link, `rel="next"`) || !strings.Contains(link, "limit=2") {
+		t.Errorf("Expected a next Link header, got %q", link)
+	}
+
+	rw, page = get("/api/chirps?limit=2&after=" + page.NextCursor)
+	if rw.Code != http.StatusOK || len(page.Chirps) != 1 || page.Chirps[0].ID != 3 || page.NextCursor != "" || page.PrevCursor == "" {
+		t.Fatalf("Expected a last page with chirp 3, got %d %s", rw.Code, rw.Body.String())
+	}
+
+	for _, path := range []string{
+		"/api/chirps?after=" + page.PrevCursor + "x",
+		"/api/chirps?sort=desc&before=" + page.PrevCursor,
+		"/api/chirps?limit=0",
+	} {
+		if rw, _ := get(path); rw.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected StatusBadRequest, got %d", path, rw.Code)
+		}
+	}
+}
+
+func TestSearchChirps(t *testing.T) {
+	hiddenAt := time.Now()
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{
+		{ID: 1, AuthorID: 1, Body: "I had something interesting for breakfast"},
+		{ID: 2, AuthorID: 2, Body: "Breakfasts are great"},
+		{ID: 3, AuthorID: 1, Body: "Nothing to see"},
+		{ID: 4, AuthorID: 1, Body: "A hidden breakfast", HiddenAt: &hiddenAt},
+	}
+	tokenManager := token.NewManager("mysecret", "")
+	router := NewRouter(mockDB, tokenManager)
+	authorToken, _ := tokenManager.CreateAccessToken(1)
+	adminToken, _ := tokenManager.CreateAccessToken(3, token.RoleAdmin)
+
+	tests := []struct {
+		name           string
+		path           string
+		token          string
+		wantStatusCode int
+		wantIDs        []int
+	}{
+		{name: "stemmed", path: "/api/chirps/search?q=breakfast&sort=recent", wantStatusCode: http.StatusOK, wantIDs: []int{2, 1}},
+		{name: "hidden to the author", path: "/api/chirps/search?q=breakfast&sort=recent", token: authorToken, wantStatusCode: http.StatusOK, wantIDs: []int{2, 1}},
+		{name: "hidden to the admins", path: "/api/chirps/search?q=hidden", token: adminToken, wantStatusCode: http.StatusOK},
+		{name: "author", path: "/api/chirps/search?q=breakfast&author_id=1", wantStatusCode: http.StatusOK, wantIDs: []int{1}},
+		{name: "phrase", path: "/api/chirps/search?q=%22interesting+breakfast%22", wantStatusCode: http.StatusOK},
+		{name: "missing query", path: "/api/chirps/search?q=+", wantStatusCode: http.StatusBadRequest},
+		{name: "invalid sort", path: "/api/chirps/search?q=breakfast&sort=asc", wantStatusCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tt.path, http.NoBody)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			router.ServeHTTP(rw, req)
+			if rw.Code != tt.wantStatusCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatusCode, rw.Code)
+			}
+			if rw.Code != http.StatusOK {
+				return
+			}
+
+			var page chirp.ChirpPageResponse
+			if err := json.Unmarshal(rw.Body.Bytes(), &page); err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+			var ids []int
+			for _, found := range page.Chirps {
+				ids = append(ids, found.ID)
+			}
+			if !slices.Equal(ids, tt.wantIDs) {
+				t.Errorf("Expected chirps %v, got %v", tt.wantIDs, ids)
+			}
+		})
+	}
+}
+
+func TestUpdateChirp(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{
+		{ID: 1, AuthorID: 1, Body: "I had someting for breakfast", CreatedAt: &now},
+		{ID: 2, AuthorID: 1, Body: "Too late", CreatedAt: &old},
+		{ID: 3, AuthorID: 2, Body: "Not mine", CreatedAt: &now},
+		{ID: 4, AuthorID: 1, Body: "Legacy"},
+	}
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, err := tokenManager.CreateAccessToken(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	router := NewRouter(mockDB, tokenManager, WithChirpEditWindow(10*time.Minute))
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{name: "Edit", path: "/api/chirps/1", body: `{"body":"I had something for kerfuffle"}`, wantStatusCode: http.StatusOK, wantBody: "I had something for ****"},
+		{name: "Too long", path: "/api/chirps/1", body: `{"body":"` + strings.Repeat("a", 141) + `"}`, wantStatusCode: http.StatusBadRequest},
+		{name: "Edit window over", path: "/api/chirps/2", body: `{"body":"Edited"}`, wantStatusCode: http.StatusForbidden},
+		{name: "Not the author", path: "/api/chirps/3", body: `{"body":"Edited"}`, wantStatusCode: http.StatusForbidden},
+		{name: "Unknown creation time", path: "/api/chirps/4", body: `{"body":"Edited"}`, wantStatusCode: http.StatusForbidden},
+		{name: "Not found", path: "/api/chirps/5", body: `{"body":"Edited"}`, wantStatusCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+			rw := httptest.NewRecorder()
+			router.ServeHTTP(rw, req)
+
+			if rw.Code != tt.wantStatusCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatusCode, rw.Code)
+			}
+			if tt.wantBody == "" {
+				return
+			}
+
+			var got db.Chirp
+			if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+			if got.Body != tt.wantBody || got.EditedAt == nil {
+				t.Errorf("Expected an edited chirp with body %q, got %s", tt.wantBody, rw.Body.String())
+			}
+		})
+	}
+
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/chirps/1/history", http.NoBody))
+	if rw.Code != http.StatusOK {
+		t.Errorf("Expected StatusOK, got %d", rw.Code)
+	}
+}
+
+func TestChirpThread(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{
+		{ID: 1, AuthorID: 1, Body: "root"},
+		{ID: 3, AuthorID: 1, Body: "reply to a deleted chirp", ReplyToID: 2, ConversationID: 1},
+		{ID: 4, AuthorID: 2, Body: "second reply", ReplyToID: 1, ConversationID: 1},
+		{ID: 5, AuthorID: 1, Body: "deep", ReplyToID: 3, ConversationID: 1},
+	}
+	mockDB.Tombstones = []db.ChirpTombstone{{ID: 2, ReplyToID: 1, ConversationID: 1}}
+	router := NewRouter(mockDB, token.NewManager("mysecret", ""))
+
+	get := func(path string) chirp.ThreadResponse {
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("%s: expected StatusOK, got %d", path, rw.Code)
+		}
+		var thread chirp.ThreadResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &thread); err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		return thread
+	}
+
+	thread := get("/api/chirps/1/thread?depth=2&limit=1")
+	replies := thread.Chirp.Replies
+	if len(replies) != 1 || !replies[0].Deleted || replies[0].ReplyCount != 1 || thread.NextCursor == "" {
+		t.Fatalf("Expected a deleted placeholder as first reply and a next cursor, got %+v", thread)
+	}
+	if nested := replies[0].Replies; len(nested) != 1 || nested[0].ID != 3 || !nested[0].HasMoreReplies {
+		t.Errorf("Expected the reply to the deleted chirp, cut at the depth limit, got %+v", nested)
+	}
+
+	thread = get("/api/chirps/1/thread?depth=2&limit=1&after=" + thread.NextCursor)
+	if replies := thread.Chirp.Replies; len(replies) != 1 || replies[0].ID != 4 || thread.NextCursor != "" {
+		t.Errorf("Expected the second reply on the last page, got %+v", thread)
+	}
+
+	thread = get("/api/chirps/5/thread")
+	var ancestors []int
+	for _, ancestor := range thread.Ancestors {
+		ancestors = append(ancestors, ancestor.ID)
+	}
+	if !slices.Equal(ancestors, []int{1, 2, 3}) || !thread.Ancestors[1].Deleted {
+		t.Errorf("Expected the ancestors 1, 2 (deleted) and 3, got %+v", thread.Ancestors)
+	}
+}
+
+func TestLikeChirp(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{{ID: 1, AuthorID: 2, Body: "like me"}}
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, err := tokenManager.CreateAccessToken(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	router := NewRouter(mockDB, tokenManager)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		wantStatusCode int
+		wantResp       string
+	}{
+		{name: "Like", method: http.MethodPut, path: "/api/chirps/1/like", wantStatusCode: http.StatusOK, wantResp: `{"chirp_id":1,"like_count":1,"liked_by_me":true}`},
+		{name: "Like again", method: http.MethodPut, path: "/api/chirps/1/like", wantStatusCode: http.StatusOK, wantResp: `{"chirp_id":1,"like_count":1,"liked_by_me":true}`},
+		{name: "Chirp", method: http.MethodGet, path: "/api/chirps/1", wantStatusCode: http.StatusOK, wantResp: `{"id":1,"author_id":2,"body":"like me","reply_count":0,"like_count":1,"liked_by_me":true}`},
+		{name: "Unlike", method: http.MethodDelete, path: "/api/chirps/1/like", wantStatusCode: http.StatusOK, wantResp: `{"chirp_id":1,"like_count":0,"liked_by_me":false}`},
+		{name: "Unlike again", method: http.MethodDelete, path: "/api/chirps/1/like", wantStatusCode: http.StatusOK, wantResp: `{"chirp_id":1,"like_count":0,"liked_by_me":false}`},
+		{name: "Unknown chirp", method: http.MethodPut, path: "/api/chirps/2/like", wantStatusCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, http.NoBody)
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+			rw := httptest.NewRecorder()
+			router.ServeHTTP(rw, req)
+
+			if rw.Code != tt.wantStatusCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatusCode, rw.Code)
+			}
+			if tt.wantResp != "" && rw.Body.String() != tt.wantResp {
+				t.Errorf("Expected body to be %s, got %s", tt.wantResp, rw.Body.String())
+			}
+		})
+	}
+
+	mockDB.Likes = []db.Like{{ID: 1, UserID: 2, ChirpID: 1}, {ID: 2, UserID: 3, ChirpID: 1}}
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/chirps/1/likes?limit=1", http.NoBody))
+	var likers like.LikersResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &likers); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if rw.Code != http.StatusOK || len(likers.Users) != 1 || likers.NextCursor == "" {
+		t.Errorf("Expected a page of one liker with a next cursor, got %d %s", rw.Code, rw.Body.String())
+	}
+}
+
+func TestRechirpAndQuote(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{{ID: 5, AuthorID: 2, Body: "share me"}, {ID: 6, AuthorID: 2, Body: "quote", QuoteOfID: 4}}
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, err := tokenManager.CreateAccessToken(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	router := NewRouter(mockDB, tokenManager)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := do(http.MethodPost, "/api/chirps/5/rechirp", ""); rw.Code != http.StatusCreated ||
+		!strings.Contains(rw.Body.String(), `"rechirp_of_id":5`) || !strings.Contains(rw.Body.String(), `"original":{"id":5,"author_id":2,"body":"share me"`) {
+		t.Errorf("Expected a new rechirp embedding its original, got %d %s", rw.Code, rw.Body.String())
+	}
+	if rw := do(http.MethodPost, "/api/chirps/5/rechirp", ""); rw.Code != http.StatusOK {
+		t.Errorf("Expected the existing rechirp, got %d", rw.Code)
+	}
+	if rw := do(http.MethodPost, "/api/chirps", `{"body":"`+strings.Repeat("a", 141)+`","quote_of_id":5}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a too long quote to be rejected, got %d", rw.Code)
+	}
+	if rw := do(http.MethodPost, "/api/chirps", `{"body":"nice","quote_of_id":42}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a quote of an unknown chirp to be rejected, got %d", rw.Code)
+	}
+	if rw := do(http.MethodGet, "/api/chirps/6", ""); !strings.Contains(rw.Body.String(), `"original":{"id":4,"author_id":0,"body":"","reply_count":0,"like_count":0,"deleted":true}`) {
+		t.Errorf("Expected a quote of a deleted chirp to embed a placeholder, got %s", rw.Body.String())
+	}
+}
+
+func TestFollowAndTimeline(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{
+		{ID: 1, AuthorID: 1, Body: "mine"},
+		{ID: 2, AuthorID: 2, Body: "followed"},
+		{ID: 3, AuthorID: 3, Body: "stranger"},
+		{ID: 4, AuthorID: 2, Body: "followed again"},
+	}
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, err := tokenManager.CreateAccessToken(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	router := NewRouter(mockDB, tokenManager)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := do(http.MethodPut, "/api/users/1/follow"); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected following oneself to be rejected, got %d", rw.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if rw := do(http.MethodPut, "/api/users/2/follow"); rw.Code != http.StatusOK || rw.Body.String() != `{"user_id":2,"followed_by_me":true}` {
+			t.Errorf("Expected the user to be followed, got %d %s", rw.Code, rw.Body.String())
+		}
+	}
+	if len(mockDB.Follows) != 1 {
+		t.Errorf("Expected a single follow, got %d", len(mockDB.Follows))
+	}
+	if rw := do(http.MethodGet, "/api/users/2/followers"); !strings.Contains(rw.Body.String(), `"users":[{"id":1,`) {
+		t.Errorf("Expected the follower to be listed, got %s", rw.Body.String())
+	}
+
+	var page chirp.ChirpPageResponse
+	rw := do(http.MethodGet, "/api/timeline?limit=2")
+	if err := json.Unmarshal(rw.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if len(page.Chirps) != 2 || page.Chirps[0].ID != 4 || page.Chirps[1].ID != 2 || page.NextCursor == "" {
+		t.Fatalf("Expected the two most recent chirps of the timeline, got %s", rw.Body.String())
+	}
+	rw = do(http.MethodGet, "/api/timeline?limit=2&after="+page.NextCursor)
+	page = chirp.ChirpPageResponse{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if len(page.Chirps) != 1 || page.Chirps[0].ID != 1 || page.NextCursor != "" {
+		t.Errorf("Expected the own chirp on the last page, got %s", rw.Body.String())
+	}
+
+	if rw := do(http.MethodDelete, "/api/users/2/follow"); rw.Code != http.StatusOK || rw.Body.String() != `{"user_id":2,"followed_by_me":false}` {
+		t.Errorf("Expected the user to be unfollowed, got %d %s", rw.Code, rw.Body.String())
+	}
+	rw = do(http.MethodGet, "/api/timeline")
+	if err := json.Unmarshal(rw.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if len(page.Chirps) != 1 || page.Chirps[0].ID != 1 {
+		t.Errorf("Expected only the own chirp after unfollowing, got %s", rw.Body.String())
+	}
+}
+
+func TestTagFeeds(t *testing.T) {
+	mockDB := NewMockDB()
+	golang := entity.Entity{Type: entity.TypeHashtag, Start: 0, End: 7, Tag: "golang"}
+	mockDB.Chirps = []db.Chirp{
+		{ID: 1, AuthorID: 1, Body: "#golang", Entities: []entity.Entity{golang}},
+		{ID: 2, AuthorID: 1, Body: "#rust", Entities: []entity.Entity{{Type: entity.TypeHashtag, Start: 0, End: 5, Tag: "rust"}}},
+		{ID: 3, AuthorID: 2, Body: "#GoLang", Entities: []entity.Entity{golang}},
+	}
+	router := NewRouter(mockDB, token.NewManager("mysecret", ""))
+
+	var page chirp.ChirpPageResponse
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/tags/GoLang?limit=1", http.NoBody))
+	if err := json.Unmarshal(rw.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if len(page.Chirps) != 1 || page.Chirps[0].ID != 3 || page.NextCursor == "" {
+		t.Fatalf("Expected the most recent chirp of the tag, got %s", rw.Body.String())
+	}
+	if !strings.Contains(rw.Body.String(), `"entities":[{"type":"hashtag","start":0,"end":7,"tag":"golang"}]`) {
+		t.Errorf("Expected the chirp to carry its entities, got %s", rw.Body.String())
+	}
+
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/tags/rust?after="+page.NextCursor, http.NoBody))
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a cursor of another tag to be rejected, got %d", rw.Code)
+	}
+
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/trending/tags?window=1h", http.NoBody))
+	if want := `{"window":"1h0m0s","tags":[{"tag":"golang","count":2},{"tag":"rust","count":1}]}`; rw.Body.String() != want {
+		t.Errorf("Expected body to be %s, got %s", want, rw.Body.String())
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Notifications = []db.Notification{
+		{ID: 1, UserID: 1, Type: db.NotificationFollow, ActorID: 2},
+		{ID: 2, UserID: 2, Type: db.NotificationFollow, ActorID: 1},
+		{ID: 3, UserID: 1, Type: db.NotificationLike, ActorID: 2, ChirpID: 4},
+	}
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, err := tokenManager.CreateAccessToken(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	router := NewRouter(mockDB, tokenManager)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := do(http.MethodGet, "/api/notifications?limit=1", "")
+	if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), `"notifications":[{"id":3,"user_id":1,"type":"like","actor_id":2,"chirp_id":4,`) ||
+		!strings.Contains(rw.Body.String(), `"actor":{"id":2,`) || !strings.Contains(rw.Body.String(), `"next_cursor"`) {
+		t.Errorf("Expected the most recent notification with its actor, got %d %s", rw.Code, rw.Body.String())
+	}
+	if rw := do(http.MethodGet, "/api/notifications/unread", ""); rw.Body.String() != `{"unread_count":2}` {
+		t.Errorf("Expected two unread notifications, got %s", rw.Body.String())
+	}
+	if rw := do(http.MethodPost, "/api/notifications/read", `{"ids":[3,2]}`); rw.Body.String() != `{"marked":1,"unread_count":1}` {
+		t.Errorf("Expected only the own notification to be marked, got %s", rw.Body.String())
+	}
+	if rw := do(http.MethodPost, "/api/notifications/read", `{}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a read without ids nor all to be rejected, got %d", rw.Code)
+	}
+	if rw := do(http.MethodPost, "/api/notifications/read", `{"all":true}`); rw.Body.String() != `{"marked":1,"unread_count":0}` {
+		t.Errorf("Expected all the notifications to be marked, got %s", rw.Body.String())
+	}
+
+	if rw := do(http.MethodPut, "/api/notifications/preferences", `{"muted":["like","unknown"]}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown type to be rejected, got %d", rw.Code)
+	}
+	if rw := do(http.MethodPut, "/api/notifications/preferences", `{"muted":["like"]}`); rw.Body.String() != `{"muted":["like"]}` {
+		t.Errorf("Expected likes to be muted, got %s", rw.Body.String())
+	}
+}
+
+func TestStreamChirps(t *testing.T) {
+	mockDB := NewMockDB()
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, err := tokenManager.CreateAccessToken(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	broker := stream.NewBroker(0)
+	server := httptest.NewServer(NewRouter(mockDB, tokenManager, WithStreamBroker(broker)))
+	defer server.Close()
+
+	broker.ChirpCreated(db.Chirp{ID: 5, AuthorID: 1, Body: "seen"})
+	broker.ChirpCreated(db.Chirp{ID: 6, AuthorID: 1, Body: "missed"})
+	broker.ChirpCreated(db.Chirp{ID: 7, AuthorID: 2, Body: "filtered"})
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/stream?author_id=1", http.NoBody)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	create := httptest.NewRequest(http.MethodPost, "/api/chirps", strings.NewReader(`{"body":"live"}`))
+	create.Header.Set("Authorization", "Bearer "+accessToken)
+	server.Config.Handler.ServeHTTP(httptest.NewRecorder(), create)
+
+	lines := bufio.NewScanner(resp.Body)
+	var events []string
+	for lines.Scan() {
+		if strings.HasPrefix(lines.Text(), "id: ") || strings.HasPrefix(lines.Text(), "data: ") {
+			events = append(events, lines.Text())
+		}
+		if strings.HasPrefix(lines.Text(), "data: ") && strings.Contains(lines.Text(), `"live"`) {
+			break
+		}
+	}
+	want := []string{`id: 2`, `data: {"id":6,"author_id":1,"body":"missed"}`, `id: 4`, `data: {"id":1,"author_id":1,"body":"live"}`}
+	if !slices.Equal(events, want) {
+		t.Errorf("Expected the events %v, got %v", want, events)
+	}
+
+	// closing the broker ends the streams, for the server to shut down.
+	broker.Close()
+	for lines.Scan() {
+	}
+	if err := lines.Err(); err != nil {
+		t.Errorf("Expected the stream to end, got %s", err.Error())
+	}
+}
+
+func TestDeleteChirp_Rechirps(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{
+		{ID: 1, AuthorID: 1, Body: "original"},
+		{ID: 2, AuthorID: 2, RechirpOfID: 1},
+		{ID: 3, AuthorID: 3, Body: "other"},
+	}
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, _ := tokenManager.CreateAccessToken(1)
+	broker := stream.NewBroker(0)
+	sub, _ := broker.Subscribe(0)
+	defer sub.Cancel()
+	router := NewRouter(mockDB, tokenManager, WithStreamBroker(broker))
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/chirps/1", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rw.Code)
+	}
+
+	// the rechirp deleted with the chirp is announced too.
+	for _, want := range []int{1, 2} {
+		event := <-sub.Events
+		if event.Type != stream.EventChirpDeleted || event.Chirp.ID != want {
+			t.Errorf("Expected the deletion of chirp %d, got %s of %d", want, event.Type, event.Chirp.ID)
+		}
+	}
+	if len(mockDB.Chirps) != 1 || mockDB.Chirps[0].ID != 3 {
+		t.Errorf("Expected the chirp and its rechirp to be deleted, got %v", mockDB.Chirps)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	store, err := db.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatalf("newDB should not have an error %v", err)
+	}
+	passwords := password.NewManager(password.NewBcrypt(4))
+	hash, err := passwords.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := store.CreateUser("leaving@example.com", hash)
+	if err != nil {
+		t.Fatalf("CreateUser should not have an error %v", err)
+	}
+	if _, err := store.CreateChirp(db.Chirp{Body: "goodbye everyone", AuthorID: u.ID}); err != nil {
+		t.Fatalf("CreateChirp should not have an error %v", err)
+	}
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, _ := tokenManager.CreateAccessToken(u.ID)
+	broker := stream.NewBroker(0)
+	sub, _ := broker.Subscribe(0)
+	defer sub.Cancel()
+	router := NewRouter(store, tokenManager, WithPasswordManager(passwords), WithStreamBroker(broker), WithBlobStore(blob.NewFileStore(t.TempDir())))
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		return rw
+	}
+
+	rw := serve(http.MethodDelete, "/api/users/me", `{"current_password":"secret"}`)
+	var deletion db.AccountDeletion
+	if err := json.Unmarshal(rw.Body.Bytes(), &deletion); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Expected the account to be deleted, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	// the deleted chirps are announced and leave the search index.
+	if event := <-sub.Events; event.Type != stream.EventChirpDeleted || event.Chirp.ID != 1 {
+		t.Errorf("Expected the deletion of chirp 1, got %s of %d", event.Type, event.Chirp.ID)
+	}
+	if rw := serve(http.MethodGet, "/api/chirps/search?q=goodbye", ""); rw.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the token of the deleted account to be rejected, got %d", rw.Code)
+	}
+	search := httptest.NewRecorder()
+	router.ServeHTTP(search, httptest.NewRequest(http.MethodGet, "/api/chirps/search?q=goodbye", nil))
+	if search.Code != http.StatusOK || strings.Contains(search.Body.String(), "goodbye") {
+		t.Errorf("Expected the deleted chirp to leave the search index, got %s", search.Body.String())
+	}
+
+	// the deletion can be retried with the token of the deleted account.
+	rw = serve(http.MethodDelete, "/api/users/me", `{"current_password":"secret"}`)
+	var again db.AccountDeletion
+	if err := json.Unmarshal(rw.Body.Bytes(), &again); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("Expected the deletion to be idempotent, got %d: %s", rw.Code, rw.Body.String())
+	}
+	if !again.DeletedAt.Equal(deletion.DeletedAt) || again.UserID != u.ID {
+		t.Errorf("Expected the original audit record %+v, got %+v", deletion, again)
+	}
+}
+
+func TestWebSocket(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Follows = []db.Follow{{ID: 1, FollowerID: 1, FolloweeID: 2}}
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, err := tokenManager.CreateAccessToken(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	broker := stream.NewBroker(0)
+	server := httptest.NewServer(NewRouter(mockDB, tokenManager,
+		WithStreamBroker(broker),
+		WithCORSPolicy(cors.NewPolicy("https://app.example.com")),
+	))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ws"
+
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected an anonymous handshake to be refused, got %v", err)
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"?access_token="+accessToken, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected an access token in the query to be refused, got %v", err)
+	}
+	newTicket := func() string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/ws/tickets", nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Expected no error, got %s", err.Error())
+		}
+		defer resp.Body.Close()
+		var ticket ws.TicketResponse
+		if err := json.NewDecoder(resp.Body).Decode(&ticket); err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected a ticket, got %d %v", resp.StatusCode, err)
+		}
+		return ticket.Ticket
+	}
+
+	foreign := http.Header{"Origin": {"https://evil.example.com"}}
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"?ticket="+newTicket(), foreign); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected a foreign origin to be refused, got %v", err)
+	}
+	allowed := http.Header{"Origin": {"https://app.example.com"}}
+	ticket := newTicket()
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?ticket="+ticket, allowed)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	defer conn.Close()
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"?ticket="+ticket, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a used ticket to be refused, got %v", err)
+	}
+
+	exchange := func(msg, want string) {
+		t.Helper()
+		if msg != "" {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+		}
+		_, got, err := conn.ReadMessage()
+		if err != nil || string(got) != want {
+			t.Fatalf("Expected message %s, got %s %v", want, got, err)
+		}
+	}
+	exchange(`{"type":"subscribe","topic":"everything"}`, `{"type":"error","topic":"everything","error":"unknown topic \"everything\""}`)
+	exchange(`{"type":"subscribe","topic":"timeline"}`, `{"type":"subscribed","topic":"timeline"}`)
+	exchange(`{"type":"subscribe","topic":"user:3"}`, `{"type":"subscribed","topic":"user:3"}`)
+
+	broker.ChirpCreated(db.Chirp{ID: 5, AuthorID: 4, Body: "not followed"})
+	broker.ChirpCreated(db.Chirp{ID: 6, AuthorID: 2, Body: "followed"})
+	exchange("", `{"type":"event","topic":"timeline","event":"chirp.created","data":{"id":6,"author_id":2,"body":"followed"}}`)
+	broker.ChirpDeleted(db.Chirp{ID: 7, AuthorID: 3})
+	exchange("", `{"type":"event","topic":"user:3","event":"chirp.deleted","data":{"id":7}}`)
+
+	// the notifications need the profile:read scope.
+	exchange(`{"type":"subscribe","topic":"notifications"}`, `{"type":"subscribed","topic":"notifications"}`)
+	raw, _ := token.NewPersonalAccessToken()
+	if _, err := mockDB.CreatePersonalAccessToken(1, "cli", token.HashPersonalAccessToken(raw), []string{token.ScopeChirpsRead}); err != nil {
+		t.Fatal(err)
+	}
+	patConn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + raw}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	defer patConn.Close()
+	if err := patConn.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","topic":"notifications"}`)); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	if _, got, err := patConn.ReadMessage(); err != nil || string(got) != `{"type":"error","topic":"notifications","error":"missing scope profile:read"}` {
+		t.Errorf("Expected the notifications to be refused to a chirps:read token, got %s %v", got, err)
+	}
+
+	// closing the broker ends the websockets, for the server to shut down.
+	broker.Close()
+	var closeErr *websocket.CloseError
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseGoingAway {
+		t.Errorf("Expected the server to close the websocket, got %v", err)
+	}
+}
+
+func TestCORSPolicy(t *testing.T) {
+	tokenManager := token.NewManager("mysecret", "")
+	allowOrigin := func(router http.Handler, origin string) string {
+		req := httptest.NewRequest(http.MethodOptions, "/api/chirps", nil)
+		req.Header.Set("Origin", origin)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		return rw.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	if got := allowOrigin(NewRouter(NewMockDB(), tokenManager), "https://app.example.com"); got != "*" {
+		t.Errorf("Expected any origin to be allowed by default, got %q", got)
+	}
+	router := NewRouter(NewMockDB(), tokenManager, WithCORSPolicy(cors.NewPolicy("https://app.example.com")))
+	if got := allowOrigin(router, "https://app.example.com"); got != "https://app.example.com" {
+		t.Errorf("Expected the listed origin to be allowed, got %q", got)
+	}
+	if got := allowOrigin(router, "https://evil.example.com"); got != "" {
+		t.Errorf("Expected another origin not to be allowed, got %q", got)
+	}
+}
+
+func TestAuthRequiredRoutes(t *testing.T) {
+	mockDB := NewMockDB()
+	tokenManager := token.NewManager("mysecret", "")
+	refreshToken, err := tokenManager.CreateRefreshToken(1)
+	if err != nil {
+		t.Errorf("Expected no error, got %s", err.Error())
+	}
+	router := NewRouter(mockDB, tokenManager)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+	}{
+		{name: "Create chirp without token", method: http.MethodPost, path: "/api/chirps"},
+		{name: "Delete chirp without token", method: http.MethodDelete, path: "/api/chirps/1"},
+		{name: "Update user without token", method: http.MethodPut, path: "/api/users"},
+		{
+			name:          "Create chirp with refresh token",
+			method:        http.MethodPost,
+			path:          "/api/chirps",
+			authorization: "Bearer " + refreshToken,
+		},
+		{
+			name:          "List chirps with invalid token",
+			method:        http.MethodGet,
+			path:          "/api/chirps",
+			authorization: "Bearer invalid",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, http.NoBody)
+			if test.authorization != "" {
+				req.Header.Set("Authorization", test.authorization)
+			}
+			rw := httptest.NewRecorder()
+			router.ServeHTTP(rw, req)
+
+			if rw.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rw.Code)
+			}
+		})
+	}
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	mockDB := NewMockDB()
+	tokenManager := token.NewManager("mysecret", "")
+	raw, err := token.NewPersonalAccessToken()
+	if err != nil {
+		t.Errorf("Expected no error, got %s", err.Error())
+	}
+	mockDB.CreatePersonalAccessToken(1, "ci", token.HashPersonalAccessToken(raw), []string{token.ScopeChirpsRead})
+	router := NewRouter(mockDB, tokenManager)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		wantStatusCode int
+	}{
+		{name: "List chirps", method: http.MethodGet, path: "/api/chirps", wantStatusCode: http.StatusOK},
+		{name: "Create chirp", method: http.MethodPost, path: "/api/chirps", body: `{"body":"hello"}`, wantStatusCode: http.StatusForbidden},
+		{name: "Update user", method: http.MethodPut, path: "/api/users", body: `{}`, wantStatusCode: http.StatusForbidden},
+		{name: "Create token", method: http.MethodPost, path: "/api/tokens", body: `{"name":"other","scopes":["chirps:read"]}`, wantStatusCode: http.StatusForbidden},
+		{name: "List tokens", method: http.MethodGet, path: "/api/tokens", wantStatusCode: http.StatusForbidden},
+		{name: "Revoke token", method: http.MethodDelete, path: "/api/tokens/1", wantStatusCode: http.StatusForbidden},
+		{name: "List notifications", method: http.MethodGet, path: "/api/notifications", wantStatusCode: http.StatusForbidden},
+		{name: "List mutes", method: http.MethodGet, path: "/api/users/me/mutes", wantStatusCode: http.StatusForbidden},
+		{name: "Report chirp", method: http.MethodPost, path: "/api/reports", body: `{"chirp_id":1,"reason":"spam"}`, wantStatusCode: http.StatusForbidden},
+		{name: "Export account", method: http.MethodGet, path: "/api/users/me/export", wantStatusCode: http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			req.Header.Set("Authorization", "Bearer "+raw)
+			rw := httptest.NewRecorder()
+			router.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantStatusCode {
+				t.Errorf("Expected status %d, got %d", test.wantStatusCode, rw.Code)
+			}
+		})
+	}
+}
+
+func TestSignedWebhook(t *testing.T) {
+	mockDB := NewMockDB()
+	tokenManager := token.NewManager("mysecret", "").WithWebhookSecret("webhooksecret")
+	router := NewRouter(mockDB, tokenManager)
+
+	body := []byte(`{"id":"evt_1","event":"user.upgraded","data":{"user_id":3}}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name           string
+		timestamp      string
+		signature      string
+		wantStatusCode int
+		wantUpgraded   int
+	}{
+		{
+			name:           "Valid signature",
+			timestamp:      now,
+			signature:      "sha256=" + hex.EncodeToString(tokenManager.SignWebhook(now, body)),
+			wantStatusCode: http.StatusOK,
+			wantUpgraded:   1,
+		},
+		{
+			name:           "Replayed event",
+			timestamp:      now,
+			signature:      "sha256=" + hex.EncodeToString(tokenManager.SignWebhook(now, body)),
+			wantStatusCode: http.StatusOK,
+			wantUpgraded:   1,
+		},
+		{
+			name:           "Invalid signature",
+			timestamp:      now,
+			signature:      "sha256=" + hex.EncodeToString([]byte("invalid")),
+			wantStatusCode: http.StatusUnauthorized,
+			wantUpgraded:   1,
+		},
+		{
+			name:           "Stale timestamp",
+			timestamp:      stale,
+			signature:      "sha256=" + hex.EncodeToString(tokenManager.SignWebhook(stale, body)),
+			wantStatusCode: http.StatusUnauthorized,
+			wantUpgraded:   1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/polka/webhooks", bytes.NewReader(body))
+			req.Header.Set(token.WebhookTimestampHeader, test.timestamp)
+			req.Header.Set(token.WebhookSignatureHeader, test.signature)
+			rw := httptest.NewRecorder()
+			router.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantStatusCode {
+				t.Errorf("Expected status %d, got %d", test.wantStatusCode, rw.Code)
+			}
+
+			if len(mockDB.UpgradedUsers) != test.wantUpgraded {
+				t.Errorf("Expected %d upgrades, got %d", test.wantUpgraded, len(mockDB.UpgradedUsers))
+			}
+		})
+	}
+}
+
+func TestPublicProfile(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{{ID: 1, AuthorID: 2, Body: "I had something interesting for breakfast"}}
+	tokenManager := token.NewManager("mysecret", "")
+	router := NewRouter(mockDB, tokenManager)
+
+	tests := []struct {
+		name           string
+		path           string
+		wantResp       string
+		wantStatusCode int
+	}{
+		{
+			name:           "By id",
+			path:           "/api/users/2",
+			wantResp:       `{"id":2,"is_chirpy_red":false}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "By handle, case-insensitive",
+			path:           "/api/users/@SOMEONE",
+			wantResp:       `{"id":1,"handle":"Someone","is_chirpy_red":false}`,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Unknown handle",
+			path:           "/api/users/@nobody",
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Chirp with embedded author",
+			path:           "/api/chirps/1?embed=author",
+			wantResp:       `{"id":1,"author_id":2,"body":"I had something interesting for breakfast","reply_count":0,"like_count":0,"author":{"id":2,"is_chirpy_red":false}}`,
+			wantStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, http.NoBody)
+			rw := httptest.NewRecorder()
+			router.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantStatusCode {
+				t.Errorf("Expected status %d, got %d", test.wantStatusCode, rw.Code)
+			}
+
+			if test.wantResp != "" && rw.Body.String() != test.wantResp {
+				t.Errorf("Expected body to be %s, got %s", test.wantResp, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestUploadMedia(t *testing.T) {
+	mockDB := NewMockDB()
+	tokenManager := token.NewManager("mysecret", "")
+	accessToken, err := tokenManager.CreateAccessToken(1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+	router := NewRouter(mockDB, tokenManager, WithBlobStore(blob.NewFileStore(t.TempDir())))
+
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	var png bytes.Buffer
+	if err := pngenc.Encode(&png, img); err != nil {
+		t.Fatalf("Expected no error, got %s", err.Error())
+	}
+
+	tests := []struct {
+		name           string
+		content        []byte
+		wantStatusCode int
+	}{
+		{name: "PNG image", content: png.Bytes(), wantStatusCode: http.StatusCreated},
+		{name: "Not an image", content: []byte("#!/bin/sh\necho hello"), wantStatusCode: http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body bytes.Buffer
+			form := multipart.NewWriter(&body)
+			// the declared content type must be ignored.
+			part, err := form.CreateFormFile("file", "image.png")
+			if err != nil {
+				t.Fatalf("Expected no error, got %s", err.Error())
+			}
+			part.Write(test.content)
+			form.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/media", &body)
+			req.Header.Set("Content-Type", form.FormDataContentType())
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+			rw := httptest.NewRecorder()
+			router.ServeHTTP(rw, req)
+
+			if rw.Code != test.wantStatusCode {
+				t.Errorf("Expected status %d, got %d: %s", test.wantStatusCode, rw.Code, rw.Body.String())
+			}
+		})
+	}
+
+	// the media is not attached yet, only its owner gets it.
+	req := httptest.NewRequest(http.MethodGet, "/api/media/1/thumbnail", http.NoBody)
+	rw := httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404, got %d", rw.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/media/1/thumbnail", http.NoBody)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	rw = httptest.NewRecorder()
+	router.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rw.Code)
+	}
+	if rw.Header().Get("Cache-Control") == "" {
+		t.Error("Expected a Cache-Control header")
+	}
+	thumbnail, err := pngenc.DecodeConfig(rw.Body)
+	if err != nil {
+		t.Fatalf("Expected a PNG thumbnail, got %s", err.Error())
+	}
+	if thumbnail.Width != 320 || thumbnail.Height != 160 {
+		t.Errorf("Expected a 320x160 thumbnail, got %dx%d", thumbnail.Width, thumbnail.Height)
+	}
+}
+
+func (m *MockDB) CreateReport(report db.Report) (db.Report, error) {
+	if !slices.Contains(db.ReportReasons, report.Reason) {
+		return db.Report{}, db.ErrInvalidReport
+	}
+	report.ID = len(m.Reports) + 1
+	report.Status = db.ReportOpen
+	m.Reports = append(m.Reports, report)
+	return report, nil
+}
+
+func (m *MockDB) GetReport(id int) (*db.Report, error) {
+	for _, report := range m.Reports {
+		if report.ID == id {
+			return &report, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (m *MockDB) ListReports(status string, afterID, limit int) ([]db.Report, bool, error) {
+	var reports []db.Report
+	for _, report := range m.Reports {
+		if (status == "" || report.Status == status) && report.ID > afterID {
+			reports = append(reports, report)
+		}
+	}
+	if len(reports) > limit {
+		return reports[:limit], true, nil
+	}
+	return reports, false, nil
+}
+
+func (m *MockDB) ListDecisions(beforeID, limit int) ([]db.Decision, bool, error) {
+	return m.Decisions, false, nil
+}
+
+func (m *MockDB) Decide(decision db.Decision) (db.Decision, error) {
+	if !slices.Contains(db.ModerationActions, decision.Action) {
+		return db.Decision{}, db.ErrInvalidDecision
+	}
+	decision.ID = len(m.Decisions) + 1
+	switch decision.Action {
+	case db.ActionHideChirp:
+		for i, chirp := range m.Chirps {
+			if chirp.ID == decision.ChirpID {
+				now := time.Now()
+				m.Chirps[i].HiddenAt = &now
+			}
+		}
+	case db.ActionSuspend:
+		if m.Suspensions == nil {
+			m.Suspensions = map[int]*db.Suspension{}
+		}
+		m.Suspensions[decision.UserID] = &db.Suspension{DecisionID: decision.ID, ExpiresAt: decision.ExpiresAt}
+	}
+	m.Decisions = append(m.Decisions, decision)
+	return decision, nil
+}
+
+func (m *MockDB) AppealSuspension(userID int, message string) (db.Suspension, error) {
+	suspension, ok := m.Suspensions[userID]
+	if !ok {
+		return db.Suspension{}, db.ErrNotFound
+	}
+	suspension.Appeal = &db.Appeal{Message: message, Status: db.AppealPending}
+	return *suspension, nil
+}
+
+func TestModeration(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{{ID: 1, AuthorID: 1, Body: "spam spam spam"}}
+	tokenManager := token.NewManager("mysecret", "")
+	router := NewRouter(mockDB, tokenManager)
+
+	authorToken, _ := tokenManager.CreateAccessToken(1)
+	reporterToken, _ := tokenManager.CreateAccessToken(2)
+	adminToken, _ := tokenManager.CreateAccessToken(3, token.RoleAdmin)
+	do := func(method, path, accessToken, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := do(http.MethodPost, "/api/reports", reporterToken, `{"chirp_id":1,"reason":"rude"}`); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown reason to be rejected, got %d", rw.Code)
+	}
+	if rw := do(http.MethodPost, "/api/reports", reporterToken, `{"chirp_id":1,"reason":"spam"}`); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected the report to be created, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	if rw := do(http.MethodGet, "/admin/reports", reporterToken, ""); rw.Code != http.StatusForbidden {
+		t.Errorf("Expected the queue to be restricted to the admins, got %d", rw.Code)
+	}
+	rw := do(http.MethodGet, "/admin/reports", adminToken, "")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rw.Code)
+	}
+	var queue report.ReportsResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue.Reports) != 1 || queue.Reports[0].Chirp == nil || queue.Reports[0].Chirp.ID != 1 {
+		t.Errorf("Expected the report of chirp 1, got %+v", queue.Reports)
+	}
+
+	// a hidden chirp is only visible to its author and the admins.
+	if rw := do(http.MethodPost, "/admin/decisions", adminToken, `{"action":"hide_chirp","chirp_id":1}`); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected the chirp to be hidden, got %d: %s", rw.Code, rw.Body.String())
+	}
+	for accessToken, want := range map[string]int{reporterToken: http.StatusNotFound, authorToken: http.StatusOK, adminToken: http.StatusOK} {
+		if rw := do(http.MethodGet, "/api/chirps/1", accessToken, ""); rw.Code != want {
+			t.Errorf("Expected status %d for the hidden chirp, got %d", want, rw.Code)
+		}
+		if rw := do(http.MethodGet, "/api/chirps/1/history", accessToken, ""); rw.Code != want {
+			t.Errorf("Expected status %d for the history of the hidden chirp, got %d", want, rw.Code)
+		}
+	}
+
+	if rw := do(http.MethodPost, "/admin/decisions", adminToken, `{"action":"suspend","user_id":1,"duration":"1h"}`); rw.Code != http.StatusCreated {
+		t.Fatalf("Expected the user to be suspended, got %d: %s", rw.Code, rw.Body.String())
+	}
+	if rw := do(http.MethodGet, "/api/timeline", authorToken, ""); rw.Code != http.StatusForbidden {
+		t.Errorf("Expected the token of a suspended user to be rejected, got %d", rw.Code)
+	}
+	if rw := do(http.MethodPost, "/api/users/me/suspension/appeal", authorToken, `{"message":"sorry"}`); rw.Code != http.StatusCreated {
+		t.Errorf("Expected the appeal to be recorded, got %d: %s", rw.Code, rw.Body.String())
+	}
+	rw = do(http.MethodGet, "/api/users/me/suspension", authorToken, "")
+	var suspension report.SuspensionResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &suspension); err != nil {
+		t.Fatal(err)
+	}
+	if !suspension.Active || suspension.Appeal == nil || suspension.Appeal.Status != db.AppealPending {
+		t.Errorf("Expected an active suspension with a pending appeal, got %+v", suspension)
+	}
+}
+
+func TestBlockAndMute(t *testing.T) {
+	mockDB := NewMockDB()
+	mockDB.Chirps = []db.Chirp{{ID: 1, AuthorID: 1, Body: "one"}, {ID: 2, AuthorID: 2, Body: "two"}, {ID: 3, AuthorID: 3, Body: "three"}}
+	mockDB.Follows = []db.Follow{{ID: 1, FollowerID: 1, FolloweeID: 2}, {ID: 2, FollowerID: 1, FolloweeID: 3}}
+	mockDB.Likes = []db.Like{{ID: 1, UserID: 2, ChirpID: 3}, {ID: 2, UserID: 3, ChirpID: 3}}
+	tokenManager := token.NewManager("mysecret", "")
+	router := NewRouter(mockDB, tokenManager)
+
+	blockerToken, _ := tokenManager.CreateAccessToken(1)
+	blockedToken, _ := tokenManager.CreateAccessToken(2)
+	do := func(method, path, accessToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		rw := httptest.NewRecorder()
+		router.ServeHTTP(rw, req)
+		return rw
+	}
+	chirpIDs := func(rw *httptest.ResponseRecorder) []int {
+		var page chirp.ChirpPageResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for _, c := range page.Chirps {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	if rw := do(http.MethodPut, "/api/users/1/block", blockerToken); rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a user not to block themselves, got %d", rw.Code)
+	}
+	if rw := do(http.MethodPut, "/api/users/2/block", blockerToken); rw.Code != http.StatusOK {
+		t.Fatalf("Expected the user to be blocked, got %d: %s", rw.Code, rw.Body.String())
+	}
+	if rw := do(http.MethodPut, "/api/users/3/mute", blockerToken); rw.Code != http.StatusOK {
+		t.Fatalf("Expected the user to be muted, got %d: %s", rw.Code, rw.Body.String())
+	}
+
+	// the block hides the chirps both ways and prevents the follows.
+	if rw := do(http.MethodGet, "/api/chirps/1", blockedToken); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected the chirp of the blocker to be hidden from the blocked user, got %d", rw.Code)
+	}
+	if rw := do(http.MethodGet, "/api/chirps/2", blockerToken); rw.Code != http.StatusNotFound {
+		t.Errorf("Expected the chirp of the blocked user to be hidden from the blocker, got %d", rw.Code)
+	}
+	for _, path := range []string{"/api/chirps/1/history", "/api/chirps/1/likes"} {
+		if rw := do(http.MethodGet, path, blockedToken); rw.Code != http.StatusNotFound {
+			t.Errorf("Expected %s to be hidden from the blocked user, got %d", path, rw.Code)
+		}
+	}
+	rw := do(http.MethodGet, "/api/chirps/3/likes", blockerToken)
+	var likers like.LikersResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &likers); err != nil {
+		t.Fatal(err)
+	}
+	if len(likers.Users) != 0 {
+		t.Errorf("Expected the blocked and muted likers to be left out, got %+v", likers.Users)
+	}
+	if rw := do(http.MethodPut, "/api/users/1/follow", blockedToken); rw.Code != http.StatusForbidden {
+		t.Errorf("Expected the blocked user not to follow the blocker, got %d", rw.Code)
+	}
+
+	// the mute only filters the feeds of the muter.
+	if rw := do(http.MethodGet, "/api/chirps/3", blockerToken); rw.Code != http.StatusOK {
+		t.Errorf("Expected the chirp of the muted user to stay readable, got %d", rw.Code)
+	}
+	if got := chirpIDs(do(http.MethodGet, "/api/timeline", blockerToken)); !slices.Equal(got, []int{1}) {
+		t.Errorf("Expected the timeline of the blocker to only hold their chirp, got %v", got)
+	}
+	if got := chirpIDs(do(http.MethodGet, "/api/chirps?limit=10", blockerToken)); !slices.Equal(got, []int{1}) {
+		t.Errorf("Expected the chirps of the blocked and muted users to be left out, got %v", got)
+	}
+	if got := chirpIDs(do(http.MethodGet, "/api/chirps?limit=10", blockedToken)); !slices.Equal(got, []int{2, 3}) {
+		t.Errorf("Expected the chirps of the blocker to be left out, got %v", got)
+	}
+
+	rw = do(http.MethodGet, "/api/users/me/mutes", blockerToken)
+	var mutes block.UsersResponse
+	if err := json.Unmarshal(rw.Body.Bytes(), &mutes); err != nil {
+		t.Fatal(err)
+	}
+	if len(mutes.Users) != 1 || mutes.Users[0].ID != 3 {
+		t.Errorf("Expected user 3 to be muted, got %+v", mutes.Users)
+	}
+}
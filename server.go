@@ -8,12 +8,21 @@ import (
 	"net/http"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+// shutdownTimeout is how long the server waits for the active requests to end when it shuts down.
+const shutdownTimeout = 10 * time.Second
+
 type WebServer struct {
 	*http.Server
+	jobs []Job
 }
 
+// Job is a background task running for the lifetime of the server.
+// It must return when the context is canceled.
+type Job func(ctx context.Context)
+
 func NewWebServer(addr string, handler http.Handler) *WebServer {
 	return &WebServer{
 		Server: &http.Server{
@@ -24,10 +33,20 @@ func NewWebServer(addr string, handler http.Handler) *WebServer {
 
 }
 
+// AddJob registers a background job started with the server.
+func (s *WebServer) AddJob(job Job) *WebServer {
+	s.jobs = append(s.jobs, job)
+	return s
+}
+
 func (s *WebServer) Start() error {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	for _, job := range s.jobs {
+		go job(ctx)
+	}
+
 	go func() {
 		log.Printf("starting server on %s\n", s.Addr)
 		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -37,7 +56,9 @@ func (s *WebServer) Start() error {
 
 	<-ctx.Done()
 	log.Println("got interruption signal")
-	if err := s.Shutdown(context.TODO()); err != nil {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
 		return fmt.Errorf("server shutdown returned an err: %w\n", err)
 	}
 